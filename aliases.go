@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// rolloverName is what rollover can increment, ie logs-000001
+var rolloverName = regexp.MustCompile(`-\d+$`)
+
+// DestAlias is the name of an alias on the destination, after --rename-alias
+func (c *Config) DestAlias(alias string) string {
+
+	if name, ok := c.Aliases[alias]; ok {
+		return name
+	}
+	return alias
+}
+
+// CreateAliases adds the aliases of the source indexes of names to their
+// destination indexes, with their filters, routing and write index flag, or
+// only the rollover aliases of --copy-ilm without --aliases. It runs once the
+// documents are copied so nothing reading through an alias sees half a copy.
+// Data streams are left out
+func (c *Config) CreateAliases(names []string) error {
+
+	var indexes []string
+	for _, name := range names {
+		if !c.isStream(name) {
+			indexes = append(indexes, name)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil
+	}
+
+	// {"index": {"aliases": {"alias": {"filter": ..., "index_routing": ...}}}}
+	found := map[string]struct {
+		Aliases map[string]map[string]interface{} `json:"aliases"`
+	}{}
+	if err := c.getJson(c.Src, "/"+strings.Join(indexes, ",")+"/_alias", &found); err != nil {
+		return err
+	}
+	sort.Strings(indexes)
+
+	// es 6.4 added write indexes, before that an alias of one index is one
+	major, minor := hostVersion(c.Dst)
+	writeIndex := major > 6 || major == 6 && minor >= 4
+
+	var actions []interface{}
+	for _, index := range indexes {
+		var aliases []string
+		for alias := range found[index].Aliases {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+
+		for _, alias := range aliases {
+			rollover := c.Rollover[index] == alias
+			if !c.CopyAliases && !rollover {
+				continue
+			}
+			add := map[string]interface{}{"index": c.DestIndex(index), "alias": c.DestAlias(alias)}
+			for key, val := range found[index].Aliases[alias] {
+				add[key] = val
+			}
+			if _, ok := add["is_write_index"]; ok && !writeIndex {
+				Printf("%s: alias %s loses its write index flag, es before 6.4 has none\n", index, alias)
+				delete(add, "is_write_index")
+			}
+
+			// rollover names the next index by the number at the end of this one
+			if (rollover || add["is_write_index"] == true) && !rolloverName.MatchString(c.DestIndex(index)) {
+				Printf("%s: rollover of alias %s can't name the index after %s, it doesn't end in -<number>\n",
+					index, c.DestAlias(alias), c.DestIndex(index))
+			}
+			actions = append(actions, map[string]interface{}{"add": add})
+		}
+	}
+	if len(actions) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+	resp, err := c.Dst.Request("POST", "/_aliases", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed creating aliases: %s", string(b))
+	}
+	Printf("created %d aliases\n", len(actions))
+	return nil
+}
+
+func (c *Config) isStream(name string) bool {
+
+	for _, stream := range c.Streams {
+		if stream.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// analysisPlugins are the analysis components of the official plugins, by
+// the name settings and mappings refer to them with
+var analysisPlugins = map[string]string{
+	"icu_analyzer":            "analysis-icu",
+	"icu_normalizer":          "analysis-icu",
+	"icu_folding":             "analysis-icu",
+	"icu_tokenizer":           "analysis-icu",
+	"icu_collation":           "analysis-icu",
+	"icu_transform":           "analysis-icu",
+	"icu_collation_keyword":   "analysis-icu",
+	"kuromoji":                "analysis-kuromoji",
+	"kuromoji_tokenizer":      "analysis-kuromoji",
+	"kuromoji_baseform":       "analysis-kuromoji",
+	"kuromoji_part_of_speech": "analysis-kuromoji",
+	"kuromoji_readingform":    "analysis-kuromoji",
+	"kuromoji_stemmer":        "analysis-kuromoji",
+	"kuromoji_number":         "analysis-kuromoji",
+	"kuromoji_iteration_mark": "analysis-kuromoji",
+	"kuromoji_completion":     "analysis-kuromoji",
+	"ja_stop":                 "analysis-kuromoji",
+	"nori":                    "analysis-nori",
+	"nori_tokenizer":          "analysis-nori",
+	"nori_part_of_speech":     "analysis-nori",
+	"nori_readingform":        "analysis-nori",
+	"nori_number":             "analysis-nori",
+	"phonetic":                "analysis-phonetic",
+	"smartcn":                 "analysis-smartcn",
+	"smartcn_tokenizer":       "analysis-smartcn",
+	"polish":                  "analysis-stempel",
+	"polish_stem":             "analysis-stempel",
+	"ukrainian":               "analysis-ukrainian",
+}
+
+// CheckAnalysisPlugins finds the analyzers, tokenizers and filters of
+// plugins the settings and mappings of idxs use, and fails when nodes of the
+// destination don't have those plugins, rather than creating the index
+// failing with an unknown type
+func (c *Config) CheckAnalysisPlugins(idxs Indexes) error {
+
+	// plugin to the indexes and components using it
+	used := map[string]map[string][]string{}
+	for name, index := range idxs {
+		idx, _ := index.(map[string]interface{})
+		for _, component := range pluginComponents(idx) {
+			plugin := analysisPlugins[component]
+			if used[plugin] == nil {
+				used[plugin] = map[string][]string{}
+			}
+			used[plugin][name] = append(used[plugin][name], component)
+		}
+	}
+	if len(used) == 0 {
+		return nil
+	}
+
+	nodes := struct {
+		Nodes map[string]struct {
+			Name    string `json:"name"`
+			Plugins []struct {
+				Name string `json:"name"`
+			} `json:"plugins"`
+			Modules []struct {
+				Name string `json:"name"`
+			} `json:"modules"`
+		} `json:"nodes"`
+	}{}
+	if err := getJSON(c.Dst, "/_nodes/plugins", &nodes); err != nil {
+		Println("can't check the analysis plugins of the destination:", err)
+		return nil
+	}
+
+	var plugins []string
+	for plugin := range used {
+		plugins = append(plugins, plugin)
+	}
+	sort.Strings(plugins)
+
+	var missing []string
+	for _, plugin := range plugins {
+		var without []string
+		for _, node := range nodes.Nodes {
+			found := false
+			for _, p := range append(node.Plugins, node.Modules...) {
+				found = found || p.Name == plugin
+			}
+			if !found {
+				without = append(without, node.Name)
+			}
+		}
+		if len(without) == 0 {
+			continue
+		}
+		sort.Strings(without)
+
+		var indexes []string
+		for name, components := range used[plugin] {
+			indexes = append(indexes, fmt.Sprintf("%s (%s)", name, strings.Join(components, ", ")))
+		}
+		sort.Strings(indexes)
+		Printf("%s is used by %s but missing on destination nodes %s\n", plugin, strings.Join(indexes, ", "), strings.Join(without, ", "))
+		missing = append(missing, plugin)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("install %s on every destination node", strings.Join(missing, ", "))
+}
+
+// pluginComponents are the plugin analysis components an index uses, as
+// the type, tokenizer or filters in its analysis settings, or analyzers and
+// field types of its mappings. Components the index defines itself don't count
+func pluginComponents(idx map[string]interface{}) []string {
+
+	settings := map[string]interface{}{}
+	flattenSettings("", idx["settings"], settings)
+
+	defined := map[string]bool{}
+	seen := map[string]bool{}
+	var components []string
+	add := func(name string) {
+		if _, ok := analysisPlugins[name]; ok && !defined[name] && !seen[name] {
+			seen[name] = true
+			components = append(components, name)
+		}
+	}
+
+	for key := range settings {
+		parts := strings.Split(strings.TrimPrefix(key, "index."), ".")
+		if len(parts) > 3 && parts[0] == "analysis" {
+			defined[parts[2]] = true
+		}
+	}
+	for key, val := range settings {
+		parts := strings.Split(strings.TrimPrefix(key, "index."), ".")
+		if parts[0] != "analysis" {
+			continue
+		}
+		// arrays are flattened to filter.0, filter.1 by old versions
+		last := parts[len(parts)-1]
+		if _, err := strconv.Atoi(last); err == nil && len(parts) > 1 {
+			last = parts[len(parts)-2]
+		}
+		switch last {
+		case "type", "tokenizer", "filter", "char_filter":
+			switch v := val.(type) {
+			case string:
+				add(v)
+			case []interface{}:
+				for _, name := range v {
+					add(fmt.Sprint(name))
+				}
+			}
+		}
+	}
+
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, val := range v {
+				if name, ok := val.(string); ok {
+					switch key {
+					case "analyzer", "search_analyzer", "search_quote_analyzer", "type":
+						add(name)
+					}
+				}
+				walk(val)
+			}
+		case []interface{}:
+			for _, val := range v {
+				walk(val)
+			}
+		}
+	}
+	walk(idx["mappings"])
+
+	sort.Strings(components)
+	return components
+}
+
+// flattenSettings turns nested settings into index.analysis.analyzer.x keys
+func flattenSettings(prefix string, v interface{}, out map[string]interface{}) {
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		out[prefix] = v
+		return
+	}
+	for key, val := range m {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		flattenSettings(key, val, out)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+// parts are kept in memory until they are added to the archive, so unless
+// --split-size says otherwise they are kept small
+const defaultArchivePartSize = 16 << 20
+
+// IsArchive tells if location is a tar.gz archive rather than a plain dump
+func IsArchive(location string) bool {
+	location = trimQuery(location)
+	return strings.HasSuffix(location, ".tar.gz") || strings.HasSuffix(location, ".tgz")
+}
+
+// ArchiveWriter bundles the metadata and documents of every index into one
+// tar.gz. The layout is
+//
+//	<index>/mappings.json, <index>/settings.json, <index>/aliases.json
+//	<index>/00001.json, <index>/00002.json, ...
+//	manifest.json
+//
+// Metadata comes first so a restore can create indexes before loading any
+// documents, the manifest comes last once all the counts are known
+type ArchiveWriter struct {
+	c         *Config
+	w         io.WriteCloser
+	tar       *tar.Writer
+	format    string
+	partSize  int
+	splitDocs int
+	current   map[string]*archivePart
+	manifest  Manifest
+}
+
+type archivePart struct {
+	DocWriter
+	ManifestPart
+	buf *bytes.Buffer
+}
+
+// NewArchiveWriter creates the archive at location
+func (c *Config) NewArchiveWriter(location, format string) (*ArchiveWriter, error) {
+
+	// archives are meant to be restored
+	if format != "bulk" && format != "docs" && format != "elasticdump" {
+		return nil, fmt.Errorf("archives can only hold bulk, docs or elasticdump dumps")
+	}
+
+	w, err := c.OpenRawOutput(location)
+	if err != nil {
+		return nil, err
+	}
+	w = Compress(w, location)
+
+	partSize := defaultArchivePartSize
+	if c.SplitSize > 0 {
+		partSize = c.SplitSize << 20
+	}
+
+	return &ArchiveWriter{
+		c:         c,
+		w:         w,
+		tar:       tar.NewWriter(w),
+		format:    format,
+		partSize:  partSize,
+		splitDocs: c.SplitDocs,
+		current:   map[string]*archivePart{},
+		manifest:  Manifest{Format: format},
+	}, nil
+}
+
+// WriteMetadata adds the mappings, settings and aliases of every index. It
+// has to be called before any documents are written
+func (a *ArchiveWriter) WriteMetadata(c *Config, idxs *Indexes) error {
+
+	for name, idx := range *idxs {
+		files, err := c.IndexMetadata(name, idx)
+		if err != nil {
+			return err
+		}
+		for kind, data := range files {
+			b, err := json.MarshalIndent(data, "", "  ")
+			if err != nil {
+				return err
+			}
+			if err := a.addFile(fmt.Sprintf("%s/%s.json", name, kind), append(b, '\n')); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *ArchiveWriter) WriteDoc(doc *Document) error {
+
+	part := a.current[doc.Index]
+	if part == nil {
+		buf := &bytes.Buffer{}
+		w, err := a.c.NewFormatWriter(nopWriteCloser{buf}, a.format)
+		if err != nil {
+			return err
+		}
+		part = &archivePart{DocWriter: w, ManifestPart: ManifestPart{Index: doc.Index}, buf: buf}
+		a.current[doc.Index] = part
+	}
+
+	if err := part.WriteDoc(doc); err != nil {
+		return err
+	}
+	part.Docs++
+
+	if part.buf.Len() >= a.partSize || (a.splitDocs > 0 && part.Docs >= a.splitDocs) {
+		return a.flushPart(part)
+	}
+
+	return nil
+}
+
+// flushPart adds a part to the archive and starts the index on a new one
+func (a *ArchiveWriter) flushPart(part *archivePart) error {
+
+	number := 1
+	for _, p := range a.manifest.Parts {
+		if p.Index == part.Index {
+			number++
+		}
+	}
+	part.File = fmt.Sprintf("%s/%05d.json", part.Index, number)
+	part.Bytes = int64(part.buf.Len())
+	part.Sha256 = hashHex(part.buf.Bytes())
+
+	if err := a.addFile(part.File, part.buf.Bytes()); err != nil {
+		return err
+	}
+	a.manifest.Parts = append(a.manifest.Parts, part.ManifestPart)
+	delete(a.current, part.Index)
+
+	return nil
+}
+
+func (a *ArchiveWriter) addFile(name string, data []byte) error {
+
+	err := a.tar.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = a.tar.Write(data)
+	return err
+}
+
+// Close adds whatever is left of each index and the manifest
+func (a *ArchiveWriter) Close() error {
+
+	for _, part := range a.current {
+		if err := a.flushPart(part); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.MarshalIndent(a.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := a.addFile("manifest.json", append(b, '\n')); err != nil {
+		return err
+	}
+
+	if err := a.tar.Close(); err != nil {
+		a.w.Close()
+		return err
+	}
+	return a.w.Close()
+}
+
+// ArchiveReader restores an archive written by ArchiveWriter. The metadata is
+// read when it is opened, documents as the archive is streamed
+type ArchiveReader struct {
+	r       io.ReadCloser
+	tar     *tar.Reader
+	next    *tar.Header // first entry after the metadata
+	Indexes Indexes
+}
+
+// NewArchiveReader opens the archive at location and reads the metadata of
+// every index in it
+func (c *Config) NewArchiveReader(location string) (*ArchiveReader, error) {
+
+	r, err := c.OpenInput(location)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &ArchiveReader{r: r, tar: tar.NewReader(r), Indexes: Indexes{}}
+	for {
+		header, err := a.tar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed reading %s: %s", location, err)
+		}
+
+		index, file := path.Split(header.Name)
+		kind := strings.TrimSuffix(file, ".json")
+		if index == "" || (kind != "mappings" && kind != "settings" && kind != "aliases") {
+			a.next = header
+			break
+		}
+
+		var data interface{}
+		if err := json.NewDecoder(a.tar).Decode(&data); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed reading %s: %s", header.Name, err)
+		}
+
+		index = strings.TrimSuffix(index, "/")
+		if _, ok := a.Indexes[index]; !ok {
+			a.Indexes[index] = map[string]interface{}{}
+		}
+		if data != nil {
+			a.Indexes[index].(map[string]interface{})[kind] = data
+		}
+	}
+
+	for _, idx := range a.Indexes {
+		cleanSettings(idx.(map[string]interface{}))
+	}
+
+	return a, nil
+}
+
+// Next reads the rest of the archive, done is always set
+func (a *ArchiveReader) Next(c *Config) (done bool) {
+
+	defer a.r.Close()
+
+	for header := a.next; header != nil && !c.Stopped(); {
+		if header.Name != "manifest.json" {
+			part := &DocReader{r: ioutil.NopCloser(a.tar), dec: json.NewDecoder(bufio.NewReader(a.tar))}
+			for !c.Limit.Reached() && !c.Stopped() && part.Next(c) == false {
+			}
+		}
+
+		var err error
+		if header, err = a.tar.Next(); err != nil {
+			if err != io.EOF {
+				c.Errors.Add(sourceErrors, err)
+			}
+			break
+		}
+	}
+
+	return true
+}
+
+// VerifyArchive reads the whole archive and checks every part against the
+// manifest at its end
+func (c *Config) VerifyArchive(location string) error {
+
+	r, err := c.OpenInput(location)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	type sum struct {
+		n   int64
+		sum string
+	}
+	sums := map[string]sum{}
+	var manifest *Manifest
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed verifying %s, the archive is truncated or corrupted: %s", location, err)
+		}
+
+		if header.Name == "manifest.json" {
+			manifest = &Manifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return fmt.Errorf("failed reading the manifest of %s: %s", location, err)
+			}
+			continue
+		}
+
+		h := sha256.New()
+		n, err := io.Copy(h, tr)
+		if err != nil {
+			return fmt.Errorf("failed verifying %s, the archive is truncated or corrupted: %s", location, err)
+		}
+		sums[header.Name] = sum{n, hex.EncodeToString(h.Sum(nil))}
+	}
+
+	if manifest == nil {
+		return fmt.Errorf("%s has no manifest, the archive is truncated", location)
+	}
+	for _, part := range manifest.Parts {
+		s, ok := sums[part.File]
+		if !ok {
+			return fmt.Errorf("%s is missing from %s", part.File, location)
+		}
+		if err := part.check(location+":"+part.File, s.n, s.sum); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nopWriteCloser turns a writer into a WriteCloser that does nothing on close
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+)
+
+// documents buffered before they are compressed and written as a block
+const avroBlockDocs = 1000
+
+// AvroField is a field of the avro schema of a dump, objects in the mappings
+// become nested records
+type AvroField struct {
+	Name     string       // avro name, only letters, digits and _
+	Source   string       // name of the field in _source
+	Type     string       // string, long, double, boolean or record
+	Fields   []*AvroField // fields of a record
+	Required bool         // never null, only _index and _id
+}
+
+var avroInvalid = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// AvroSchemaFor builds a Document record with _index, _id and every field in
+// the mappings of idxs. Fields mapped with different types in different
+// indexes become strings
+func AvroSchemaFor(idxs Indexes) (*AvroField, error) {
+
+	root := &AvroField{Name: "Document", Type: "record"}
+	for _, idx := range idxs {
+		mappings, _ := idx.(map[string]interface{})["mappings"].(map[string]interface{})
+		if _, ok := mappings["properties"]; ok {
+			root.addMapping(mappings)
+			continue
+		}
+		// older versions nest mappings under the document type
+		for _, typ := range mappings {
+			if typ, ok := typ.(map[string]interface{}); ok {
+				root.addMapping(typ)
+			}
+		}
+	}
+	if len(root.Fields) == 0 {
+		return nil, fmt.Errorf("no mappings to build an avro schema from")
+	}
+
+	root.sort()
+	root.uniqueNames("", map[string]bool{"_index": true, "_id": true})
+	root.Fields = append([]*AvroField{
+		{Name: "_index", Source: "_index", Type: "string", Required: true},
+		{Name: "_id", Source: "_id", Type: "string", Required: true},
+	}, root.Fields...)
+
+	return root, nil
+}
+
+func (f *AvroField) addMapping(mapping map[string]interface{}) {
+
+	properties, _ := mapping["properties"].(map[string]interface{})
+	for name, field := range properties {
+		field, ok := field.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		typ, _ := field["type"].(string)
+
+		t, ok := columnTypes[typ]
+		if !ok {
+			t = "string"
+		}
+		if _, ok := field["properties"]; ok && (typ == "" || typ == "object") {
+			t = "record"
+		}
+
+		child := f.field(name)
+		if child == nil {
+			avroName := avroInvalid.ReplaceAllString(name, "_")
+			if avroName[0] >= '0' && avroName[0] <= '9' {
+				avroName = "_" + avroName
+			}
+			child = &AvroField{Name: avroName, Source: name, Type: t}
+			f.Fields = append(f.Fields, child)
+		} else if child.Type != t {
+			child.Type, child.Fields = "string", nil
+		}
+
+		if child.Type == "record" {
+			child.addMapping(field)
+		}
+	}
+}
+
+// field finds a child by its name in _source
+func (f *AvroField) field(source string) *AvroField {
+
+	for _, child := range f.Fields {
+		if child.Source == source {
+			return child
+		}
+	}
+	return nil
+}
+
+func (f *AvroField) sort() {
+
+	sort.Slice(f.Fields, func(i, j int) bool { return f.Fields[i].Source < f.Fields[j].Source })
+	for _, child := range f.Fields {
+		child.sort()
+	}
+}
+
+// uniqueNames numbers fields whose names are the same once sanitized, ie
+// a-b and a_b, avro readers refuse a record with a field twice
+func (f *AvroField) uniqueNames(path string, taken map[string]bool) {
+
+	for _, child := range f.Fields {
+		name := child.Name
+		for n := 2; taken[name]; n++ {
+			name = fmt.Sprintf("%s_%d", child.Name, n)
+		}
+		if name != child.Name {
+			Printf("avro field %s%s is named %s, %s is taken\n", path, child.Source, name, child.Name)
+		}
+		child.Name = name
+		taken[name] = true
+		child.uniqueNames(path+child.Source+".", map[string]bool{})
+	}
+}
+
+// schema is the avro json schema of the field type, path names records
+// since they have to be unique across the schema
+func (f *AvroField) schema(path string) interface{} {
+
+	if f.Type != "record" {
+		return f.Type
+	}
+
+	fields := []interface{}{}
+	for _, child := range f.Fields {
+		field := map[string]interface{}{"name": child.Name, "type": child.Type}
+		if !child.Required {
+			field["type"] = []interface{}{"null", child.schema(path + "_" + child.Name)}
+			field["default"] = nil
+		}
+		fields = append(fields, field)
+	}
+
+	return map[string]interface{}{"type": "record", "name": path, "fields": fields}
+}
+
+// AvroWriter writes documents as an avro object container file, compressed
+// with deflate one block at a time
+type AvroWriter struct {
+	w      io.WriteCloser
+	record *AvroField
+	sync   []byte
+	block  bytes.Buffer
+	docs   int64
+}
+
+// NewAvroWriter writes the file header with the schema of record to w
+func NewAvroWriter(w io.WriteCloser, record *AvroField) (*AvroWriter, error) {
+
+	if record == nil {
+		w.Close()
+		return nil, fmt.Errorf("avro dumps need a schema")
+	}
+
+	schema, err := json.Marshal(record.schema(record.Name))
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	a := &AvroWriter{w: w, record: record, sync: make([]byte, 16)}
+	if _, err := rand.Read(a.sync); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	header := &bytes.Buffer{}
+	header.WriteString("Obj\x01")
+	avroLong(header, 2)
+	avroString(header, "avro.schema")
+	avroLong(header, int64(len(schema)))
+	header.Write(schema)
+	avroString(header, "avro.codec")
+	avroString(header, "deflate")
+	avroLong(header, 0)
+	header.Write(a.sync)
+
+	if _, err := w.Write(header.Bytes()); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *AvroWriter) WriteDoc(doc *Document) error {
+
+	source := map[string]interface{}{"_index": doc.Index, "_id": doc.Id}
+	for k, v := range doc.source {
+		source[k] = v
+	}
+	a.record.encode(&a.block, source)
+	a.docs++
+
+	if a.docs >= avroBlockDocs {
+		return a.flushBlock()
+	}
+	return nil
+}
+
+// encode writes the fields of a record from the matching fields of source
+func (f *AvroField) encode(b *bytes.Buffer, source map[string]interface{}) {
+
+	for _, child := range f.Fields {
+		v := source[child.Source]
+
+		if child.Required {
+			avroString(b, v.(string))
+			continue
+		}
+
+		if child.Type == "record" {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				avroLong(b, 0)
+				continue
+			}
+			avroLong(b, 1)
+			child.encode(b, obj)
+			continue
+		}
+
+		// the union is null first and the value second
+		v = columnValue(child.Type, v)
+		if v == nil {
+			avroLong(b, 0)
+			continue
+		}
+		avroLong(b, 1)
+		switch v := v.(type) {
+		case string:
+			avroString(b, v)
+		case int64:
+			avroLong(b, v)
+		case float64:
+			binary.Write(b, binary.LittleEndian, math.Float64bits(v))
+		case bool:
+			if v {
+				b.WriteByte(1)
+			} else {
+				b.WriteByte(0)
+			}
+		}
+	}
+}
+
+// flushBlock writes the buffered documents as a single deflated block
+func (a *AvroWriter) flushBlock() error {
+
+	if a.docs == 0 {
+		return nil
+	}
+
+	compressed := &bytes.Buffer{}
+	fw, _ := flate.NewWriter(compressed, flate.DefaultCompression)
+	fw.Write(a.block.Bytes())
+	fw.Close()
+
+	block := &bytes.Buffer{}
+	avroLong(block, a.docs)
+	avroLong(block, int64(compressed.Len()))
+	block.Write(compressed.Bytes())
+	block.Write(a.sync)
+
+	a.block.Reset()
+	a.docs = 0
+
+	_, err := a.w.Write(block.Bytes())
+	return err
+}
+
+func (a *AvroWriter) Close() error {
+
+	if err := a.flushBlock(); err != nil {
+		a.w.Close()
+		return err
+	}
+	return a.w.Close()
+}
+
+func avroLong(b *bytes.Buffer, v int64) {
+	putUvarint(b, zigzag(v))
+}
+
+func avroString(b *bytes.Buffer, s string) {
+	avroLong(b, int64(len(s)))
+	b.WriteString(s)
+}
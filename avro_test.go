@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// avroReader decodes an object container file with the schema in its
+// header, so the schema is checked against the encoding as well
+type avroReader struct {
+	b []byte
+	i int
+}
+
+func (r *avroReader) long() int64 {
+	u, n := binary.Uvarint(r.b[r.i:])
+	r.i += n
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (r *avroReader) bytes() []byte {
+	n := int(r.long())
+	r.i += n
+	return r.b[r.i-n : r.i]
+}
+
+func (r *avroReader) value(schema interface{}) interface{} {
+
+	switch s := schema.(type) {
+	case string:
+		switch s {
+		case "null":
+			return nil
+		case "string":
+			return string(r.bytes())
+		case "long":
+			return r.long()
+		case "double":
+			r.i += 8
+			return math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.i-8:]))
+		case "boolean":
+			r.i++
+			return r.b[r.i-1] == 1
+		}
+	case []interface{}:
+		return r.value(s[r.long()])
+	case map[string]interface{}:
+		record := map[string]interface{}{}
+		for _, field := range s["fields"].([]interface{}) {
+			field := field.(map[string]interface{})
+			record[field["name"].(string)] = r.value(field["type"])
+		}
+		return record
+	}
+	panic("unexpected avro schema")
+}
+
+func readAvro(t *testing.T, b []byte) (schema map[string]interface{}, records []interface{}, blocks int) {
+
+	if !bytes.HasPrefix(b, []byte("Obj\x01")) {
+		t.Fatal("missing avro magic")
+	}
+	r := &avroReader{b: b, i: 4}
+	meta := map[string]string{}
+	for n := r.long(); n != 0; n = r.long() {
+		for ; n > 0; n-- {
+			k := string(r.bytes())
+			meta[k] = string(r.bytes())
+		}
+	}
+	if meta["avro.codec"] != "deflate" {
+		t.Errorf("codec is %s", meta["avro.codec"])
+	}
+	if err := json.Unmarshal([]byte(meta["avro.schema"]), &schema); err != nil {
+		t.Fatal(err)
+	}
+	sync := b[r.i : r.i+16]
+	r.i += 16
+
+	for r.i < len(b) {
+		count := r.long()
+		compressed := r.bytes()
+		if !bytes.Equal(b[r.i:r.i+16], sync) {
+			t.Fatal("bad sync marker after block")
+		}
+		r.i += 16
+		blocks++
+
+		plain, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := &avroReader{b: plain}
+		for ; count > 0; count-- {
+			records = append(records, block.value(schema))
+		}
+		if block.i != len(plain) {
+			t.Errorf("%d bytes left over in a block", len(plain)-block.i)
+		}
+	}
+
+	return schema, records, blocks
+}
+
+func avroIndexes(t *testing.T, mappings ...string) Indexes {
+
+	idxs := Indexes{}
+	for i, mapping := range mappings {
+		var m interface{}
+		if err := json.Unmarshal([]byte(mapping), &m); err != nil {
+			t.Fatal(err)
+		}
+		idxs[string(rune('a'+i))] = map[string]interface{}{"mappings": m}
+	}
+	return idxs
+}
+
+func TestAvroSchemaFor(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		mappings []string
+		want     string
+	}{
+		{
+			"types",
+			[]string{`{"properties":{"n":{"type":"integer"},"x":{"type":"float"},"ok":{"type":"boolean"},"s":{"type":"keyword"}}}`},
+			`{"fields":[{"name":"_index","type":"string"},{"name":"_id","type":"string"},` +
+				`{"default":null,"name":"n","type":["null","long"]},{"default":null,"name":"ok","type":["null","boolean"]},` +
+				`{"default":null,"name":"s","type":["null","string"]},{"default":null,"name":"x","type":["null","double"]}],` +
+				`"name":"Document","type":"record"}`,
+		},
+		{
+			"objects and old style mappings",
+			[]string{`{"doc":{"properties":{"user":{"properties":{"id":{"type":"long"}}}}}}`},
+			`{"fields":[{"name":"_index","type":"string"},{"name":"_id","type":"string"},` +
+				`{"default":null,"name":"user","type":["null",{"fields":[{"default":null,"name":"id","type":["null","long"]}],"name":"Document_user","type":"record"}]}],` +
+				`"name":"Document","type":"record"}`,
+		},
+		{
+			"conflicting types",
+			[]string{`{"properties":{"a":{"type":"long"}}}`, `{"properties":{"a":{"type":"text"}}}`},
+			`{"fields":[{"name":"_index","type":"string"},{"name":"_id","type":"string"},` +
+				`{"default":null,"name":"a","type":["null","string"]}],"name":"Document","type":"record"}`,
+		},
+		{
+			"invalid and colliding names",
+			[]string{`{"properties":{"a-b":{"type":"long"},"a_b":{"type":"long"},"a b":{"type":"long"},"1st":{"type":"long"},"_id":{"type":"long"}}}`},
+			`{"fields":[{"name":"_index","type":"string"},{"name":"_id","type":"string"},` +
+				`{"default":null,"name":"_1st","type":["null","long"]},{"default":null,"name":"_id_2","type":["null","long"]},` +
+				`{"default":null,"name":"a_b","type":["null","long"]},{"default":null,"name":"a_b_2","type":["null","long"]},` +
+				`{"default":null,"name":"a_b_3","type":["null","long"]}],"name":"Document","type":"record"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		record, err := AvroSchemaFor(avroIndexes(t, tt.mappings...))
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		got, _ := json.Marshal(record.schema(record.Name))
+		if string(got) != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := AvroSchemaFor(avroIndexes(t, `{}`)); err == nil {
+		t.Error("expected an error without mappings")
+	}
+}
+
+func TestAvroRoundTrip(t *testing.T) {
+
+	record, err := AvroSchemaFor(avroIndexes(t, `{"properties":{
+		"name":{"type":"keyword"},
+		"n":{"type":"long"},
+		"x":{"type":"double"},
+		"ok":{"type":"boolean"},
+		"a-b":{"type":"keyword"},
+		"a_b":{"type":"keyword"},
+		"user":{"properties":{"id":{"type":"keyword"},"age":{"type":"integer"}}}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id     string
+		source string
+		want   map[string]interface{}
+	}{
+		{"1", `{"name":"a","n":1,"x":1.5,"ok":true,"a-b":"dash","a_b":"under","user":{"id":"u1","age":30}}`,
+			map[string]interface{}{"name": "a", "n": int64(1), "x": 1.5, "ok": true, "a_b": "dash", "a_b_2": "under",
+				"user": map[string]interface{}{"id": "u1", "age": int64(30)}}},
+		{"2", `{}`,
+			map[string]interface{}{"name": nil, "n": nil, "x": nil, "ok": nil, "a_b": nil, "a_b_2": nil, "user": nil}},
+		{"3", `{"name":["x"],"n":"-5","x":"2","ok":"false","user":"flat"}`,
+			map[string]interface{}{"name": `["x"]`, "n": int64(-5), "x": 2.0, "ok": false, "a_b": nil, "a_b_2": nil, "user": nil}},
+		{"4", `{"n":1.5,"x":true,"user":{}}`,
+			map[string]interface{}{"name": nil, "n": nil, "x": nil, "ok": nil, "a_b": nil, "a_b_2": nil,
+				"user": map[string]interface{}{"id": nil, "age": nil}}},
+		{"日本", `{"name":"ünï","n":-9007199254740991}`,
+			map[string]interface{}{"name": "ünï", "n": int64(-9007199254740991), "x": nil, "ok": nil, "a_b": nil, "a_b_2": nil, "user": nil}},
+	}
+
+	var out bytes.Buffer
+	w, err := NewAvroWriter(nopWriteCloser{&out}, record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		doc := &Document{Index: "idx", Id: tt.id}
+		if err := json.Unmarshal([]byte(tt.source), &doc.source); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.WriteDoc(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, records, blocks := readAvro(t, out.Bytes())
+	if blocks != 1 || len(records) != len(tests) {
+		t.Fatalf("got %d records in %d blocks", len(records), blocks)
+	}
+	for i, tt := range tests {
+		tt.want["_index"], tt.want["_id"] = "idx", tt.id
+		if !reflect.DeepEqual(records[i], tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.source, records[i], tt.want)
+		}
+	}
+}
+
+func TestAvroBlocks(t *testing.T) {
+
+	record, _ := AvroSchemaFor(avroIndexes(t, `{"properties":{"n":{"type":"long"}}}`))
+
+	tests := []struct {
+		docs   int
+		blocks int
+	}{
+		{0, 0},
+		{1, 1},
+		{avroBlockDocs, 1},
+		{avroBlockDocs + 1, 2},
+		{3*avroBlockDocs + 7, 4},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w, _ := NewAvroWriter(nopWriteCloser{&out}, record)
+		for i := 0; i < tt.docs; i++ {
+			w.WriteDoc(&Document{Index: "idx", Id: "x", source: map[string]interface{}{"n": float64(i)}})
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		_, records, blocks := readAvro(t, out.Bytes())
+		if blocks != tt.blocks || len(records) != tt.docs {
+			t.Errorf("%d docs: got %d records in %d blocks, want %d blocks", tt.docs, len(records), blocks, tt.blocks)
+			continue
+		}
+		for i, r := range records {
+			if r.(map[string]interface{})["n"] != int64(i) {
+				t.Errorf("%d docs: record %d is %v", tt.docs, i, r)
+				break
+			}
+		}
+	}
+}
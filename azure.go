@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// size of each block in a block blob upload
+const azureBlockSize = 16 << 20
+
+const azureApiVersion = "2020-10-02"
+
+// AzureBlob is a blob in an azure storage container
+type AzureBlob struct {
+	Url    string // https://account.blob.core.windows.net/container/blob
+	Sas    string // shared access signature query string
+	Client *http.Client
+}
+
+// NewAzureBlob parses azblob://account/container/blob. Access is through a
+// sas token in AZURE_STORAGE_SAS_TOKEN, AZURE_STORAGE_ENDPOINT overrides the
+// endpoint for azurite and sovereign clouds
+func (c *Config) NewAzureBlob(location string) (*AzureBlob, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	account := u.Host
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if account == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("bad azure location %s, should be azblob://account/container/blob", location)
+	}
+
+	sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+	if sas == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_SAS_TOKEN is not set")
+	}
+	masker.Add(sas)
+
+	endpoint := fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	if custom := os.Getenv("AZURE_STORAGE_ENDPOINT"); custom != "" {
+		endpoint = strings.TrimRight(custom, "/")
+	}
+
+	return &AzureBlob{
+		Url:    fmt.Sprintf("%s/%s/%s", endpoint, parts[0], awsEscape(parts[1])),
+		Sas:    sas,
+		Client: c.Client,
+	}, nil
+}
+
+// Request makes a request against the blob, query is added to the sas
+func (b *AzureBlob) Request(method, query string, body []byte, headers map[string]string) (*http.Response, error) {
+
+	u := b.Url + "?" + b.Sas
+	if query != "" {
+		u += "&" + query
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("x-ms-version", azureApiVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("azure %s %s failed: %s %s", method, b.Url, resp.Status, string(msg))
+	}
+
+	return resp, nil
+}
+
+// NewReader streams the blob
+func (b *AzureBlob) NewReader() (io.ReadCloser, error) {
+
+	resp, err := b.Request("GET", "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// NewWriter uploads everything written to it as a block blob
+func (b *AzureBlob) NewWriter() io.WriteCloser {
+	return &AzureWriter{blob: b}
+}
+
+// AzureWriter buffers a block at a time and uploads it, committing the list
+// of blocks on close
+type AzureWriter struct {
+	blob   *AzureBlob
+	buf    bytes.Buffer
+	blocks []string
+}
+
+func (w *AzureWriter) Write(p []byte) (int, error) {
+
+	n, _ := w.buf.Write(p)
+	for w.buf.Len() >= azureBlockSize {
+		if err := w.putBlock(w.buf.Next(azureBlockSize)); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (w *AzureWriter) putBlock(block []byte) error {
+
+	// block ids have to be the same length for the whole blob
+	id := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("block-%08d", len(w.blocks))))
+	resp, err := w.blob.Request("PUT", "comp=block&blockid="+url.QueryEscape(id), block, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	w.blocks = append(w.blocks, id)
+
+	return nil
+}
+
+func (w *AzureWriter) Close() error {
+
+	// small enough for a single put
+	if len(w.blocks) == 0 {
+		resp, err := w.blob.Request("PUT", "", w.buf.Bytes(), map[string]string{"x-ms-blob-type": "BlockBlob"})
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if w.buf.Len() > 0 {
+		if err := w.putBlock(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	list, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{Latest: w.blocks})
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.blob.Request("PUT", "comp=blocklist", list, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxBulkSize = 100000000 // es takes bulk requests up to 100mb
+	minBulkSize = 1 << 20
+	maxBackoff  = time.Minute
+)
+
+// Backoff shrinks bulk requests for every worker while the destination
+// rejects documents because it is busy. They halve with every rejection and
+// grow back by a quarter with every request es took without complaint
+type Backoff struct {
+	sync.Mutex
+	size int
+}
+
+func NewBackoff() *Backoff {
+	return &Backoff{size: maxBulkSize}
+}
+
+// Size is the most bytes a bulk request should have right now
+func (b *Backoff) Size() int {
+
+	b.Lock()
+	defer b.Unlock()
+	return b.size
+}
+
+func (b *Backoff) Rejected() {
+
+	b.Lock()
+	defer b.Unlock()
+	if b.size /= 2; b.size < minBulkSize {
+		b.size = minBulkSize
+	}
+}
+
+func (b *Backoff) Accepted() {
+
+	b.Lock()
+	defer b.Unlock()
+	if b.size += b.size / 4; b.size > maxBulkSize {
+		b.size = maxBulkSize
+	}
+}
+
+// backoffDelay is the pause before sending rejected documents again,
+// doubling from a second with every attempt up to a minute, with some jitter
+// so workers don't come back at once. es asking for a longer pause wins
+func backoffDelay(attempt int, retryAfter time.Duration) time.Duration {
+
+	delay := maxBackoff
+	if attempt < 7 {
+		delay = time.Second << uint(attempt-1)
+	}
+	delay += time.Duration(rand.Int63n(int64(delay) / 4))
+	if retryAfter > delay {
+		return retryAfter
+	}
+	return delay
+}
+
+// retryAfter reads a Retry-After header, either seconds or a date
+func retryAfter(header string) time.Duration {
+
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(header)); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(header); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// splitBulk cuts action and source lines into requests of about size bytes,
+// documents are never split
+func splitBulk(body []byte, size int) [][]byte {
+
+	lines := bytes.SplitAfter(body, []byte("\n"))
+	var chunks [][]byte
+	start, end := 0, 0
+	for i := 0; i+1 < len(lines); i += 2 {
+		doc := len(lines[i]) + len(lines[i+1])
+		if end > start && end-start+doc > size {
+			chunks = append(chunks, body[start:end])
+			start = end
+		}
+		end += doc
+	}
+	if end > start {
+		chunks = append(chunks, body[start:end])
+	}
+	return chunks
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// bulkFilter leaves out of bulk responses what isn't read. Every item has a
+// status so none is dropped, they are matched to documents by their order
+const bulkFilter = "filter_path=errors,error,status,items.*._index,items.*._id,items.*.status,items.*.error"
+
+// BulkResponse has the result of every document of a _bulk request, in the
+// order they were sent
+type BulkResponse struct {
+	Errors bool                  `json:"errors"`
+	Items  []map[string]BulkItem `json:"items"`
+}
+
+type BulkItem struct {
+	Index  string      `json:"_index"`
+	Id     string      `json:"_id"`
+	Status int         `json:"status"`
+	Error  interface{} `json:"error"` // a string before es 5, an object later
+}
+
+// retryable tells if a document failed because es was busy rather than
+// because of the document itself. Older versions report a full queue with
+// other statuses
+func (item BulkItem) retryable() bool {
+
+	if item.Status == 429 || item.Status >= 500 {
+		return true
+	}
+	reason := fmt.Sprint(item.Error)
+	return strings.Contains(reason, "rejected_execution") || strings.Contains(reason, "RejectedExecution")
+}
+
+// postBulk sends body, action and source lines, to the destination. The
+// lines of documents that can be sent again are returned along with how long
+// es asked to wait, how many documents failed for good, those are reported
+// and counted, and how many a restarted reader sent again that were indexed
+// already. Their conflicts are skipped whatever --on-conflict says
+func (c *Config) postBulk(body []byte, restarted bool) ([]byte, time.Duration, int, int, error) {
+
+	path := "/_bulk?" + bulkFilter
+	if c.Pipeline != "" {
+		path += "&pipeline=" + url.QueryEscape(c.Pipeline)
+	}
+	resp, err := c.Dst.Request("POST", path, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	// the whole request was rejected, es is busy
+	if resp.StatusCode == 429 || resp.StatusCode == 503 {
+		return body, retryAfter(resp.Header.Get("Retry-After")), 0, 0, nil
+	}
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, 0, 0, 0, fmt.Errorf("bad bulk response: %s", string(b))
+	}
+
+	result := BulkResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, 0, 0, fmt.Errorf("failed decoding bulk response: %s", err)
+	}
+	if !result.Errors {
+		return nil, 0, 0, 0, nil
+	}
+
+	// every document is an action line and a source line
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	retry, overwrite := bytes.Buffer{}, bytes.Buffer{}
+	failed, again := 0, 0
+	for i, items := range result.Items {
+		for _, item := range items {
+			if item.Status < 300 {
+				continue
+			}
+
+			// documents created with the same id before
+			if item.Status == 409 && 2*i+1 < len(lines) {
+				switch {
+				case restarted && c.OnConflict != "overwrite":
+					again++
+					continue
+				case c.OnConflict == "skip":
+					c.Existing++
+					continue
+				case c.OnConflict == "overwrite":
+					overwrite.Write(overwriteAction(lines[2*i]))
+					overwrite.WriteByte('\n')
+					overwrite.Write(lines[2*i+1])
+					overwrite.WriteByte('\n')
+					continue
+				case c.OnConflict == "abort":
+					// the conflict is the reason to stop, not a failed document
+					c.Existing++
+					c.Abort(fmt.Errorf("%s/%s exists on the destination, stopping", item.Index, item.Id))
+					continue
+				}
+			}
+
+			if item.retryable() && 2*i+1 < len(lines) {
+				retry.Write(lines[2*i])
+				retry.WriteByte('\n')
+				retry.Write(lines[2*i+1])
+				retry.WriteByte('\n')
+				continue
+			}
+			failed++
+			c.countFailed()
+			c.Errors.AddKind(destErrors, bulkErrorType(item), fmt.Errorf("failed indexing %s/%s: %d %s", item.Index, item.Id, item.Status, bulkError(item.Error)))
+			if 2*i+1 < len(lines) {
+				c.deadLetter(lines[2*i], lines[2*i+1], item.Status, bulkError(item.Error))
+			}
+		}
+	}
+
+	// documents to replace go right away, they weren't rejected
+	wait := retryAfter(resp.Header.Get("Retry-After"))
+	if overwrite.Len() > 0 {
+		rejected, after, lost, _, err := c.postBulk(overwrite.Bytes(), restarted)
+		if err != nil {
+			c.Errors.Add(destErrors, err)
+			c.failBulk(overwrite.Bytes(), 0, err.Error())
+			lost = bulkDocs(overwrite.Bytes())
+		}
+		failed += lost
+		retry.Write(rejected)
+		if after > wait {
+			wait = after
+		}
+	}
+
+	return retry.Bytes(), wait, failed, again, nil
+}
+
+// failBulk gives up on every document in body
+func (c *Config) failBulk(body []byte, status int, reason string) {
+
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	for i := 0; i+1 < len(lines); i += 2 {
+		c.countFailed()
+		c.deadLetter(lines[i], lines[i+1], status, reason)
+	}
+}
+
+// countFailed counts a document the destination didn't index, and stops
+// reading with --abort-on-error or past --max-errors
+func (c *Config) countFailed() {
+
+	c.Failed++
+	switch {
+	case c.AbortOnError:
+		c.Abort(fmt.Errorf("a document failed, stopping"))
+	case c.MaxErrors > 0 && c.Failed > c.MaxErrors:
+		c.Abort(fmt.Errorf("more than %d documents failed, stopping", c.MaxErrors))
+	}
+}
+
+// deadLetter writes a document to --dead-letter if it was given
+func (c *Config) deadLetter(action, source []byte, status int, reason string) {
+
+	if c.Dead == nil {
+		return
+	}
+	if err := c.Dead.Write(action, source, status, reason); err != nil {
+		c.Errors.Add(outputErrors, fmt.Errorf("failed writing to %s: %s", c.Dead.Path, err))
+	}
+}
+
+// bulkErrorType is what es calls the error of a bulk item, or its status
+// for versions that only give a reason
+func bulkErrorType(item BulkItem) string {
+
+	if e, ok := item.Error.(map[string]interface{}); ok {
+		if t, ok := e["type"].(string); ok {
+			return t
+		}
+	}
+	return fmt.Sprint(item.Status)
+}
+
+// bulkError is the reason of an error of a bulk item
+func bulkError(err interface{}) string {
+
+	if e, ok := err.(map[string]interface{}); ok {
+		return fmt.Sprintf("%v: %v", e["type"], e["reason"])
+	}
+	return fmt.Sprint(err)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	pb "github.com/cheggaaa/pb"
+)
+
+// State is the progress of a copy, saved to --state as each index is done so
+// an interrupted copy can go on with --resume
+type State struct {
+	Source string         `json:"source"`
+	Done   map[string]int `json:"done"` // documents scrolled of every finished index
+	path   string
+}
+
+// LoadState reads the state at path, a fresh state if there is none yet
+func LoadState(path, source string) (*State, error) {
+
+	state := &State{Source: source, Done: map[string]int{}, path: path}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("bad state in %s: %s", path, err)
+	}
+	if state.Source != source {
+		return nil, fmt.Errorf("%s is the state of a copy from %s", path, state.Source)
+	}
+
+	return state, nil
+}
+
+// Save writes the state to a temporary file first, a crash while saving
+// leaves the last state in place
+func (s *State) Save() error {
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(s.path+".tmp", s.path)
+}
+
+// IndexScrolls scrolls one index after another, so every index is finished
+// before the next starts and can be saved as done
+type IndexScrolls struct {
+	Names  []string
+	Bar    *pb.ProgressBar // grows with the total of every index
+	scroll *Scroll
+}
+
+// NewIndexScrolls scrolls the source indexes that aren't done yet
+func (c *Config) NewIndexScrolls(names []string) *IndexScrolls {
+
+	s := &IndexScrolls{}
+	for _, name := range names {
+		if _, done := c.State.Done[name]; !done {
+			s.Names = append(s.Names, name)
+		}
+	}
+	sort.Strings(s.Names)
+
+	return s
+}
+
+func (s *IndexScrolls) Next(c *Config) (done bool) {
+
+	if len(s.Names) == 0 {
+		return true
+	}
+
+	if s.scroll == nil {
+		scroll, err := c.NewScroll(s.Names[0])
+		if err != nil {
+			c.Errors.Add(sourceErrors, err)
+			c.Partial = true
+			return true
+		}
+		s.scroll = scroll
+		if total := scroll.Hits.Total.Value; s.Bar != nil {
+			if c.Sampling > 0 {
+				total = int(float64(total) * c.Sampling)
+			}
+			s.Bar.SetTotal(int(s.Bar.Total) + total)
+		}
+	}
+
+	if !s.scroll.Next(c) {
+		return false
+	}
+	if s.scroll.Partial {
+		c.Partial = true
+		return true
+	}
+
+	// everything of the index has to be indexed before it counts as done
+	c.Checkpoint()
+	c.State.Done[s.Names[0]] = s.scroll.Count
+	if err := c.State.Save(); err != nil {
+		c.Errors.Add(outputErrors, fmt.Errorf("failed saving state: %s", err))
+		return true
+	}
+	if err := s.scroll.Clear(c); err != nil {
+		c.Errors.Add(notices, err)
+	}
+	s.Names, s.scroll = s.Names[1:], nil
+
+	return len(s.Names) == 0
+}
+
+// Checkpoint waits until every worker has sent what it has so far. Every
+// worker gets a marker and holds on to it until all of them have flushed
+func (c *Config) Checkpoint() {
+
+	release := make(chan bool)
+	c.Flushed.Add(c.Workers)
+	for i := 0; i < c.Workers; i++ {
+		c.DocChan <- map[string]interface{}{"_checkpoint": release}
+	}
+	c.Flushed.Wait()
+	close(release)
+}
+
+// resumedIndexes lists the indexes the state has as done, for the log
+func (s *State) resumedIndexes() string {
+
+	var names []string
+	for name := range s.Done {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
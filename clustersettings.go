@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// CopyClusterSettings copies the persistent cluster settings of the source
+// named by --copy-cluster-settings, ie slowlog defaults or allocation
+// awareness. A name copies every setting under it too, so
+// cluster.routing.allocation.awareness brings its attributes and force
+func (c *Config) CopyClusterSettings() error {
+
+	found := struct {
+		Persistent map[string]interface{} `json:"persistent"`
+	}{}
+	if err := getJSON(c.Src, "/_cluster/settings?flat_settings=true", &found); err != nil {
+		return err
+	}
+
+	settings := map[string]interface{}{}
+	for _, name := range strings.Split(c.ClusterSettings, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		matched := false
+		for key, val := range found.Persistent {
+			if key == name || strings.HasPrefix(key, name+".") {
+				settings[key] = val
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("cluster setting %s isn't set on the source", name)
+		}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"persistent": settings})
+	if err != nil {
+		return err
+	}
+	resp, err := c.Dst.Request("PUT", "/_cluster/settings", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed copying cluster settings: %s", string(b))
+	}
+
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		Println("copied cluster setting: ", key)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --coerce types and the column types whose conversions they share
+var coerceTypes = map[string]string{
+	"int":     "long",
+	"integer": "long",
+	"long":    "long",
+	"float":   "double",
+	"double":  "double",
+	"bool":    "boolean",
+	"boolean": "boolean",
+	"string":  "string",
+	"keyword": "string",
+}
+
+// Coercion converts the values of the fields matching Field to Type
+type Coercion struct {
+	Field IndexPattern
+	Type  string
+}
+
+// ParseCoercions reads --coerce, a comma separated list of field:type
+func ParseCoercions(list string) ([]Coercion, error) {
+
+	coercions := []Coercion{}
+	for _, rule := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(rule), ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("bad coercion %s, use field:type", rule)
+		}
+		typ, ok := coerceTypes[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("can't coerce %s to %s, use int, float, bool or string", parts[0], parts[1])
+		}
+		patterns, err := ParseIndexPatterns(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		coercions = append(coercions, Coercion{patterns[0], typ})
+	}
+
+	return coercions, nil
+}
+
+// coerceValue converts v to typ, every element of arrays on their own
+func coerceValue(typ string, v interface{}) (interface{}, error) {
+
+	switch value := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		coerced := make([]interface{}, len(value))
+		for i, item := range value {
+			c, err := coerceValue(typ, item)
+			if err != nil {
+				return nil, err
+			}
+			coerced[i] = c
+		}
+		return coerced, nil
+	case string:
+		// legacy data often has padded numbers, or integers written as 12.0
+		value = strings.TrimSpace(value)
+		v = value
+		if typ == "long" {
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				v = f
+			}
+		}
+	}
+
+	coerced := columnValue(typ, v)
+	if coerced == nil {
+		return nil, fmt.Errorf("can't coerce %v to %s", v, typ)
+	}
+	return coerced, nil
+}
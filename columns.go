@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Column is a column of a tabular dump, name is the dotted path of the field
+// in _source, type is one of string, long, double or boolean
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// elasticsearch field types that map to something other than a string column
+var columnTypes = map[string]string{
+	"long":          "long",
+	"integer":       "long",
+	"short":         "long",
+	"byte":          "long",
+	"unsigned_long": "long",
+	"double":        "double",
+	"float":         "double",
+	"half_float":    "double",
+	"scaled_float":  "double",
+	"boolean":       "boolean",
+}
+
+// MappingColumns infers a column for every field in the mappings of idxs,
+// sorted by name. Fields mapped with different types in different indexes
+// become strings
+func MappingColumns(idxs Indexes) ([]Column, error) {
+
+	fields := map[string]string{}
+	for _, idx := range idxs {
+		mappings, _ := idx.(map[string]interface{})["mappings"].(map[string]interface{})
+		if _, ok := mappings["properties"]; ok {
+			mappingFields("", mappings, fields)
+			continue
+		}
+		// older versions nest mappings under the document type
+		for _, typ := range mappings {
+			if typ, ok := typ.(map[string]interface{}); ok {
+				mappingFields("", typ, fields)
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no mappings to infer columns from")
+	}
+
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	columns := []Column{}
+	for _, name := range names {
+		columns = append(columns, Column{name, fields[name]})
+	}
+
+	return columns, nil
+}
+
+// mappingFields collects the leaf fields under the properties of mapping
+func mappingFields(prefix string, mapping map[string]interface{}, fields map[string]string) {
+
+	properties, _ := mapping["properties"].(map[string]interface{})
+	for name, field := range properties {
+		field, ok := field.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		typ, _ := field["type"].(string)
+
+		// objects are flattened, nested documents are kept as json
+		if _, ok := field["properties"]; ok && (typ == "" || typ == "object") {
+			mappingFields(prefix+name+".", field, fields)
+			continue
+		}
+
+		t, ok := columnTypes[typ]
+		if !ok {
+			t = "string"
+		}
+		if seen, ok := fields[prefix+name]; ok && seen != t {
+			t = "string"
+		}
+		fields[prefix+name] = t
+	}
+}
+
+// Flatten adds the fields of source to out with dotted names, ie
+// {"user": {"name": "x"}} becomes {"user.name": "x"}. Arrays are kept as is
+func Flatten(prefix string, source map[string]interface{}, out map[string]interface{}) {
+
+	for k, v := range source {
+		if obj, ok := v.(map[string]interface{}); ok {
+			Flatten(prefix+k+".", obj, out)
+			continue
+		}
+		out[prefix+k] = v
+	}
+}
+
+// columnValue converts a json value to the type of its column. Values that
+// don't fit, like arrays in a numeric column, are left empty
+func columnValue(typ string, v interface{}) interface{} {
+
+	if v == nil {
+		return nil
+	}
+
+	switch typ {
+	case "long":
+		switch v := v.(type) {
+		case float64:
+			if v == math.Trunc(v) {
+				return int64(v)
+			}
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n
+			}
+		}
+	case "double":
+		switch v := v.(type) {
+		case float64:
+			return v
+		case string:
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				return n
+			}
+		}
+	case "boolean":
+		switch v := v.(type) {
+		case bool:
+			return v
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+		}
+	default:
+		if s, ok := v.(string); ok {
+			return s
+		}
+		b, err := json.Marshal(v)
+		if err == nil {
+			return string(b)
+		}
+	}
+
+	return nil
+}
+
+// flatDoc is a document flattened with its _index and _id as columns
+func flatDoc(doc *Document) map[string]interface{} {
+
+	fields := map[string]interface{}{"_index": doc.Index, "_id": doc.Id}
+	Flatten("", doc.source, fields)
+	return fields
+}
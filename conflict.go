@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+var conflictPolicies = []string{"abort", "skip", "overwrite"}
+
+// CheckConflictPolicy validates --on-conflict, empty reports conflicts as
+// failed documents like any other error
+func CheckConflictPolicy(policy string) error {
+
+	if policy == "" {
+		return nil
+	}
+	for _, p := range conflictPolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("bad --on-conflict %s, use abort, skip or overwrite", policy)
+}
+
+// overwriteAction turns the create action of a document into an index action,
+// which replaces the document already there
+func overwriteAction(action []byte) []byte {
+	return bytes.Replace(action, []byte(`{"create":`), []byte(`{"index":`), 1)
+}
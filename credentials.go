@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// CredentialProvider looks up a secret so it doesnt have to be passed on the
+// command line. ref is whatever follows the provider's scheme
+type CredentialProvider interface {
+	Get(ref string) (string, error)
+}
+
+// credential values starting with one of these schemes are resolved through
+// the provider instead of being used as is
+var CredentialProviders = map[string]CredentialProvider{
+	"keyring": KeyringProvider{},
+	"vault":   VaultProvider{},
+	"env":     EnvProvider{},
+}
+
+// ResolveCredential returns value, or the secret it refers to if it looks
+// like scheme://ref for one of the known providers
+func ResolveCredential(value string) (string, error) {
+
+	i := strings.Index(value, "://")
+	if i < 0 {
+		return value, nil
+	}
+
+	provider, ok := CredentialProviders[value[:i]]
+	if !ok {
+		return value, nil
+	}
+
+	secret, err := provider.Get(value[i+3:])
+	if err != nil {
+		return "", fmt.Errorf("failed loading %s credential: %s", value[:i], err)
+	}
+
+	return strings.TrimRight(secret, "\r\n"), nil
+}
+
+// EnvProvider reads env://NAME from the environment
+type EnvProvider struct{}
+
+func (EnvProvider) Get(ref string) (string, error) {
+
+	val, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("%s is not set", ref)
+	}
+	return val, nil
+}
+
+// KeyringProvider reads keyring://service/account from the os keychain, using
+// security on macos and secret-tool (libsecret) on linux
+type KeyringProvider struct{}
+
+func (KeyringProvider) Get(ref string) (string, error) {
+
+	i := strings.LastIndex(ref, "/")
+	if i <= 0 || i == len(ref)-1 {
+		return "", fmt.Errorf("bad keyring reference %s, should be service/account", ref)
+	}
+	service, account := ref[:i], ref[i+1:]
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "linux", "freebsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	default:
+		return "", fmt.Errorf("keyring is not supported on %s", runtime.GOOS)
+	}
+
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if len(out) == 0 {
+		return "", fmt.Errorf("nothing found for %s", ref)
+	}
+
+	return string(out), nil
+}
+
+// VaultProvider reads vault://path#field from hashicorp vault using
+// VAULT_ADDR and VAULT_TOKEN (or ~/.vault-token). Both kv v1 and v2 engines
+// work, for v2 the path has to include data/, ie secret/data/es#password
+type VaultProvider struct{}
+
+func (VaultProvider) Get(ref string) (string, error) {
+
+	i := strings.LastIndex(ref, "#")
+	if i <= 0 || i == len(ref)-1 {
+		return "", fmt.Errorf("bad vault reference %s, should be path#field", ref)
+	}
+	path, field := ref[:i], ref[i+1:]
+
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			b, _ := ioutil.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(b))
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("VAULT_TOKEN is not set and there is no ~/.vault-token")
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/%s", strings.TrimRight(addr, "/"), path), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("vault returned %d: %s", resp.StatusCode, string(b))
+	}
+
+	secret := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+
+	// kv v2 nests the secret in another data object
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+
+	val, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("no field %s in %s", field, path)
+	}
+
+	return val, nil
+}
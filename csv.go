@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CsvColumnsFor returns the columns of a csv dump, either as listed in
+// --csv-columns or _index, _id and every field in the mappings of idxs
+func (c *Config) CsvColumnsFor(idxs Indexes) ([]Column, error) {
+
+	if c.CsvColumns != "" {
+		columns := []Column{}
+		for _, name := range strings.Split(c.CsvColumns, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				columns = append(columns, Column{name, "string"})
+			}
+		}
+		return columns, nil
+	}
+
+	fields, err := MappingColumns(idxs)
+	if err != nil {
+		return nil, fmt.Errorf("%s, use --csv-columns", err)
+	}
+	return append([]Column{{"_index", "string"}, {"_id", "string"}}, fields...), nil
+}
+
+// CsvWriter writes a header with the column names followed by a row per
+// document. Fields missing from a document are left empty, arrays and
+// nested documents are written as json
+type CsvWriter struct {
+	w       io.WriteCloser
+	csv     *csv.Writer
+	columns []Column
+	row     []string
+}
+
+// NewCsvWriter writes documents to w with the given columns and delimiter,
+// \t can be used for tab separated output
+func NewCsvWriter(w io.WriteCloser, columns []Column, delimiter string) (*CsvWriter, error) {
+
+	if delimiter == `\t` {
+		delimiter = "\t"
+	}
+	comma, size := utf8.DecodeRuneInString(delimiter)
+	if size == 0 || size != len(delimiter) || comma == '"' || comma == '\r' || comma == '\n' {
+		w.Close()
+		return nil, fmt.Errorf("bad csv delimiter %q, should be a single character", delimiter)
+	}
+	if len(columns) == 0 {
+		w.Close()
+		return nil, fmt.Errorf("csv dumps need at least one column")
+	}
+
+	writer := &CsvWriter{w: w, csv: csv.NewWriter(w), columns: columns, row: make([]string, len(columns))}
+	writer.csv.Comma = comma
+
+	for i, col := range columns {
+		writer.row[i] = col.Name
+	}
+	if err := writer.csv.Write(writer.row); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (w *CsvWriter) WriteDoc(doc *Document) error {
+
+	fields := flatDoc(doc)
+	for i, col := range w.columns {
+		w.row[i] = csvValue(fields[col.Name])
+	}
+
+	return w.csv.Write(w.row)
+}
+
+func csvValue(v interface{}) string {
+
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+func (w *CsvWriter) Close() error {
+
+	w.csv.Flush()
+	if err := w.csv.Error(); err != nil {
+		w.w.Close()
+		return err
+	}
+	return w.w.Close()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// DataStream is a data stream of the source. Its documents are written
+// through a data stream of the same name on the destination, created from a
+// copy of its index template, since backing indexes can't be created or
+// written to directly
+type DataStream struct {
+	Name      string `json:"name"`
+	Template  string `json:"template"`
+	IlmPolicy string `json:"ilm_policy"`
+	Indices   []struct {
+		Name string `json:"index_name"`
+	} `json:"indices"`
+}
+
+// GetDataStreams finds the data streams of the source selected by --indexes
+// and --exclude-indexes, es 7.9 and later. It has to run before GetIndexes
+// replaces the patterns of --indexes with the names they matched
+func (c *Config) GetDataStreams() error {
+
+	resp, err := c.Src.Request("GET", "/_data_stream", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// older versions take _data_stream for an index name
+	if resp.StatusCode != 200 {
+		return nil
+	}
+	result := struct {
+		Streams []*DataStream `json:"data_streams"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	patterns, err := ParseIndexPatterns(c.IndexNames)
+	if err != nil {
+		return err
+	}
+	excludes, err := ParseIndexPatterns(c.ExcludeIndexes)
+	if err != nil {
+		return err
+	}
+
+	c.Backing = map[string]string{}
+	for _, stream := range result.Streams {
+		matched := false
+		for _, p := range patterns {
+			matched = matched || p.Match(stream.Name) || p.Name == "_all" || p.Name == "*"
+		}
+		for _, p := range excludes {
+			matched = matched && !p.Match(stream.Name)
+		}
+		if !matched || stream.Name[0] == '.' && !c.CopyAllIndexes {
+			continue
+		}
+		c.Streams = append(c.Streams, stream)
+		for _, index := range stream.Indices {
+			c.Backing[index.Name] = stream.Name
+		}
+	}
+	return nil
+}
+
+// StreamNames are the names of the data streams copied
+func (c *Config) StreamNames() []string {
+
+	var names []string
+	for _, stream := range c.Streams {
+		names = append(names, stream.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddStreams leaves the backing indexes of data streams out of idxs, they
+// are created by the data streams, and scrolls the data streams instead
+func (c *Config) AddStreams(idxs *Indexes) {
+
+	for name := range *idxs {
+		if _, ok := c.Backing[name]; ok {
+			delete(*idxs, name)
+		}
+	}
+
+	scrolled := map[string]bool{}
+	var names []string
+	for _, name := range strings.Split(c.IndexNames, ",") {
+		if name != "" {
+			scrolled[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range c.StreamNames() {
+		if !scrolled[name] {
+			names = append(names, name)
+		}
+	}
+	c.IndexNames = strings.Join(names, ",")
+}
+
+// CreateDataStreams creates every data stream on the destination, after
+// copying the index template it is made from and the component templates
+// that one is composed of. Templates already on the destination are kept
+func (c *Config) CreateDataStreams() error {
+
+	if len(c.Streams) == 0 {
+		return nil
+	}
+	if major, minor := hostVersion(c.Dst); major < 7 || major == 7 && minor < 9 {
+		return fmt.Errorf("data streams need es 7.9 or later on the destination")
+	}
+
+	for _, stream := range c.Streams {
+		dest := c.DestIndex(stream.Name)
+		if c.Skipped[dest] {
+			continue
+		}
+		if err := c.copyTemplate("_index_template", "index_templates", stream.Template); err != nil {
+			return err
+		}
+
+		if c.Destructive {
+			resp, err := c.Dst.Request("DELETE", "/_data_stream/"+dest, nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				Println("deleted data stream: ", dest)
+			}
+		}
+
+		resp, err := c.Dst.Request("PUT", "/_data_stream/"+dest, nil)
+		if err != nil {
+			return err
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == 200:
+			Println("created data stream: ", dest)
+		case strings.Contains(string(b), "already_exists") && c.IfExists == "append":
+			Println("appending to existing data stream: ", dest)
+		case strings.Contains(string(b), "already_exists") && c.IfExists == "abort":
+			return fmt.Errorf("data stream exists on the destination: %s", dest)
+		default:
+			return fmt.Errorf("failed creating data stream %s: %s", dest, string(b))
+		}
+	}
+	return nil
+}
+
+// copyTemplate copies an index or component template from the source, and
+// the component templates an index template is composed of
+func (c *Config) copyTemplate(api, key, name string) error {
+
+	resp, err := c.Dst.Request("HEAD", "/"+api+"/"+name, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == 200 {
+		return nil
+	}
+
+	resp, err = c.Src.Request("GET", "/"+api+"/"+name, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed getting template %s: %s", name, string(b))
+	}
+
+	// {"index_templates": [{"name": "logs", "index_template": {...}}]}
+	found := map[string][]map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return err
+	}
+	if len(found[key]) == 0 {
+		return fmt.Errorf("template %s not found on the source", name)
+	}
+	template, _ := found[key][0][strings.TrimSuffix(key, "s")].(map[string]interface{})
+
+	if c.StripIlm {
+		inner, _ := template["template"].(map[string]interface{})
+		stripLifecycle(inner["settings"])
+	}
+
+	if components, ok := template["composed_of"].([]interface{}); ok {
+		for _, component := range components {
+			if err := c.copyTemplate("_component_template", "component_templates", fmt.Sprint(component)); err != nil {
+				return err
+			}
+		}
+	}
+
+	body, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	put, err := c.Dst.Request("PUT", "/"+api+"/"+name, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer put.Body.Close()
+	if put.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(put.Body)
+		return fmt.Errorf("failed creating template %s: %s", name, string(b))
+	}
+	Println("created template: ", name)
+	return nil
+}
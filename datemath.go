@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// format of date math names without one, like es
+const defaultDateFormat = "yyyy.MM.dd"
+
+func isDateMath(entry string) bool {
+	return len(entry) > 2 && strings.HasPrefix(entry, "<") && strings.HasSuffix(entry, ">")
+}
+
+// ResolveDateMath turns a date math index name like <logstash-{now/d-1d}> or
+// <logs-{now/M{yyyy.MM|+02:00}}> into the index name for now, the way es
+// resolves them. \{ and \} are taken literally
+func ResolveDateMath(name string, now time.Time) (string, error) {
+
+	inner := []rune(name[1 : len(name)-1])
+	var out strings.Builder
+
+	for i := 0; i < len(inner); i++ {
+		switch inner[i] {
+		case '\\':
+			if i+1 < len(inner) {
+				i++
+			}
+			out.WriteRune(inner[i])
+		case '}':
+			return "", fmt.Errorf("bad date math index %s: unexpected }", name)
+		case '{':
+			// the expression runs to the matching }, with an optional
+			// {format|time zone} at its end
+			end, depth := i+1, 1
+			for ; end < len(inner) && depth > 0; end++ {
+				switch inner[end] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+				}
+			}
+			if depth > 0 {
+				return "", fmt.Errorf("bad date math index %s: missing }", name)
+			}
+			value, err := dateMathExpr(string(inner[i+1:end-1]), now)
+			if err != nil {
+				return "", fmt.Errorf("bad date math index %s: %s", name, err)
+			}
+			out.WriteString(value)
+			i = end - 1
+		default:
+			out.WriteRune(inner[i])
+		}
+	}
+
+	return out.String(), nil
+}
+
+func dateMathExpr(expr string, now time.Time) (string, error) {
+
+	format, zone := defaultDateFormat, ""
+	if i := strings.Index(expr, "{"); i >= 0 {
+		if !strings.HasSuffix(expr, "}") {
+			return "", fmt.Errorf("bad format in %s", expr)
+		}
+		format = expr[i+1 : len(expr)-1]
+		expr = expr[:i]
+		if j := strings.Index(format, "|"); j >= 0 {
+			format, zone = format[:j], format[j+1:]
+		}
+		if format == "" {
+			format = defaultDateFormat
+		}
+	}
+
+	loc, err := dateMathZone(zone)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(expr, "now") {
+		return "", fmt.Errorf("%s has to start with now", expr)
+	}
+	t, err := dateMath(expr[len("now"):], now.In(loc))
+	if err != nil {
+		return "", err
+	}
+
+	return formatJavaDate(format, t)
+}
+
+func dateMathZone(zone string) (*time.Location, error) {
+
+	if zone == "" {
+		return time.UTC, nil
+	}
+	if zone[0] == '+' || zone[0] == '-' {
+		t, err := time.Parse("-07:00", zone)
+		if err != nil {
+			return nil, fmt.Errorf("bad time zone %s", zone)
+		}
+		_, offset := t.Zone()
+		return time.FixedZone(zone, offset), nil
+	}
+	return time.LoadLocation(zone)
+}
+
+// dateMath applies operations like +1d, -2h and /M to t
+func dateMath(ops string, t time.Time) (time.Time, error) {
+
+	for len(ops) > 0 {
+		op := ops[0]
+		ops = ops[1:]
+
+		n := 1
+		if op == '+' || op == '-' {
+			digits := 0
+			for digits < len(ops) && ops[digits] >= '0' && ops[digits] <= '9' {
+				digits++
+			}
+			if digits > 0 {
+				n, _ = strconv.Atoi(ops[:digits])
+				ops = ops[digits:]
+			}
+			if op == '-' {
+				n = -n
+			}
+		} else if op != '/' {
+			return t, fmt.Errorf("unknown date math operation %c", op)
+		}
+
+		if len(ops) == 0 {
+			return t, fmt.Errorf("missing unit after %c", op)
+		}
+		unit := ops[0]
+		ops = ops[1:]
+
+		var err error
+		if op == '/' {
+			t, err = roundDate(t, unit)
+		} else {
+			t, err = addDate(t, n, unit)
+		}
+		if err != nil {
+			return t, err
+		}
+	}
+
+	return t, nil
+}
+
+func addDate(t time.Time, n int, unit byte) (time.Time, error) {
+
+	switch unit {
+	case 'y':
+		return t.AddDate(n, 0, 0), nil
+	case 'M':
+		return t.AddDate(0, n, 0), nil
+	case 'w':
+		return t.AddDate(0, 0, 7*n), nil
+	case 'd':
+		return t.AddDate(0, 0, n), nil
+	case 'h', 'H':
+		return t.Add(time.Duration(n) * time.Hour), nil
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute), nil
+	case 's':
+		return t.Add(time.Duration(n) * time.Second), nil
+	}
+	return t, fmt.Errorf("unknown date math unit %c", unit)
+}
+
+// roundDate rounds down to the start of the unit, weeks start on monday
+func roundDate(t time.Time, unit byte) (time.Time, error) {
+
+	y, m, d := t.Date()
+	switch unit {
+	case 'y':
+		return time.Date(y, 1, 1, 0, 0, 0, 0, t.Location()), nil
+	case 'M':
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location()), nil
+	case 'w':
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, t.Location()), nil
+	case 'd':
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location()), nil
+	case 'h', 'H':
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location()), nil
+	case 'm':
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case 's':
+		return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, t.Location()), nil
+	}
+	return t, fmt.Errorf("unknown date math unit %c", unit)
+}
+
+// formatJavaDate formats t with the java date patterns es uses, ie
+// yyyy.MM.dd. Text between single quotes is copied as is
+func formatJavaDate(format string, t time.Time) (string, error) {
+
+	var out strings.Builder
+	for i := 0; i < len(format); {
+		c := format[i]
+
+		if c == '\'' {
+			end := strings.IndexByte(format[i+1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed quote in %s", format)
+			}
+			out.WriteString(format[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			out.WriteByte(c)
+			i++
+			continue
+		}
+
+		n := 1
+		for i+n < len(format) && format[i+n] == c {
+			n++
+		}
+		i += n
+
+		pad := func(v int) string {
+			return fmt.Sprintf("%0*d", n, v)
+		}
+		switch c {
+		case 'y', 'u', 'Y':
+			if n == 2 {
+				out.WriteString(pad(t.Year() % 100))
+			} else {
+				out.WriteString(pad(t.Year()))
+			}
+		case 'M':
+			switch {
+			case n >= 4:
+				out.WriteString(t.Month().String())
+			case n == 3:
+				out.WriteString(t.Month().String()[:3])
+			default:
+				out.WriteString(pad(int(t.Month())))
+			}
+		case 'd':
+			out.WriteString(pad(t.Day()))
+		case 'D':
+			out.WriteString(pad(t.YearDay()))
+		case 'H':
+			out.WriteString(pad(t.Hour()))
+		case 'm':
+			out.WriteString(pad(t.Minute()))
+		case 's':
+			out.WriteString(pad(t.Second()))
+		case 'w':
+			_, week := t.ISOWeek()
+			out.WriteString(pad(week))
+		case 'E':
+			if n >= 4 {
+				out.WriteString(t.Weekday().String())
+			} else {
+				out.WriteString(t.Weekday().String()[:3])
+			}
+		default:
+			return "", fmt.Errorf("unsupported date format %s", strings.Repeat(string(c), n))
+		}
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveDateMath(t *testing.T) {
+
+	// a friday
+	now := time.Date(2024, 3, 22, 18, 45, 30, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		want string
+		err  bool
+	}{
+		{"<logstash-{now}>", "logstash-2024.03.22", false},
+		{"<logstash-{now/d}>", "logstash-2024.03.22", false},
+		{"<logstash-{now/d-1d}>", "logstash-2024.03.21", false},
+		{"<logstash-{now/M}>", "logstash-2024.03.01", false},
+		{"<logstash-{now/M{yyyy.MM}}>", "logstash-2024.03", false},
+		{"<logstash-{now/M-1M{yyyy.MM}}>", "logstash-2024.02", false},
+		{"<logstash-{now/y{yyyy}}>", "logstash-2024", false},
+		{"<logstash-{now/w{yyyy.MM.dd}}>", "logstash-2024.03.18", false},
+		{"<logstash-{now/d{yyyy.MM.dd|+12:00}}>", "logstash-2024.03.23", false},
+		{"<logstash-{now/d{yyyy.MM.dd|-08:00}}>", "logstash-2024.03.22", false},
+		{"<logstash-{now/h{yyyy.MM.dd.HH}}>", "logstash-2024.03.22.18", false},
+		{"<logstash-{now+1h{yyyy.MM.dd.HH}}>", "logstash-2024.03.22.19", false},
+		{"<logstash-{now+6h/d}>", "logstash-2024.03.23", false},
+		{"<\\{logs\\}-{now/d}>", "{logs}-2024.03.22", false},
+		{"<logs-{now/d}-{now/M{yyyy.MM}}>", "logs-2024.03.22-2024.03", false},
+		{"<logs-{now/q}>", "", true},
+		{"<logs-{now/d>", "", true},
+		{"<logs-}>", "", true},
+		{"<logs-{today}>", "", true},
+		{"<logs-{now/d{yyyy|+25:00}}>", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ResolveDateMath(tt.name, now)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRoundDate(t *testing.T) {
+
+	now := time.Date(2024, 3, 22, 18, 45, 30, 500, time.UTC)
+
+	tests := []struct {
+		unit byte
+		want time.Time
+	}{
+		{'y', time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{'M', time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{'w', time.Date(2024, 3, 18, 0, 0, 0, 0, time.UTC)},
+		{'d', time.Date(2024, 3, 22, 0, 0, 0, 0, time.UTC)},
+		{'h', time.Date(2024, 3, 22, 18, 0, 0, 0, time.UTC)},
+		{'H', time.Date(2024, 3, 22, 18, 0, 0, 0, time.UTC)},
+		{'m', time.Date(2024, 3, 22, 18, 45, 0, 0, time.UTC)},
+		{'s', time.Date(2024, 3, 22, 18, 45, 30, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := roundDate(now, tt.unit)
+		if err != nil {
+			t.Errorf("/%c: %s", tt.unit, err)
+		} else if !got.Equal(tt.want) {
+			t.Errorf("/%c: got %s, want %s", tt.unit, got, tt.want)
+		}
+	}
+
+	// weeks start on monday, a sunday rounds back six days and a monday
+	// stays where it is
+	for day, want := range map[int]int{17: 11, 18: 18, 24: 18} {
+		got, _ := roundDate(time.Date(2024, 3, day, 9, 0, 0, 0, time.UTC), 'w')
+		if got.Day() != want {
+			t.Errorf("/w of march %d: got %d, want %d", day, got.Day(), want)
+		}
+	}
+
+	if _, err := roundDate(now, 'x'); err == nil {
+		t.Error("expected an error for an unknown unit")
+	}
+}
+
+func TestDateMathAcrossMonths(t *testing.T) {
+
+	tests := []struct {
+		now  time.Time
+		ops  string
+		want time.Time
+	}{
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "-1d", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), "/M-1M", time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 12, 31, 23, 0, 0, 0, time.UTC), "+1h/d", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 22, 0, 0, 0, 0, time.UTC), "+2w", time.Date(2024, 4, 5, 0, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 22, 10, 0, 0, 0, time.UTC), "-90m", time.Date(2024, 3, 22, 8, 30, 0, 0, time.UTC)},
+		{time.Date(2024, 3, 22, 10, 0, 0, 0, time.UTC), "+d", time.Date(2024, 3, 23, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := dateMath(tt.ops, tt.now)
+		if err != nil {
+			t.Errorf("%s: %s", tt.ops, err)
+		} else if !got.Equal(tt.want) {
+			t.Errorf("%s from %s: got %s, want %s", tt.ops, tt.now, got, tt.want)
+		}
+	}
+
+	for _, ops := range []string{"*1d", "+1", "/", "+1q"} {
+		if _, err := dateMath(ops, time.Now()); err == nil {
+			t.Errorf("%s: expected an error", ops)
+		}
+	}
+}
+
+func TestFormatJavaDate(t *testing.T) {
+
+	ts := time.Date(2024, 3, 5, 7, 8, 9, 0, time.UTC)
+
+	tests := []struct {
+		format string
+		want   string
+		err    bool
+	}{
+		{"yyyy.MM.dd", "2024.03.05", false},
+		{"yy-M-d", "24-3-5", false},
+		{"uuuu/MM", "2024/03", false},
+		{"yyyy-MM-dd'T'HH:mm:ss", "2024-03-05T07:08:09", false},
+		{"dd MMM yyyy", "05 Mar 2024", false},
+		{"MMMM", "March", false},
+		{"EEE EEEE", "Tue Tuesday", false},
+		{"yyyy.DDD", "2024.065", false},
+		{"YYYY.ww", "2024.10", false},
+		{"'week' w", "week 10", false},
+		{"yyyy'", "", true},
+		{"yyyy.MM.dd.SSS", "", true},
+	}
+	for _, tt := range tests {
+		got, err := formatJavaDate(tt.format, ts)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", tt.format, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.format, err)
+		} else if got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.format, got, tt.want)
+		}
+	}
+}
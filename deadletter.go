@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DeadLetter keeps the documents the destination didn't index, in the docs
+// format with the reason they failed. They can be copied again with --input,
+// the reasons are ignored then
+type DeadLetter struct {
+	Path  string
+	f     *os.File
+	enc   *json.Encoder
+	count int
+}
+
+// Write adds the document of a bulk action and source line. The file is
+// created on the first failure
+func (d *DeadLetter) Write(action, source []byte, status int, reason string) error {
+
+	if d.f == nil {
+		f, err := os.Create(d.Path)
+		if err != nil {
+			return err
+		}
+		d.f, d.enc = f, json.NewEncoder(f)
+	}
+
+	actions := map[string]map[string]interface{}{}
+	if err := json.Unmarshal(action, &actions); err != nil {
+		return err
+	}
+	doc := map[string]interface{}{}
+	for _, meta := range actions {
+		for key, value := range meta {
+			if key == "routing" || key == "parent" {
+				key = "_" + key
+			}
+			doc[key] = value
+		}
+	}
+	doc["_source"] = json.RawMessage(source)
+	doc["_status"] = status
+	doc["_error"] = reason
+
+	d.count++
+	return d.enc.Encode(doc)
+}
+
+func (d *DeadLetter) Close() error {
+
+	if d.f == nil {
+		return nil
+	}
+	Println(fmt.Sprintf("%d failed documents written to %s", d.count, d.Path))
+	return d.f.Close()
+}
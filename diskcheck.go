@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the high disk watermark of es when none is set
+const defaultHighWatermark = 0.9
+
+// CheckDiskSpace estimates whether the source indexes fit on the destination
+// before its nodes reach the high disk watermark, past which es stops
+// allocating shards to them. Copies that won't fit are an error, those that
+// leave less than a fifth of the room get a warning. Sizes differ between
+// versions and settings, so this is only a rough guess. Not being able to
+// tell is only a warning
+func (c *Config) CheckDiskSpace(names []string) error {
+
+	if len(names) == 0 || c.Src == nil {
+		return nil
+	}
+	need, err := c.sourceSize(names)
+	if err != nil {
+		Printf("can't tell if the copy fits on the destination: %s\n", err)
+		return nil
+	}
+
+	nodes := struct {
+		Nodes map[string]struct {
+			Fs struct {
+				Total struct {
+					Total     int64 `json:"total_in_bytes"`
+					Available int64 `json:"available_in_bytes"`
+				} `json:"total"`
+			} `json:"fs"`
+		} `json:"nodes"`
+	}{}
+	if err := getJSON(c.Dst, "/_nodes/stats/fs", &nodes); err != nil {
+		Printf("can't tell if the copy fits on the destination: %s\n", err)
+		return nil
+	}
+
+	watermark := c.highWatermark()
+	var room int64
+	for _, node := range nodes.Nodes {
+		fs := node.Fs.Total
+		if free := fs.Available - int64(float64(fs.Total)*(1-watermark)); free > 0 {
+			room += free
+		}
+	}
+
+	switch {
+	case need > room:
+		return fmt.Errorf("the copy needs about %s, the destination has %s until the %.0f%% disk watermark", byteSize(need), byteSize(room), watermark*100)
+	case need > room*4/5:
+		Printf("the copy needs about %s of the %s the destination has until the %.0f%% disk watermark\n", byteSize(need), byteSize(room), watermark*100)
+	}
+	return nil
+}
+
+// sourceSize adds up the store of the indexes, with their replicas when
+// replication is enabled on the destination
+func (c *Config) sourceSize(names []string) (int64, error) {
+
+	stats := struct {
+		Indices map[string]struct {
+			Primaries struct {
+				Store struct {
+					Size int64 `json:"size_in_bytes"`
+				} `json:"store"`
+			} `json:"primaries"`
+			Total struct {
+				Store struct {
+					Size int64 `json:"size_in_bytes"`
+				} `json:"store"`
+			} `json:"total"`
+		} `json:"indices"`
+	}{}
+	if err := getJSON(c.Src, "/"+strings.Join(names, ",")+"/_stats/store", &stats); err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, index := range stats.Indices {
+		if c.EnableReplication {
+			size += index.Total.Store.Size
+		} else {
+			size += index.Primaries.Store.Size
+		}
+	}
+	return size, nil
+}
+
+// highWatermark reads the high disk watermark of the destination as a share
+// of the disk. Absolute watermarks and older versions get the default
+func (c *Config) highWatermark() float64 {
+
+	settings := map[string]map[string]interface{}{}
+	if err := getJSON(c.Dst, "/_cluster/settings?include_defaults=true&flat_settings=true", &settings); err != nil {
+		return defaultHighWatermark
+	}
+	for _, scope := range []string{"transient", "persistent", "defaults"} {
+		value, ok := settings[scope]["cluster.routing.allocation.disk.watermark.high"].(string)
+		if !ok {
+			continue
+		}
+		if percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64); err == nil && strings.HasSuffix(value, "%") {
+			return percent / 100
+		}
+		return defaultHighWatermark
+	}
+	return defaultHighWatermark
+}
+
+func getJSON(host *Host, path string, v interface{}) error {
+
+	resp, err := host.Request("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("failed getting %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func byteSize(n int64) string {
+
+	for _, unit := range byteUnits {
+		if float64(n) >= unit.size {
+			return fmt.Sprintf("%.1f%s", float64(n)/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%db", n)
+}
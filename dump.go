@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DocWriter writes documents to a dump instead of a destination instance
+type DocWriter interface {
+	WriteDoc(doc *Document) error
+	Close() error
+}
+
+// NewDocWriter opens location for writing and returns a writer for format
+func (c *Config) NewDocWriter(location, format string) (DocWriter, error) {
+
+	if IsArchive(location) {
+		return c.NewArchiveWriter(location, format)
+	}
+	if c.SplitSize > 0 || c.SplitDocs > 0 {
+		return c.NewSplitWriter(location, format)
+	}
+
+	w, err := c.OpenOutput(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.NewFormatWriter(w, format)
+}
+
+// NewFormatWriter writes documents to w in format
+func (c *Config) NewFormatWriter(w io.WriteCloser, format string) (DocWriter, error) {
+
+	switch format {
+	case "bulk":
+		return &BulkWriter{w: w, enc: json.NewEncoder(w)}, nil
+	case "docs":
+		return &DocsWriter{w: w, enc: json.NewEncoder(w)}, nil
+	case "elasticdump":
+		return &ElasticdumpWriter{w: w, enc: json.NewEncoder(w)}, nil
+	case "parquet":
+		return NewParquetWriter(w, c.Columns)
+	case "csv":
+		return NewCsvWriter(w, c.Columns, c.CsvDelimiter)
+	case "avro":
+		return NewAvroWriter(w, c.Avro)
+	default:
+		w.Close()
+		return nil, fmt.Errorf("unknown output format %s", format)
+	}
+}
+
+// Object is a dump kept in object storage rather than on local disk
+type Object interface {
+	NewReader() (io.ReadCloser, error)
+	NewWriter() io.WriteCloser
+}
+
+// NewObject picks the object store for location by its scheme
+func (c *Config) NewObject(location string) (Object, error) {
+
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		return c.NewS3Object(location)
+	case strings.HasPrefix(location, "gs://"):
+		return c.NewGCSObject(location)
+	case strings.HasPrefix(location, "azblob://"):
+		return c.NewAzureBlob(location)
+	default:
+		return nil, fmt.Errorf("unsupported location %s", location)
+	}
+}
+
+// OpenOutput opens a dump location for writing, compressing if it ends in .gz
+func (c *Config) OpenOutput(location string) (io.WriteCloser, error) {
+
+	w, err := c.OpenRawOutput(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return Compress(w, location), nil
+}
+
+// Compress wraps w in gzip if location ends in .gz or .tgz
+func Compress(w io.WriteCloser, location string) io.WriteCloser {
+
+	if isGzip(location) {
+		return &gzipWriter{gzip.NewWriter(w), w}
+	}
+	return w
+}
+
+// OpenRawOutput opens a dump location for writing without compression, the
+// dump is still encrypted if a key was given
+func (c *Config) OpenRawOutput(location string) (io.WriteCloser, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("bad output %s: %s", location, err)
+	}
+
+	var w io.WriteCloser
+	switch {
+	case location == "-":
+		// stdout is only flushed, never closed
+		w = &bufferedWriter{bufio.NewWriterSize(os.Stdout, 1<<20), nil}
+	case u.Scheme == "" || u.Scheme == "file":
+		f, err := os.Create(filePath(location))
+		if err != nil {
+			return nil, err
+		}
+		w = &bufferedWriter{bufio.NewWriterSize(f, 1<<20), f}
+	default:
+		obj, err := c.NewObject(location)
+		if err != nil {
+			return nil, err
+		}
+		w = obj.NewWriter()
+	}
+
+	encrypted, err := c.Encrypt(w)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	return encrypted, nil
+}
+
+// OpenInput opens a dump location for reading, decrypting and decompressing
+// as needed
+func (c *Config) OpenInput(location string) (io.ReadCloser, error) {
+
+	r, err := c.OpenRawInput(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if isGzip(location) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed reading %s: %s", location, err)
+		}
+		r = &gzipReader{gz, r}
+	}
+
+	return r, nil
+}
+
+// OpenRawInput opens a dump location for reading without decompressing, the
+// dump is still decrypted if a key was given
+func (c *Config) OpenRawInput(location string) (io.ReadCloser, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("bad input %s: %s", location, err)
+	}
+
+	var r io.ReadCloser
+	switch {
+	case location == "-":
+		r = ioutil.NopCloser(os.Stdin)
+	case u.Scheme == "" || u.Scheme == "file":
+		if r, err = os.Open(filePath(location)); err != nil {
+			return nil, err
+		}
+	case u.Scheme == "http" || u.Scheme == "https":
+		if r, err = c.NewHTTPReader(location); err != nil {
+			return nil, err
+		}
+	default:
+		obj, err := c.NewObject(location)
+		if err != nil {
+			return nil, err
+		}
+		if r, err = obj.NewReader(); err != nil {
+			return nil, err
+		}
+	}
+
+	plain, err := c.Decrypt(r, location)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return plain, nil
+}
+
+func isGzip(location string) bool {
+	location = trimQuery(location)
+	return strings.HasSuffix(location, ".gz") || strings.HasSuffix(location, ".tgz")
+}
+
+// filePath strips the file:// prefix, if any
+func filePath(location string) string {
+	return strings.TrimPrefix(location, "file://")
+}
+
+// bufferedWriter flushes its buffer before closing the underlying writer, if
+// there is one
+type bufferedWriter struct {
+	*bufio.Writer
+	c io.Closer
+}
+
+func (b *bufferedWriter) Close() error {
+	err := b.Flush()
+	if b.c == nil {
+		return err
+	}
+	if err != nil {
+		b.c.Close()
+		return err
+	}
+	return b.c.Close()
+}
+
+// gzipWriter finishes the gzip stream before closing the underlying writer
+type gzipWriter struct {
+	*gzip.Writer
+	c io.Closer
+}
+
+func (g *gzipWriter) Close() error {
+	if err := g.Writer.Close(); err != nil {
+		g.c.Close()
+		return err
+	}
+	return g.c.Close()
+}
+
+// gzipReader closes both the gzip stream and the underlying reader
+type gzipReader struct {
+	*gzip.Reader
+	c io.Closer
+}
+
+func (g *gzipReader) Close() error {
+	g.Reader.Close()
+	return g.c.Close()
+}
+
+// BulkWriter writes documents the same way they are sent to the _bulk api, so
+// a dump can be loaded with curl as well
+type BulkWriter struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+func (b *BulkWriter) WriteDoc(doc *Document) error {
+
+	if err := b.enc.Encode(map[string]*Document{"create": doc}); err != nil {
+		return err
+	}
+	return b.enc.Encode(doc.source)
+}
+
+func (b *BulkWriter) Close() error {
+	return b.w.Close()
+}
+
+// DocsWriter writes each document as a single {_index,_type,_id,_source}
+// object per line
+type DocsWriter struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+func (d *DocsWriter) WriteDoc(doc *Document) error {
+
+	line := map[string]interface{}{
+		"_index":  doc.Index,
+		"_type":   doc.Type,
+		"_id":     doc.Id,
+		"_source": doc.source,
+	}
+	if doc.Routing != "" {
+		line["_routing"] = doc.Routing
+	}
+	if doc.Parent != "" {
+		line["_parent"] = doc.Parent
+	}
+	return d.enc.Encode(line)
+}
+
+func (d *DocsWriter) Close() error {
+	return d.w.Close()
+}
+
+// DocReader reads documents back from a dump written in either the bulk or
+// the docs format
+type DocReader struct {
+	r      io.ReadCloser
+	dec    *json.Decoder
+	closed bool
+}
+
+// NewDocReader opens location for reading
+func (c *Config) NewDocReader(location string) (*DocReader, error) {
+
+	r, err := c.OpenInput(location)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DocReader{r: r, dec: json.NewDecoder(bufio.NewReaderSize(r, 1<<20))}, nil
+}
+
+// Next reads up to a page of documents into DocChan, done is set at the end
+// of the dump
+func (d *DocReader) Next(c *Config) (done bool) {
+
+	for i := 0; i < c.DocBufferCount; i++ {
+		doc, err := d.ReadDoc()
+		if err == io.EOF {
+			d.Close()
+			return true
+		}
+		if err != nil {
+			c.Errors.Add(sourceErrors, err)
+			d.Close()
+			return true
+		}
+		c.DocChan <- doc
+	}
+
+	return
+}
+
+// Close closes the dump, once, whether it was read to the end or not
+func (d *DocReader) Close() error {
+
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	return d.r.Close()
+}
+
+// ReadDoc reads the next document as it would have come out of a scroll
+func (d *DocReader) ReadDoc() (map[string]interface{}, error) {
+
+	line := map[string]interface{}{}
+	if err := d.dec.Decode(&line); err != nil {
+		return nil, err
+	}
+
+	// docs and elasticdump formats, everything is on one line
+	if _, ok := line["_source"]; ok {
+		return withType(line), nil
+	}
+
+	// bulk format, an action line followed by the source
+	for _, action := range []string{"create", "index"} {
+		meta, ok := line[action].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		source := map[string]interface{}{}
+		if err := d.dec.Decode(&source); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("failed reading source for %v: %s", meta, err)
+		}
+		meta["_source"] = source
+		if routing, ok := meta["routing"]; ok {
+			meta["_routing"] = routing
+		}
+		if parent, ok := meta["parent"]; ok {
+			meta["_parent"] = parent
+		}
+		return withType(meta), nil
+	}
+
+	return nil, fmt.Errorf("unrecognized line in dump: %v", line)
+}
+
+// withType sets _type on documents from typeless versions of es
+func withType(doc map[string]interface{}) map[string]interface{} {
+
+	if _, ok := doc["_type"].(string); !ok {
+		doc["_type"] = "_doc"
+	}
+	return doc
+}
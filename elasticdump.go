@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// The node elasticdump tool writes data files with one search hit per line,
+// and mapping, settings and alias files with the response of the matching es
+// api, ie {"index": {"mappings": {...}}}. multielasticdump names them
+// <index>.json, <index>.mapping.json, <index>.settings.json and
+// <index>.alias.json
+
+// ElasticdumpWriter writes documents the way elasticdump writes a data file
+type ElasticdumpWriter struct {
+	w   io.WriteCloser
+	enc *json.Encoder
+}
+
+func (e *ElasticdumpWriter) WriteDoc(doc *Document) error {
+
+	hit := map[string]interface{}{
+		"_index":  doc.Index,
+		"_type":   doc.Type,
+		"_id":     doc.Id,
+		"_score":  1,
+		"_source": doc.source,
+	}
+	if doc.Routing != "" {
+		hit["_routing"] = doc.Routing
+	}
+	if doc.Parent != "" {
+		hit["_parent"] = doc.Parent
+	}
+	return e.enc.Encode(hit)
+}
+
+func (e *ElasticdumpWriter) Close() error {
+	return e.w.Close()
+}
+
+// elasticdump file names for each kind of metadata
+var elasticdumpFiles = map[string]string{
+	"mappings": "mapping",
+	"settings": "settings",
+	"aliases":  "alias",
+}
+
+// writeElasticdumpMetadata writes the metadata of an index to dir the way
+// multielasticdump does. elasticdump reads files a line at a time so each
+// one is a single line
+func writeElasticdumpMetadata(dir, name string, files map[string]interface{}) error {
+
+	for kind, data := range files {
+		b, err := json.Marshal(map[string]interface{}{name: map[string]interface{}{kind: data}})
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%s.%s.json", name, elasticdumpFiles[kind]))
+		if err := ioutil.WriteFile(path, append(b, '\n'), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadElasticdumpMetadata reads index definitions from mapping, settings and
+// alias files written by elasticdump. A file can hold more than one index
+func LoadElasticdumpMetadata(dir string) (Indexes, error) {
+
+	idxs := Indexes{}
+	for _, kind := range []string{"mappings", "settings", "aliases"} {
+		files, err := filepath.Glob(filepath.Join(dir, "*."+elasticdumpFiles[kind]+".json"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if err := readElasticdumpFile(file, kind, idxs); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// settings and aliases are only kept for indexes that have mappings
+	for name, idx := range idxs {
+		if _, ok := idx.(map[string]interface{})["mappings"]; !ok {
+			delete(idxs, name)
+			continue
+		}
+		cleanSettings(idx.(map[string]interface{}))
+	}
+	if len(idxs) == 0 {
+		return nil, fmt.Errorf("no index metadata found in %s", dir)
+	}
+
+	return idxs, nil
+}
+
+func readElasticdumpFile(path, kind string, idxs Indexes) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// usually a single line, but elasticdump appends when asked to
+	dec := json.NewDecoder(f)
+	for {
+		resp := map[string]interface{}{}
+		if err := dec.Decode(&resp); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed reading %s: %s", path, err)
+		}
+
+		for name := range resp {
+			data := unwrap(resp, name, kind)
+			if data == nil {
+				continue
+			}
+			if _, ok := idxs[name]; !ok {
+				idxs[name] = map[string]interface{}{}
+			}
+			idxs[name].(map[string]interface{})[kind] = data
+		}
+	}
+}
+
+// isElasticdumpDir tells if dir has metadata written by elasticdump rather
+// than --dump-metadata
+func isElasticdumpDir(dir string) bool {
+
+	native, _ := filepath.Glob(filepath.Join(dir, "*.mappings.json"))
+	if len(native) > 0 {
+		return false
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.mapping.json"))
+	return len(files) > 0
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Encrypted dumps start with a header of
+//
+//	magic (8 bytes), salt (16 bytes), nonce prefix (7 bytes)
+//
+// followed by chunks of at most encryptChunkSize bytes, each sealed with
+// aes-256-gcm and prefixed with its sealed length. The nonce of a chunk is the
+// prefix, the chunk number and a byte that is set on the last chunk only, so
+// chunks can't be reordered and a truncated dump fails to decrypt
+const encryptChunkSize = 64 << 10
+
+var encryptMagic = []byte("ESDENC\x00\x01")
+
+// iterations of pbkdf2 when the key comes from --passphrase
+const passphraseIterations = 600000
+
+// encryptionKey derives the aes key of a dump from --passphrase and the salt
+// of the dump, or reads it from --encryption-key. Keyfiles are expected to be
+// random, ie openssl rand 32 > dump.key, so they are only hashed
+func (c *Config) encryptionKey(salt []byte) ([]byte, error) {
+
+	if c.EncryptionKey != "" {
+		b, err := ioutil.ReadFile(c.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < 16 {
+			return nil, fmt.Errorf("encryption key %s is too short, use at least 16 random bytes", c.EncryptionKey)
+		}
+		sum := sha256.Sum256(b)
+		return sum[:], nil
+	}
+
+	return pbkdf2([]byte(c.Passphrase), salt, passphraseIterations), nil
+}
+
+// Encrypts tells if dumps are encrypted
+func (c *Config) Encrypts() bool {
+	return c.EncryptionKey != "" || c.Passphrase != ""
+}
+
+// Encrypt wraps w so everything written to it is encrypted, w is returned as
+// is when no key was given
+func (c *Config) Encrypt(w io.WriteCloser) (io.WriteCloser, error) {
+
+	if !c.Encrypts() {
+		return w, nil
+	}
+
+	header := make([]byte, len(encryptMagic)+16+7)
+	copy(header, encryptMagic)
+	if _, err := rand.Read(header[len(encryptMagic):]); err != nil {
+		return nil, err
+	}
+	salt := header[len(encryptMagic) : len(encryptMagic)+16]
+
+	aead, err := c.newAead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{w: w, aead: aead, prefix: header[len(encryptMagic)+16:]}, nil
+}
+
+// Decrypt wraps r so it reads the plain dump. Encrypted dumps are recognized by
+// their header, and refused when no key was given
+func (c *Config) Decrypt(r io.ReadCloser, location string) (io.ReadCloser, error) {
+
+	buf := bufio.NewReader(r)
+	magic, _ := buf.Peek(len(encryptMagic))
+	encrypted := bytes.Equal(magic, encryptMagic)
+
+	if !encrypted {
+		if c.Encrypts() {
+			return nil, fmt.Errorf("%s is not encrypted", location)
+		}
+		return &readCloser{buf, r}, nil
+	}
+	if !c.Encrypts() {
+		return nil, fmt.Errorf("%s is encrypted, use --passphrase or --encryption-key", location)
+	}
+
+	header := make([]byte, len(encryptMagic)+16+7)
+	if _, err := io.ReadFull(buf, header); err != nil {
+		return nil, fmt.Errorf("failed reading %s: %s", location, err)
+	}
+	aead, err := c.newAead(header[len(encryptMagic) : len(encryptMagic)+16])
+	if err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{r: buf, c: r, aead: aead, prefix: header[len(encryptMagic)+16:], location: location}, nil
+}
+
+func (c *Config) newAead(salt []byte) (cipher.AEAD, error) {
+
+	key, err := c.encryptionKey(salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, n uint32, last bool) []byte {
+
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[7:], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptWriter seals a chunk at a time, the last one on close
+type encryptWriter struct {
+	w      io.WriteCloser
+	aead   cipher.AEAD
+	prefix []byte
+	buf    []byte
+	n      uint32
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+
+	written := len(p)
+	for len(p) > 0 {
+		take := encryptChunkSize - len(e.buf)
+		if take > len(p) {
+			take = len(p)
+		}
+		e.buf, p = append(e.buf, p[:take]...), p[take:]
+
+		// the last chunk is only known on close, so a full chunk waits
+		// until there is more to write
+		if len(e.buf) == encryptChunkSize && len(p) > 0 {
+			if err := e.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (e *encryptWriter) seal(last bool) error {
+
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.n, last), e.buf, nil)
+	e.n++
+	e.buf = e.buf[:0]
+
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(sealed)))
+	if _, err := e.w.Write(length); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *encryptWriter) Close() error {
+
+	if err := e.seal(true); err != nil {
+		e.w.Close()
+		return err
+	}
+	return e.w.Close()
+}
+
+// decryptReader opens a chunk at a time and fails if the stream ends before
+// the last chunk
+type decryptReader struct {
+	r        io.Reader
+	c        io.Closer
+	aead     cipher.AEAD
+	prefix   []byte
+	location string
+	plain    []byte
+	n        uint32
+	done     bool
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+
+	length := make([]byte, 4)
+	if _, err := io.ReadFull(d.r, length); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%s is truncated", d.location)
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(length)
+	if size > encryptChunkSize+uint32(d.aead.Overhead()) {
+		return fmt.Errorf("%s is corrupted", d.location)
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("%s is truncated", d.location)
+	}
+
+	// try the chunk as a middle one and then as the last one
+	plain, err := d.aead.Open(nil, chunkNonce(d.prefix, d.n, false), sealed, nil)
+	if err != nil {
+		if plain, err = d.aead.Open(nil, chunkNonce(d.prefix, d.n, true), sealed, nil); err != nil {
+			return errors.New("failed decrypting " + d.location + ", wrong key or corrupted dump")
+		}
+		d.done = true
+	}
+	d.plain = plain
+	d.n++
+
+	return nil
+}
+
+func (d *decryptReader) Close() error {
+	return d.c.Close()
+}
+
+// readCloser reads from a buffered reader and closes what it buffers
+type readCloser struct {
+	io.Reader
+	c io.Closer
+}
+
+func (r *readCloser) Close() error {
+	return r.c.Close()
+}
+
+// pbkdf2 derives a 32 byte key with hmac-sha256
+func pbkdf2(password, salt []byte, iterations int) []byte {
+
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+
+	return key
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// keyConfig returns a config encrypting with a random key file, which is a
+// lot faster than a passphrase
+func keyConfig(t *testing.T) *Config {
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	path := filepath.Join(t.TempDir(), "dump.key")
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &Config{EncryptionKey: path}
+}
+
+func encrypt(t *testing.T, c *Config, plain []byte) []byte {
+
+	var out bytes.Buffer
+	w, err := c.Encrypt(nopWriteCloser{&out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// write in odd sizes so chunks don't line up with the writes
+	for len(plain) > 0 {
+		n := 1000
+		if n > len(plain) {
+			n = len(plain)
+		}
+		if _, err := w.Write(plain[:n]); err != nil {
+			t.Fatal(err)
+		}
+		plain = plain[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func decrypt(c *Config, sealed []byte) ([]byte, error) {
+
+	r, err := c.Decrypt(ioutil.NopCloser(bytes.NewReader(sealed)), "dump")
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+
+	c := keyConfig(t)
+
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"small", 10},
+		{"one chunk", encryptChunkSize},
+		{"chunk and a byte", encryptChunkSize + 1},
+		{"two chunks", 2 * encryptChunkSize},
+		{"many chunks", 5*encryptChunkSize + 123},
+	}
+
+	for _, tt := range tests {
+		plain := make([]byte, tt.size)
+		rand.Read(plain)
+
+		sealed := encrypt(t, c, plain)
+		if !bytes.HasPrefix(sealed, encryptMagic) {
+			t.Errorf("%s: missing magic", tt.name)
+		}
+		if tt.size > 0 && bytes.Contains(sealed, plain) {
+			t.Errorf("%s: plain text in the dump", tt.name)
+		}
+
+		got, err := decrypt(c, sealed)
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		} else if !bytes.Equal(got, plain) {
+			t.Errorf("%s: got %d bytes back, want %d", tt.name, len(got), len(plain))
+		}
+	}
+}
+
+func TestEncryptPassphrase(t *testing.T) {
+
+	plain := []byte(`{"_index":"idx","_id":"1","_source":{}}`)
+	sealed := encrypt(t, &Config{Passphrase: "secret"}, plain)
+
+	got, err := decrypt(&Config{Passphrase: "secret"}, sealed)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := decrypt(&Config{Passphrase: "wrong"}, sealed); err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Errorf("wrong passphrase: got %v", err)
+	}
+}
+
+func TestDecryptDamaged(t *testing.T) {
+
+	c := keyConfig(t)
+	plain := make([]byte, 3*encryptChunkSize+100)
+	rand.Read(plain)
+	sealed := encrypt(t, c, plain)
+
+	header := len(encryptMagic) + 16 + 7
+	chunk := 4 + encryptChunkSize + 16
+
+	// the chunks after the header, reassembled in another order
+	chunks := func(order ...int) []byte {
+		out := append([]byte{}, sealed[:header]...)
+		for _, i := range order {
+			start := header + i*chunk
+			end := start + chunk
+			if end > len(sealed) {
+				end = len(sealed)
+			}
+			out = append(out, sealed[start:end]...)
+		}
+		return out
+	}
+
+	flipped := append([]byte{}, sealed...)
+	flipped[header+10] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		err    string
+	}{
+		{"header only", sealed[:header], "truncated"},
+		{"short header", sealed[:header-3], "failed reading"},
+		{"missing last chunk", chunks(0, 1, 2), "truncated"},
+		{"cut in a chunk", sealed[:header+chunk+100], "truncated"},
+		{"cut in a length", sealed[:header+chunk+2], "truncated"},
+		{"cut in the last chunk", sealed[:len(sealed)-1], "truncated"},
+		{"reordered", chunks(1, 0, 2, 3), "wrong key or corrupted"},
+		{"last chunk early", chunks(0, 3), "wrong key or corrupted"},
+		{"flipped bit", flipped, "wrong key or corrupted"},
+		{"trailing chunk", append(chunks(0, 1, 2, 3), sealed[header:header+chunk]...), ""},
+	}
+
+	for _, tt := range tests {
+		got, err := decrypt(c, tt.sealed)
+		if tt.err == "" {
+			// nothing is read past the last chunk
+			if err != nil || !bytes.Equal(got, plain) {
+				t.Errorf("%s: got %d bytes, %v", tt.name, len(got), err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %s", tt.name, err, tt.err)
+		}
+	}
+
+	if _, err := decrypt(keyConfig(t), sealed); err == nil {
+		t.Error("decrypted with another key")
+	}
+}
+
+func TestDecryptPlain(t *testing.T) {
+
+	plain := []byte(`{"_id":"1"}`)
+
+	got, err := decrypt(&Config{}, plain)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("plain dump: got %q, %v", got, err)
+	}
+	if _, err := decrypt(keyConfig(t), plain); err == nil || !strings.Contains(err.Error(), "is not encrypted") {
+		t.Errorf("plain dump with a key: got %v", err)
+	}
+
+	sealed := encrypt(t, keyConfig(t), plain)
+	if _, err := decrypt(&Config{}, sealed); err == nil || !strings.Contains(err.Error(), "is encrypted") {
+		t.Errorf("encrypted dump without a key: got %v", err)
+	}
+}
+
+func TestEncryptionKeyTooShort(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "short.key")
+	ioutil.WriteFile(path, []byte("short"), 0600)
+	if _, err := (&Config{EncryptionKey: path}).encryptionKey(nil); err == nil {
+		t.Error("accepted a 5 byte key")
+	}
+}
+
+// vectors from rfc 7914 and the pbkdf2-hmac-sha256 test vectors
+func TestPbkdf2(t *testing.T) {
+
+	tests := []struct {
+		password, salt string
+		iterations     int
+		key            string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2([]byte(tt.password), []byte(tt.salt), tt.iterations))
+		if got != tt.key {
+			t.Errorf("pbkdf2(%s, %s, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.key)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// errorClass tells what part of a copy an error came from, and so how it
+// ends the run
+type errorClass string
+
+const (
+	sourceErrors errorClass = "source"      // reading the source, scrolls and dumps
+	destErrors   errorClass = "destination" // bulk requests and documents es didn't index
+	docErrors    errorClass = "document"    // documents that couldn't be decoded or transformed
+	outputErrors errorClass = "output"      // writing dumps, --state and --dead-letter
+	notices      errorClass = "notice"      // printed, the copy didn't fail because of them
+)
+
+// ErrorLog collects the errors of every worker without ever blocking them.
+// Up to Max errors of each class are printed as they happen, the rest are
+// only counted by kind for the summary
+type ErrorLog struct {
+	sync.Mutex
+	Max    int
+	counts map[errorClass]int
+	kinds  map[errorClass]map[string]int
+}
+
+func NewErrorLog(max int) *ErrorLog {
+	return &ErrorLog{
+		Max:    max,
+		counts: map[errorClass]int{},
+		kinds:  map[errorClass]map[string]int{},
+	}
+}
+
+// Add records err as an error of class
+func (l *ErrorLog) Add(class errorClass, err error) {
+	l.AddKind(class, "", err)
+}
+
+// AddKind records err with the kind it is counted under in the summary, ie
+// the type of error es gave for a document
+func (l *ErrorLog) AddKind(class errorClass, kind string, err error) {
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.counts[class]++
+	if kind != "" {
+		if l.kinds[class] == nil {
+			l.kinds[class] = map[string]int{}
+		}
+		l.kinds[class][kind]++
+	}
+
+	switch {
+	case l.Max <= 0 || l.counts[class] <= l.Max:
+		Println(err)
+	case l.counts[class] == l.Max+1:
+		Printf("more %s errors are only counted, see --errors-shown\n", class)
+	}
+}
+
+// Count is the number of errors of class
+func (l *ErrorLog) Count(class errorClass) int {
+
+	l.Lock()
+	defer l.Unlock()
+	return l.counts[class]
+}
+
+// Print writes how many errors of each class there were, by kind
+func (l *ErrorLog) Print() {
+
+	l.Lock()
+	defer l.Unlock()
+
+	parts := []string{}
+	for _, class := range []errorClass{sourceErrors, destErrors, docErrors, outputErrors} {
+		if l.counts[class] == 0 {
+			continue
+		}
+		part := fmt.Sprintf("%d %s", l.counts[class], class)
+		if kinds := l.kinds[class]; len(kinds) > 0 {
+			names := []string{}
+			for kind := range kinds {
+				names = append(names, kind)
+			}
+			sort.Strings(names)
+			counted := []string{}
+			for _, kind := range names {
+				counted = append(counted, fmt.Sprintf("%d %s", kinds[kind], kind))
+			}
+			part += " (" + strings.Join(counted, ", ") + ")"
+		}
+		parts = append(parts, part)
+	}
+	if len(parts) > 0 {
+		Println("errors:", strings.Join(parts, ", "))
+	}
+}
+
+// ExitStatus is the status the errors end the run with, 0 if none count
+func (l *ErrorLog) ExitStatus() int {
+
+	switch {
+	case l.Count(sourceErrors) > 0 || l.Count(destErrors) > 0 || l.Count(docErrors) > 0:
+		return exitPartial
+	case l.Count(outputErrors) > 0:
+		return exitFailure
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var existsPolicies = []string{"abort", "skip", "append"}
+
+// errIndexSkipped drops the documents of an index --if-exists skip left alone
+var errIndexSkipped = errors.New("index exists on the destination, skipped")
+
+// CheckExistsPolicy validates --if-exists, empty tries to create the index
+// anyway and fails
+func CheckExistsPolicy(policy string) error {
+
+	if policy == "" {
+		return nil
+	}
+	for _, p := range existsPolicies {
+		if p == policy {
+			return nil
+		}
+	}
+	return fmt.Errorf("bad --if-exists %s, use abort, skip or append", policy)
+}
+
+// indexExists asks the destination whether it has index
+func (c *Config) indexExists(index string) (bool, error) {
+
+	resp, err := c.Dst.Request("HEAD", "/"+index, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	}
+	return false, fmt.Errorf("failed checking for index %s: %s", index, resp.Status)
+}
+
+// existingIndexes are the indexes of idxs the destination already has
+func (c *Config) existingIndexes(idxs *Indexes) (map[string]bool, error) {
+
+	existing := map[string]bool{}
+	for name := range *idxs {
+		ok, err := c.indexExists(name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			existing[name] = true
+		}
+	}
+	return existing, nil
+}
+
+// SkipExisting leaves out the source indexes whose destination index exists,
+// so they are neither created nor scrolled, and returns the ones left
+func (c *Config) SkipExisting(idxs *Indexes, srcNames []string) ([]string, error) {
+
+	c.Skipped = map[string]bool{}
+	var left []string
+	for _, name := range srcNames {
+		dest := c.DestIndex(name)
+		ok, err := c.indexExists(dest)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			left = append(left, name)
+			continue
+		}
+		Println("skipping existing index: ", dest)
+		c.Skipped[dest] = true
+		delete(*idxs, name)
+	}
+
+	if c.Input == "" && len(left) > 0 {
+		sort.Strings(left)
+		c.IndexNames = strings.Join(left, ",")
+	}
+	return left, nil
+}
@@ -0,0 +1,12 @@
+package main
+
+// exit statuses, so scripts can tell what went wrong
+const (
+	exitFailure     = 1   // anything not listed below
+	exitUsage       = 2   // bad flags or files given on the command line
+	exitUnreachable = 3   // the source or destination can't be read from
+	exitIndexes     = 4   // indexes or their metadata couldn't be created
+	exitPartial     = 5   // some documents weren't copied
+	exitMismatch    = 6   // --verify or --verify-count found differences
+	exitStopped     = 130 // stopped by a signal
+)
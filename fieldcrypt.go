@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// NewFieldAead reads --field-key. Like --encryption-key it is expected to be
+// random, ie openssl rand 32 > fields.key, so it is only hashed
+func NewFieldAead(path string) (cipher.AEAD, error) {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < 16 {
+		return nil, fmt.Errorf("field key %s is too short, use at least 16 random bytes", path)
+	}
+
+	key := sha256.Sum256(b)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptValue seals the json of v with a random nonce, the result is the
+// base64 of nonce and ciphertext. The json keeps the type of the value
+func encryptValue(aead cipher.AEAD, v interface{}) (interface{}, error) {
+
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// decryptValue reverses encryptValue
+func decryptValue(aead cipher.AEAD, v interface{}) (interface{}, error) {
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypted field is not a string")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted field is not base64 of a sealed value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting field, wrong --field-key?")
+	}
+
+	var value interface{}
+	err = json.Unmarshal(plain, &value)
+	return value, err
+}
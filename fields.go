@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FieldMove renames a field of _source, paths are dotted like a.b
+type FieldMove struct {
+	From string
+	To   string
+}
+
+// ParseFieldMoves reads --rename-field rules, old.path:new.path
+func ParseFieldMoves(rules []string) ([]FieldMove, error) {
+
+	moves := []FieldMove{}
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("bad field rename %s, use old.path:new.path", rule)
+		}
+		moves = append(moves, FieldMove{parts[0], parts[1]})
+	}
+
+	return moves, nil
+}
+
+// ParseFieldPatterns reads --drop-field paths, names with wildcards or
+// /regexes/ like --indexes
+func ParseFieldPatterns(fields []string) ([]IndexPattern, error) {
+
+	patterns := []IndexPattern{}
+	for _, field := range fields {
+		p, err := ParseIndexPatterns(field)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p...)
+	}
+
+	return patterns, nil
+}
+
+// ParseFieldValues reads --set-field rules, key=value. Values that are valid
+// json like 3, true or {"a":1} keep their type, anything else is a string
+func ParseFieldValues(rules []string) (map[string]interface{}, error) {
+
+	values := map[string]interface{}{}
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("bad field %s, use key=value", rule)
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(parts[1]), &v); err != nil {
+			v = parts[1]
+		}
+		values[parts[0]] = v
+	}
+
+	return values, nil
+}
+
+// TransformSource applies the field rules to the _source of doc. Encrypted
+// fields of a restore are decrypted first, then fields are renamed, coerced,
+// dates normalized, fields dropped, masked and set, and encrypted last
+func (c *Config) TransformSource(doc *Document) error {
+
+	if len(c.Decrypted) > 0 {
+		decrypt := func(v interface{}) (interface{}, error) { return decryptValue(c.FieldAead, v) }
+		if err := rewriteFields("", doc.source, c.Decrypted, decrypt); err != nil {
+			return fmt.Errorf("document %s/%s: %s", doc.Index, doc.Id, err)
+		}
+	}
+
+	for _, move := range c.Moves {
+		if v, ok := takeField(doc.source, move.From); ok {
+			putField(doc.source, move.To, v)
+		}
+	}
+	for _, coercion := range c.Coercions {
+		typ := coercion.Type
+		coerce := func(v interface{}) (interface{}, error) { return coerceValue(typ, v) }
+		if err := rewriteFields("", doc.source, []IndexPattern{coercion.Field}, coerce); err != nil {
+			return fmt.Errorf("document %s/%s: %s", doc.Index, doc.Id, err)
+		}
+	}
+	for _, rule := range c.Dates {
+		rule := rule
+		normalize := func(v interface{}) (interface{}, error) { return normalizeDate(rule, c.DateOutput, v) }
+		if err := rewriteFields("", doc.source, []IndexPattern{rule.Field}, normalize); err != nil {
+			return fmt.Errorf("document %s/%s: %s", doc.Index, doc.Id, err)
+		}
+	}
+	if len(c.Drops) > 0 {
+		dropFields("", doc.source, c.Drops)
+	}
+	if len(c.Masks) > 0 {
+		maskFields("", doc.source, c.Masks)
+	}
+	for field, v := range c.Sets {
+		putField(doc.source, field, v)
+	}
+
+	if len(c.Encrypted) > 0 {
+		encrypt := func(v interface{}) (interface{}, error) { return encryptValue(c.FieldAead, v) }
+		if err := rewriteFields("", doc.source, c.Encrypted, encrypt); err != nil {
+			return fmt.Errorf("document %s/%s: %s", doc.Index, doc.Id, err)
+		}
+	}
+
+	return nil
+}
+
+// rewriteFields replaces the values of the fields of obj whose dotted path
+// matches, objects in arrays are looked into as well
+func rewriteFields(prefix string, obj map[string]interface{}, fields []IndexPattern, rewrite func(interface{}) (interface{}, error)) error {
+
+	for key, v := range obj {
+		path := prefix + key
+		if matchAny(fields, path) {
+			value, err := rewrite(v)
+			if err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			obj[key] = value
+			continue
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if err := rewriteFields(path+".", v, fields, rewrite); err != nil {
+				return err
+			}
+		case []interface{}:
+			for _, item := range v {
+				if item, ok := item.(map[string]interface{}); ok {
+					if err := rewriteFields(path+".", item, fields, rewrite); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// dropFields removes the fields of obj whose dotted path matches, objects in
+// arrays are looked into as well
+func dropFields(prefix string, obj map[string]interface{}, drops []IndexPattern) {
+
+	for key, v := range obj {
+		path := prefix + key
+		if matchAny(drops, path) {
+			delete(obj, key)
+			continue
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			dropFields(path+".", v, drops)
+		case []interface{}:
+			for _, item := range v {
+				if item, ok := item.(map[string]interface{}); ok {
+					dropFields(path+".", item, drops)
+				}
+			}
+		}
+	}
+}
+
+func matchAny(patterns []IndexPattern, name string) bool {
+
+	for _, p := range patterns {
+		if p.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// MoveMappingFields renames fields in the mappings of idxs the same way
+// TransformSource does in documents
+func (idxs *Indexes) MoveMappingFields(moves []FieldMove) {
+
+	for _, index := range *idxs {
+		mappings, _ := index.(map[string]interface{})["mappings"].(map[string]interface{})
+		roots := []map[string]interface{}{mappings}
+		if _, ok := mappings["properties"]; !ok {
+			// older versions nest mappings under the document type
+			roots = roots[:0]
+			for _, typ := range mappings {
+				if typ, ok := typ.(map[string]interface{}); ok {
+					roots = append(roots, typ)
+				}
+			}
+		}
+
+		for _, root := range roots {
+			for _, move := range moves {
+				if v, ok := takeField(root, propertiesPath(move.From)); ok {
+					putField(root, propertiesPath(move.To), v)
+				}
+			}
+		}
+	}
+}
+
+// propertiesPath is where a field is in a mapping, a.b is in
+// properties.a.properties.b
+func propertiesPath(field string) string {
+	return "properties." + strings.Replace(field, ".", ".properties.", -1)
+}
+
+// takeField removes a dotted field from obj and returns it. Objects left
+// empty are removed as well
+func takeField(obj map[string]interface{}, field string) (interface{}, bool) {
+
+	if v, ok := obj[field]; ok {
+		delete(obj, field)
+		return v, true
+	}
+
+	parts := strings.SplitN(field, ".", 2)
+	if len(parts) < 2 {
+		return nil, false
+	}
+	inner, ok := obj[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	v, ok := takeField(inner, parts[1])
+	if ok && len(inner) == 0 {
+		delete(obj, parts[0])
+	}
+	return v, ok
+}
+
+// putField sets a dotted field in obj, creating the objects on the way
+func putField(obj map[string]interface{}, field string, v interface{}) {
+
+	parts := strings.Split(field, ".")
+	for _, part := range parts[:len(parts)-1] {
+		inner, ok := obj[part].(map[string]interface{})
+		if !ok {
+			inner = map[string]interface{}{}
+			obj[part] = inner
+		}
+		obj = inner
+	}
+	obj[parts[len(parts)-1]] = v
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// size of each chunk in a resumable upload, has to be a multiple of 256k
+const gcsChunkSize = 16 << 20
+
+// GCSObject is an object in a google cloud storage bucket
+type GCSObject struct {
+	Bucket string
+	Key    string
+	Token  string
+	Client *http.Client
+}
+
+// NewGCSObject parses gs://bucket/key. The access token comes from
+// GOOGLE_OAUTH_ACCESS_TOKEN, the service account in
+// GOOGLE_APPLICATION_CREDENTIALS, or gcloud, in that order
+func (c *Config) NewGCSObject(location string) (*GCSObject, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("bad gcs location %s, should be gs://bucket/key", location)
+	}
+
+	token, err := gcsToken(c.Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting gcs access token: %s", err)
+	}
+	masker.Add(token)
+
+	return &GCSObject{
+		Bucket: bucket,
+		Key:    key,
+		Token:  token,
+		Client: c.Client,
+	}, nil
+}
+
+// Request makes an authorized request and fails on anything but expected
+// status codes
+func (o *GCSObject) Request(method, url string, body []byte, headers map[string]string, expect ...int) (*http.Response, error) {
+
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Authorization", "Bearer "+o.Token)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range expect {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	return nil, fmt.Errorf("gcs %s %s failed: %s %s", method, o.Key, resp.Status, string(b))
+}
+
+// NewReader streams the object
+func (o *GCSObject) NewReader() (io.ReadCloser, error) {
+
+	resp, err := o.Request("GET", fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media",
+		o.Bucket, url.PathEscape(o.Key)), nil, nil, 200)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// NewWriter uploads everything written to it as the object using a
+// resumable upload, one chunk at a time
+func (o *GCSObject) NewWriter() io.WriteCloser {
+	return &GCSWriter{obj: o}
+}
+
+// GCSWriter buffers a chunk at a time and uploads it
+type GCSWriter struct {
+	obj     *GCSObject
+	buf     bytes.Buffer
+	session string // resumable upload session url
+	offset  int64
+}
+
+func (w *GCSWriter) Write(p []byte) (int, error) {
+
+	n, _ := w.buf.Write(p)
+	for w.buf.Len() > gcsChunkSize {
+		if err := w.uploadChunk(w.buf.Next(gcsChunkSize), false); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (w *GCSWriter) uploadChunk(chunk []byte, last bool) error {
+
+	if w.session == "" {
+		resp, err := w.obj.Request("POST", fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=resumable&name=%s",
+			w.obj.Bucket, url.QueryEscape(w.obj.Key)), nil, nil, 200)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if w.session = resp.Header.Get("Location"); w.session == "" {
+			return fmt.Errorf("gcs didnt return an upload session for %s", w.obj.Key)
+		}
+	}
+
+	// the total is only known once the last chunk is sent
+	total := "*"
+	if last {
+		total = fmt.Sprint(w.offset + int64(len(chunk)))
+	}
+	contentRange := fmt.Sprintf("bytes %d-%d/%s", w.offset, w.offset+int64(len(chunk))-1, total)
+	if len(chunk) == 0 {
+		contentRange = fmt.Sprintf("bytes */%s", total)
+	}
+
+	resp, err := w.obj.Request("PUT", w.session, chunk, map[string]string{"Content-Range": contentRange}, 200, 201, 308)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	w.offset += int64(len(chunk))
+
+	return nil
+}
+
+func (w *GCSWriter) Close() error {
+	return w.uploadChunk(w.buf.Bytes(), true)
+}
+
+// gcsToken finds an oauth2 access token for the storage api
+func gcsToken(client *http.Client) (string, error) {
+
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return serviceAccountToken(client, path)
+	}
+
+	out, err := exec.Command("gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return "", fmt.Errorf("set GOOGLE_OAUTH_ACCESS_TOKEN or GOOGLE_APPLICATION_CREDENTIALS, or log in with gcloud: %s", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// serviceAccountToken exchanges a signed jwt for an access token using a
+// service account key file
+func serviceAccountToken(client *http.Client, path string) (string, error) {
+
+	account := struct {
+		Email      string `json:"client_email"`
+		PrivateKey string `json:"private_key"`
+		TokenUri   string `json:"token_uri"`
+	}{}
+	if err := readJsonFile(path, &account); err != nil {
+		return "", err
+	}
+	if account.TokenUri == "" {
+		account.TokenUri = "https://oauth2.googleapis.com/token"
+	}
+
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("no private key in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("private key in %s is not rsa", path)
+	}
+
+	now := time.Now().Unix()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   account.Email,
+		"scope": "https://www.googleapis.com/auth/devstorage.read_write",
+		"aud":   account.TokenUri,
+		"iat":   now,
+		"exp":   now + 3600,
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.PostForm(account.TokenUri, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return "", fmt.Errorf("token exchange failed: %s", string(b))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	return token.AccessToken, nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Host is an elasticsearch instance along with the credentials to use when
+// talking to it
+type Host struct {
+	Url     string
+	Auth    string // user:pass for basic auth
+	ApiKey  string // base64 encoded id:key
+	Token   string // sent as a bearer token
+	Headers http.Header
+	Client  *http.Client
+	Signer  *Signer // sign requests with aws sigv4
+}
+
+// NewHost parses the url of an elasticsearch instance. Credentials can be
+// given either in auth as user:pass or in the url itself
+func NewHost(rawurl, auth string) (*Host, error) {
+
+	if !strings.Contains(rawurl, "://") {
+		if cloudUrl, err := DecodeCloudId(rawurl); err == nil {
+			rawurl = cloudUrl
+		}
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("bad url %s: %s", rawurl, err)
+	}
+
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok && auth == "" {
+			auth = u.User.Username() + ":" + pass
+		} else if auth == "" {
+			auth = u.User.Username()
+		}
+		u.User = nil
+	}
+
+	return &Host{
+		Url:    strings.TrimRight(u.String(), "/"),
+		Auth:   auth,
+		Client: http.DefaultClient,
+	}, nil
+}
+
+// DecodeCloudId turns an elastic cloud id into the url of its elasticsearch
+// endpoint. The id is name:base64(domain[:port]$es_uuid$kibana_uuid)
+func DecodeCloudId(id string) (string, error) {
+
+	i := strings.Index(id, ":")
+	if i < 0 {
+		return "", fmt.Errorf("bad cloud id %s", id)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(id[i+1:])
+	if err != nil {
+		return "", fmt.Errorf("bad cloud id %s: %s", id, err)
+	}
+
+	parts := strings.Split(string(decoded), "$")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("bad cloud id %s", id)
+	}
+
+	domain, port := parts[0], "443"
+	if j := strings.LastIndex(domain, ":"); j >= 0 {
+		domain, port = domain[:j], domain[j+1:]
+	}
+
+	return fmt.Sprintf("https://%s.%s:%s", parts[1], domain, port), nil
+}
+
+// SetApiKey sets the key sent as "Authorization: ApiKey". Keys given as id:key
+// are base64 encoded the way elasticsearch expects them
+func (h *Host) SetApiKey(key string) {
+
+	if strings.Contains(key, ":") {
+		key = base64.StdEncoding.EncodeToString([]byte(key))
+	}
+	h.ApiKey = key
+}
+
+// AddHeaders parses key:value pairs into headers sent with every request
+func (h *Host) AddHeaders(headers []string) error {
+
+	if h.Headers == nil {
+		h.Headers = http.Header{}
+	}
+
+	for _, header := range headers {
+		i := strings.Index(header, ":")
+		if i <= 0 {
+			return fmt.Errorf("bad header %s, should be key:value", header)
+		}
+		h.Headers.Add(strings.TrimSpace(header[:i]), strings.TrimSpace(header[i+1:]))
+	}
+
+	return nil
+}
+
+// Request makes a request against the host, path is relative to the host url
+func (h *Host) Request(method, path string, body io.Reader) (*http.Response, error) {
+
+	// the sigv4 signature covers the body so it has to be read up front
+	var payload []byte
+	if h.Signer != nil && body != nil {
+		var err error
+		if payload, err = ioutil.ReadAll(body); err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, h.Url+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// es 6 and later refuse bodies without it, older ones don't mind it
+	req.Header.Set("Content-Type", "application/json")
+	for name, vals := range h.Headers {
+		req.Header[name] = vals
+	}
+
+	if h.ApiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+h.ApiKey)
+	} else if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	} else if h.Auth != "" {
+		user, pass := h.Auth, ""
+		if i := strings.Index(h.Auth, ":"); i >= 0 {
+			user, pass = h.Auth[:i], h.Auth[i+1:]
+		}
+		req.SetBasicAuth(user, pass)
+	}
+
+	// signing has to happen last, once all the other headers are set
+	if h.Signer != nil {
+		h.Signer.Sign(req, payload, time.Now())
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = drainer{resp.Body}
+	return resp, nil
+}
+
+// drainer reads what is left of a response before closing it, the
+// connection only goes back to the pool once the body was read to the end.
+// Responses are mostly decoded as json which stops before the last newline
+type drainer struct {
+	io.ReadCloser
+}
+
+func (d drainer) Close() error {
+	io.CopyN(ioutil.Discard, d.ReadCloser, maxDrain)
+	return d.ReadCloser.Close()
+}
+
+// more than this is left to the connection being closed instead
+const maxDrain = 256 << 10
+
+// SetupHosts creates the source and destination hosts from the command line.
+// Either host is left nil if its url wasnt given
+func (c *Config) SetupHosts() (err error) {
+
+	if c.Client, err = c.NewClient(); err != nil {
+		return err
+	}
+	// the hosts share the transport, each with its own timeout
+	srcClient := &http.Client{Transport: c.Client.Transport, Timeout: time.Duration(c.ScrollTimeout) * time.Second}
+	dstClient := &http.Client{Transport: c.Client.Transport, Timeout: time.Duration(c.BulkTimeout) * time.Second}
+
+	// credentials in the url are moved out so they dont end up in any output
+	if c.SrcEs != "" {
+		if c.Src, err = NewHost(c.SrcEs, c.SrcAuth); err != nil {
+			return err
+		}
+		c.Src.Client = srcClient
+		c.Src.SetApiKey(c.SrcApiKey)
+		c.Src.Token = c.SrcToken
+		if err := c.Src.AddHeaders(c.SrcHeaders); err != nil {
+			return err
+		}
+		c.SrcEs = c.Src.Url
+	}
+
+	if c.DstEs != "" {
+		if c.Dst, err = NewHost(c.DstEs, c.DstAuth); err != nil {
+			return err
+		}
+		c.Dst.Client = dstClient
+		c.Dst.SetApiKey(c.DstApiKey)
+		c.Dst.Token = c.DstToken
+		if err := c.Dst.AddHeaders(c.DstHeaders); err != nil {
+			return err
+		}
+		c.DstEs = c.Dst.Url
+	}
+
+	// sign requests to any host that doesnt have credentials of its own
+	if c.AwsRegion != "" {
+		signer, err := NewSigner(c.AwsRegion, c.AwsProfile)
+		if err != nil {
+			return err
+		}
+		for _, host := range []*Host{c.Src, c.Dst} {
+			if host != nil && host.Auth == "" && host.ApiKey == "" && host.Token == "" {
+				host.Signer = signer
+			}
+		}
+	}
+
+	// never print any of the credentials we were given
+	for _, host := range []*Host{c.Src, c.Dst} {
+		if host != nil {
+			masker.AddHost(host)
+		}
+	}
+
+	return nil
+}
+
+// NewClient builds the http client shared by all requests to both hosts
+func (c *Config) NewClient() (*http.Client, error) {
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.Insecure,
+	}
+
+	if c.CACert != "" {
+		pem, err := ioutil.ReadFile(c.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading ca cert: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, fmt.Errorf("--client-cert and --client-key must be used together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading client cert: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = (&net.Dialer{
+		Timeout:   time.Duration(c.ConnectTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = time.Duration(c.ConnectTimeout) * time.Second
+
+	// every worker and slice keeps a connection open to each host instead of
+	// the two the default pool holds on to
+	transport.MaxIdleConnsPerHost = c.Workers + c.Slices + 2
+	transport.MaxIdleConns = 2 * transport.MaxIdleConnsPerHost
+
+	// http/2 is negotiated with hosts offering it over tls, ie proxies and
+	// load balancers in front of es
+	if c.Http1 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	// without --proxy the transport still honors HTTP_PROXY/HTTPS_PROXY and
+	// NO_PROXY from the environment
+	if c.Proxy != "" {
+		proxy, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("bad proxy url %s: %s", c.Proxy, err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %s, use http, https or socks5", proxy.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// times a dropped download is resumed before giving up
+const httpRetries = 5
+
+// HTTPReader streams a dump from an http(s) url, ie a presigned s3 url or an
+// artifact server. When the connection drops the download resumes where it
+// stopped with a range request
+type HTTPReader struct {
+	Url    string
+	Client *http.Client
+	body   io.ReadCloser
+	offset int64
+	etag   string // to make sure a resumed download is of the same file
+}
+
+// NewHTTPReader starts downloading location. Query strings are masked since
+// they usually carry the signature of a presigned url
+func (c *Config) NewHTTPReader(location string) (*HTTPReader, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	masker.Add(u.RawQuery)
+
+	h := &HTTPReader{Url: location, Client: c.Client}
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *HTTPReader) open() error {
+
+	req, err := http.NewRequest("GET", h.Url, nil)
+	if err != nil {
+		return err
+	}
+	if h.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", h.offset))
+		if h.etag != "" {
+			req.Header.Set("If-Range", h.etag)
+		}
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resp.StatusCode == 200 && h.offset == 0:
+		h.etag = resp.Header.Get("ETag")
+	case resp.StatusCode == 206:
+	case resp.StatusCode == 200:
+		// no support for ranges, skip what was already read unless the
+		// file changed in the meantime
+		if h.etag != "" && resp.Header.Get("ETag") != h.etag {
+			resp.Body.Close()
+			return fmt.Errorf("%s changed while it was being read", h.Url)
+		}
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, h.offset); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	default:
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return fmt.Errorf("failed getting %s: %s %s", h.Url, resp.Status, string(b))
+	}
+
+	h.body = resp.Body
+	return nil
+}
+
+func (h *HTTPReader) Read(p []byte) (int, error) {
+
+	for attempt := 1; ; attempt++ {
+		var err error
+		if h.body == nil {
+			err = h.open()
+		}
+		if err == nil {
+			var n int
+			n, err = h.body.Read(p)
+			h.offset += int64(n)
+			if err == nil || err == io.EOF {
+				return n, err
+			}
+			// the error comes back on the next read
+			if n > 0 {
+				return n, nil
+			}
+			h.body.Close()
+			h.body = nil
+		}
+
+		if attempt > httpRetries {
+			return 0, fmt.Errorf("failed reading %s at byte %d: %s", h.Url, h.offset, err)
+		}
+		Printf("reading %s failed at byte %d, resuming: %s\n", h.Url, h.offset, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+}
+
+func (h *HTTPReader) Close() error {
+
+	if h.body == nil {
+		return nil
+	}
+	return h.body.Close()
+}
+
+// trimQuery drops the query string of an http(s) location so its extension
+// can be checked
+func trimQuery(location string) string {
+
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return location
+	}
+	if i := strings.Index(location, "?"); i >= 0 {
+		return location[:i]
+	}
+	return location
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// CheckIdStrategy validates --id-strategy
+func CheckIdStrategy(strategy string) error {
+
+	switch {
+	case strategy == "keep", strategy == "autogen", strategy == "hash":
+		return nil
+	case strings.HasPrefix(strategy, "field:") && len(strategy) > len("field:"):
+		return nil
+	}
+	return fmt.Errorf("unknown --id-strategy %s, use keep, autogen, hash or field:path", strategy)
+}
+
+// DestId is the _id doc gets on the destination. autogen leaves it empty so
+// es generates one, hash uses the sha1 of _source so identical documents end
+// up as one and field:path takes it from a field of the document
+func (c *Config) DestId(doc *Document) (string, error) {
+
+	switch {
+	case c.IdStrategy == "autogen":
+		return "", nil
+	case c.IdStrategy == "hash":
+		// maps are encoded with sorted keys, the same source gives the same id
+		b, err := json.Marshal(doc.source)
+		if err != nil {
+			return "", err
+		}
+		sum := sha1.Sum(b)
+		return hex.EncodeToString(sum[:]), nil
+	case strings.HasPrefix(c.IdStrategy, "field:"):
+		field := strings.TrimPrefix(c.IdStrategy, "field:")
+		v, ok := lookupField(doc.source, field)
+		if !ok || v == nil {
+			return "", fmt.Errorf("document %s/%s has no %s for its id", doc.Index, doc.Id, field)
+		}
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+
+	return doc.Id, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// CopyLifecycle keeps the index.lifecycle settings of the source indexes,
+// which copying the settings leaves out otherwise. Without an
+// origination date ilm would count the age of an index from its creation on
+// the destination, so the creation date of the source is used for it. The
+// rollover alias gets its --rename-alias name, and is created with the
+// aliases even without --aliases
+func (c *Config) CopyLifecycle(idxs *Indexes) error {
+
+	if len(*idxs) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range *idxs {
+		names = append(names, name)
+	}
+
+	all := map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}{}
+	if err := c.getJson(c.Src, "/"+strings.Join(names, ",")+"/_settings?flat_settings=true", &all); err != nil {
+		return err
+	}
+
+	for name, index := range *idxs {
+		settings := all[name].Settings
+		lifecycle := map[string]interface{}{}
+		for key, val := range settings {
+			if strings.HasPrefix(key, "index.lifecycle.") {
+				lifecycle[strings.TrimPrefix(key, "index.lifecycle.")] = val
+			}
+		}
+		if lifecycle["name"] == nil {
+			continue
+		}
+		if _, ok := lifecycle["origination_date"]; !ok && settings["index.creation_date"] != nil {
+			lifecycle["origination_date"] = settings["index.creation_date"]
+		}
+		if alias, ok := lifecycle["rollover_alias"].(string); ok && alias != "" {
+			lifecycle["rollover_alias"] = c.DestAlias(alias)
+			if c.TimeIndex == "" && c.IndexTmpl == nil {
+				if c.Rollover == nil {
+					c.Rollover = map[string]string{}
+				}
+				c.Rollover[name] = alias
+			}
+		}
+
+		idx := index.(map[string]interface{})
+		if _, ok := idx["settings"].(map[string]interface{}); !ok {
+			idx["settings"] = map[string]interface{}{}
+		}
+		if _, ok := idx["settings"].(map[string]interface{})["index"].(map[string]interface{}); !ok {
+			idx["settings"].(map[string]interface{})["index"] = map[string]interface{}{}
+		}
+		idx["settings"].(map[string]interface{})["index"].(map[string]interface{})["lifecycle"] = lifecycle
+	}
+	return nil
+}
+
+// StripLifecycle drops the index.lifecycle settings of every index, so ilm
+// leaves the copies alone
+func (idxs *Indexes) StripLifecycle() {
+
+	for _, index := range *idxs {
+		stripLifecycle(index.(map[string]interface{})["settings"])
+	}
+}
+
+// stripLifecycle drops index.lifecycle from settings, nested or flat
+func stripLifecycle(settings interface{}) {
+
+	s, ok := settings.(map[string]interface{})
+	if !ok {
+		return
+	}
+	for key := range s {
+		if strings.HasPrefix(key, "index.lifecycle.") {
+			delete(s, key)
+		}
+	}
+	if index, ok := s["index"].(map[string]interface{}); ok {
+		for key := range index {
+			if key == "lifecycle" || strings.HasPrefix(key, "lifecycle.") {
+				delete(index, key)
+			}
+		}
+	}
+}
+
+// CreateIlmPolicies copies the ilm policies the indexes of idxs and the data
+// streams are managed by before any index is created. Policies the
+// destination has already are kept unless --force
+func (c *Config) CreateIlmPolicies(idxs Indexes) error {
+
+	wanted := map[string]bool{}
+	for _, index := range idxs {
+		if name := lifecycleName(index.(map[string]interface{})["settings"]); name != "" {
+			wanted[name] = true
+		}
+	}
+	for _, stream := range c.Streams {
+		if stream.IlmPolicy != "" {
+			wanted[stream.IlmPolicy] = true
+		}
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	dst := hostInfo(c.Dst)
+	if major, minor, _ := dst.Es(); dst.OpenSearch() || major < 6 || major == 6 && minor < 6 {
+		return fmt.Errorf("ilm policies need es 6.6 or later on the destination, not %s. use --strip-lifecycle", dst)
+	}
+
+	var names []string
+	for name := range wanted {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// {"policy": {"version": 1, "modified_date": ..., "policy": {"phases": ...}}}
+	policies := map[string]struct {
+		Policy interface{} `json:"policy"`
+	}{}
+	if err := c.getJson(c.Src, "/_ilm/policy/"+strings.Join(names, ","), &policies); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		policy, ok := policies[name]
+		if !ok {
+			return fmt.Errorf("ilm policy %s is used but not on the source", name)
+		}
+
+		if !c.Destructive {
+			resp, err := c.Dst.Request("GET", "/_ilm/policy/"+name, nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				Println("keeping existing ilm policy: ", name)
+				continue
+			}
+		}
+
+		body, err := json.Marshal(map[string]interface{}{"policy": policy.Policy})
+		if err != nil {
+			return err
+		}
+		resp, err := c.Dst.Request("PUT", "/_ilm/policy/"+name, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("failed creating ilm policy %s: %s", name, string(b))
+		}
+		Println("created ilm policy: ", name)
+	}
+	return nil
+}
+
+// lifecycleName is the ilm policy named in settings, nested or flat
+func lifecycleName(settings interface{}) string {
+
+	s, ok := settings.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if name, ok := s["index.lifecycle.name"].(string); ok {
+		return name
+	}
+	index, ok := s["index"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if name, ok := index["lifecycle.name"].(string); ok {
+		return name
+	}
+	if lifecycle, ok := index["lifecycle"].(map[string]interface{}); ok {
+		name, _ := lifecycle["name"].(string)
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// IndexPattern matches index names against an entry of --indexes, either a
+// plain name, a wildcard like logstash-2023.* or a regex like /^metrics-\d+$/
+type IndexPattern struct {
+	Name string
+	re   *regexp.Regexp // nil for plain names
+}
+
+// ParseIndexPatterns splits a comma separated list of index names and
+// patterns. Commas inside a regex don't split it, ie /^a{1,3}$/
+func ParseIndexPatterns(list string) ([]IndexPattern, error) {
+
+	var entries []string
+	for _, entry := range strings.Split(list, ",") {
+		last := len(entries) - 1
+		if last >= 0 && isOpenRegex(entries[last]) {
+			entries[last] += "," + entry
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	var patterns []IndexPattern
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// <logstash-{now/d-1d}> becomes the name for today, it may
+		// still have a wildcard
+		if isDateMath(entry) {
+			name, err := ResolveDateMath(entry, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			entry = name
+		}
+
+		p := IndexPattern{Name: entry}
+		switch {
+		case isRegex(entry):
+			re, err := regexp.Compile(entry[1 : len(entry)-1])
+			if err != nil {
+				return nil, fmt.Errorf("bad index pattern %s: %s", entry, err)
+			}
+			p.re = re
+		case strings.Contains(entry, "*"):
+			p.re = regexp.MustCompile("^" + strings.Replace(regexp.QuoteMeta(entry), `\*`, ".*", -1) + "$")
+		}
+		patterns = append(patterns, p)
+	}
+
+	return patterns, nil
+}
+
+func isRegex(entry string) bool {
+	return len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/")
+}
+
+func isOpenRegex(entry string) bool {
+	return strings.HasPrefix(entry, "/") && !isRegex(entry)
+}
+
+// Match tells if name is selected by the pattern
+func (p IndexPattern) Match(name string) bool {
+
+	if p.re == nil {
+		return p.Name == name
+	}
+	return p.re.MatchString(name)
+}
+
+// IsPattern tells if the entry has to be resolved against the index list
+func (p IndexPattern) IsPattern() bool {
+	return p.re != nil
+}
+
+// ParseRenames reads --rename, a comma separated list of old:new pairs
+func ParseRenames(list string) (map[string]string, error) {
+
+	renames := map[string]string{}
+	for _, pair := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("bad rename %s, use old:new", pair)
+		}
+		if _, ok := renames[parts[0]]; ok {
+			return nil, fmt.Errorf("%s is renamed twice", parts[0])
+		}
+		renames[parts[0]] = parts[1]
+	}
+
+	return renames, nil
+}
+
+// DestIndex is the name index is written to on the destination, after
+// --rename and with --dest-prefix and --dest-suffix
+func (c *Config) DestIndex(index string) string {
+
+	if name, ok := c.Renames[index]; ok {
+		index = name
+	}
+	return c.DestPrefix + index + c.DestSuffix
+}
+
+// DestIndexFor is the index doc is written to on the destination, from
+// --dest-index-template, --time-index or DestIndex. The first two are
+// created with the metadata of the source index when first used. Documents
+// of a backing index go to its data stream
+func (c *Config) DestIndexFor(doc *Document) (string, error) {
+
+	switch {
+	case c.IndexTmpl != nil:
+		return c.TemplateIndexFor(doc)
+	case c.TimeIndex != "":
+		return c.TimeIndexFor(doc)
+	}
+	name := doc.Index
+	if stream, ok := c.Backing[name]; ok {
+		name = stream
+	}
+	index := c.DestIndex(name)
+	if c.Skipped[index] {
+		return "", errIndexSkipped
+	}
+	return index, nil
+}
+
+// Rename moves the metadata of every index under its destination name
+func (idxs *Indexes) Rename(destIndex func(string) string) {
+
+	moved := Indexes{}
+	for name, index := range *idxs {
+		if newName := destIndex(name); newName != name {
+			moved[newName] = index
+			delete(*idxs, name)
+		}
+	}
+	for name, index := range moved {
+		(*idxs)[name] = index
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LazyIndexes creates destination indexes as the first document for them
+// shows up, for --time-index and --dest-index-template
+type LazyIndexes struct {
+	sync.Mutex
+	Meta    Indexes // source indexes, their metadata is used for new indexes
+	created map[string]error
+}
+
+func NewLazyIndexes(meta Indexes) *LazyIndexes {
+	return &LazyIndexes{Meta: meta, created: map[string]error{}}
+}
+
+// Create makes index on the destination with the metadata of source, once.
+// Later calls return the error of the first
+func (l *LazyIndexes) Create(c *Config, index, source string) error {
+
+	l.Lock()
+	defer l.Unlock()
+
+	if err, done := l.created[index]; done {
+		return err
+	}
+
+	err := l.create(c, index, l.Meta[source])
+	l.created[index] = err
+	return err
+}
+
+func (l *LazyIndexes) create(c *Config, index string, meta interface{}) error {
+
+	if c.DocsOnly {
+		return nil
+	}
+	if meta == nil {
+		meta = map[string]interface{}{}
+	}
+
+	idxs := Indexes{index: meta}
+	// documents of an index left alone are dropped, abort stops reading too
+	if (c.IfExists == "skip" || c.IfExists == "abort") && !c.Destructive {
+		exists, err := c.indexExists(index)
+		if err != nil {
+			return err
+		}
+		if exists && c.IfExists == "abort" {
+			c.Abort(fmt.Errorf("index exists on the destination: %s", index))
+			return errIndexSkipped
+		}
+		if exists {
+			Println("skipping existing index: ", index)
+			return errIndexSkipped
+		}
+	}
+	if c.Destructive {
+		if err := c.DeleteIndexes(&idxs); err != nil {
+			return err
+		}
+	}
+	return c.CreateIndexes(&idxs)
+}
@@ -0,0 +1,66 @@
+package main
+
+import "sync"
+
+// Limit caps the number of documents copied, in total and for each index
+type Limit struct {
+	sync.Mutex
+	Max      int // 0 for no limit
+	PerIndex int // 0 for no limit
+	Indexes  int // indexes being copied, 0 if unknown
+	copied   int
+	counts   map[string]int
+	full     int // indexes that reached PerIndex
+}
+
+// NewLimit returns nil when neither limit is set
+func NewLimit(max, perIndex, indexes int) *Limit {
+
+	if max <= 0 && perIndex <= 0 {
+		return nil
+	}
+	return &Limit{Max: max, PerIndex: perIndex, Indexes: indexes, counts: map[string]int{}}
+}
+
+// Take counts a document of index, false means it is over the limit and
+// should be skipped
+func (l *Limit) Take(index string) bool {
+
+	if l == nil {
+		return true
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.Max > 0 && l.copied >= l.Max {
+		return false
+	}
+	if l.PerIndex > 0 {
+		if l.counts[index] >= l.PerIndex {
+			return false
+		}
+		if l.counts[index]++; l.counts[index] == l.PerIndex {
+			l.full++
+		}
+	}
+	l.copied++
+
+	return true
+}
+
+// Reached tells if no more documents will be taken, so reading can stop
+func (l *Limit) Reached() bool {
+
+	if l == nil {
+		return false
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.Max > 0 && l.copied >= l.Max {
+		return true
+	}
+	return l.PerIndex > 0 && l.Indexes > 0 && l.full >= l.Indexes
+}
@@ -2,33 +2,42 @@ package main
 
 import (
 	"bytes"
+	"crypto/cipher"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	pb "github.com/cheggaaa/pb"
+	"github.com/dop251/goja"
 	goflags "github.com/jessevdk/go-flags"
 )
 
 type Indexes map[string]interface{}
 
 type Document struct {
-	Index  string                 `json:"_index"`
-	Type   string                 `json:"_type"`
-	Id     string                 `json:"_id"`
-	source map[string]interface{} `json:"_source"`
+	Index   string                 `json:"_index"`
+	Type    string                 `json:"_type,omitempty"`
+	Id      string                 `json:"_id,omitempty"`
+	Routing string                 `json:"routing,omitempty"`
+	Parent  string                 `json:"parent,omitempty"`
+	source  map[string]interface{} `json:"_source"`
 }
 
 type Scroll struct {
 	ScrollId string `json:"_scroll_id"`
 	TimedOut bool   `json:"timed_out"`
 	Hits     struct {
-		Total int           `json:"total"`
+		Total HitsTotal     `json:"total"`
 		Docs  []interface{} `json:"hits"`
 	} `json:"hits"`
 	Shards struct {
@@ -37,6 +46,21 @@ type Scroll struct {
 			Reason string `json:"reason"`
 		} `json:"failures"`
 	} `json:"_shards"`
+	Count   int  `json:"-"` // documents scrolled so far
+	Expired bool `json:"-"` // es lost the scroll context
+	Partial bool `json:"-"` // stopped on an error, not at the end
+	First   bool `json:"-"` // Hits has the first page, when not scanning
+
+	// what is scrolled, to start over when es lost the context
+	indexes   string
+	slice     map[string]int
+	restarts  int
+	restarted bool // documents are sent again
+}
+
+// DocSource feeds documents into DocChan one page at a time
+type DocSource interface {
+	Next(c *Config) (done bool)
 }
 
 type ClusterHealth struct {
@@ -47,24 +71,175 @@ type ClusterHealth struct {
 type Config struct {
 	FlushLock sync.Mutex
 	DocChan   chan map[string]interface{}
-	ErrChan   chan error
+	Errors    *ErrorLog
 	Uid       string // es scroll uid
+	Src       *Host
+	Dst       *Host     // nil when dumping to a file
+	Out       DocWriter // set when dumping to a file
+	Client    *http.Client
+	Scan      bool // search_type=scan on the source, before es 2.1
+	TrackHits bool // the source stops counting hits unless told, es 7 and later
+	AskMeta   bool // the source only returns _routing and _parent when asked, before es 5
+	Typeless  bool // the destination takes no _type, es 7 and later and opensearch
+	NoParent  bool // the destination has no _parent, es 6 and later
+	Ids       map[string]bool
+	Skipped   map[string]bool
+	Parents   map[string]map[string]string
+	Rollover  map[string]string // source index to the alias ilm rolls it over by
+	Streams   []*DataStream
+	Backing   map[string]string // backing index to its data stream
+	Renames   map[string]string
+	Aliases   map[string]string
+	TypeNames map[string]string
+	Routings  map[string]string
+	Moves     []FieldMove
+	Drops     []IndexPattern
+	Sets      map[string]interface{}
+	Masks     []MaskRule
+	FieldAead cipher.AEAD
+	Encrypted []IndexPattern
+	Decrypted []IndexPattern
+	Coercions []Coercion
+	Dates     []DateRule
+	Program   *goja.Program
+	Lazy      *LazyIndexes
+	IndexTmpl *template.Template
+	Columns   []Column   // columns of parquet and csv dumps
+	Avro      *AvroField // schema of avro dumps
+	Limit     *Limit     // nil unless --max-docs is set
+	Sampling  float64    // share of documents kept by --sample, 0 keeps all
+	Failed    int        // documents the destination didn't index
+	Existing  int        // documents a resumed copy found on the destination
+	Dead      *DeadLetter
+	State     *State         // nil unless --state is set
+	Flushed   sync.WaitGroup // workers that reached a checkpoint
+	Restarts  sync.Mutex     // one reader starts over at a time
+	DocRate   *RateLimit     // nil unless --rate-limit-docs is set
+	ByteRate  *RateLimit     // nil unless --rate-limit-bytes is set
+	Backoff   *Backoff       // shrinks bulk requests while es is busy
+	Retries   *RetryStats    // documents es made the copy send again
+	stopped   int32          // set by a signal or Abort, see Stopped
+	progress  int64          // unix nanoseconds documents last moved, see Progressed
+	Partial   bool           // the source stopped on an error
 
 	// config options
-	SrcEs             string `short:"s" long:"source"  description:"source elasticsearch instance" required:"true"`
-	DstEs             string `short:"d" long:"dest"    description:"destination elasticsearch instance" required:"true"`
-	DocBufferCount    int    `short:"c" long:"count"   description:"number of documents at a time: ie \"size\" in the scroll request" default:"100"`
-	ScrollTime        string `short:"t" long:"time"    description:"scroll time" default:"1m"`
-	Destructive       bool   `short:"f" long:"force"   description:"delete destination index before copying" default:"false"`
-	ShardsCount       int    `long:"shards"            description:"set a number of shards on newly created indexes"`
-	DocsOnly          bool   `long:"docs-only"         description:"load documents only, do not try to recreate indexes" default:"false"`
-	CreateIndexesOnly bool   `long:"index-only"        description:"only create indexes, do not load documents" default:"false"`
-	EnableReplication bool   `long:"replicate"         description:"enable replication while indexing into the new indexes" default:"false"`
-	IndexNames        string `short:"i" long:"indexes" description:"list of indexes to copy, comma separated" default:"_all"`
-	CopyAllIndexes    bool   `short:"a" long:"all"     description:"copy indexes starting with . and _" default:"false"`
-	Workers           int    `short:"w" long:"workers" description:"concurrency" default:"1"`
-	CopySettings      bool   `long:"settings"          description:"copy sharding settings from source" default:"true"`
-	WaitForGreen      bool   `long:"green"             description:"wait for both hosts cluster status to be green before dump. otherwise yellow is okay" default:"false"`
+	SrcEs             string   `short:"s" long:"source"  description:"source elasticsearch instance, url or elastic cloud id"`
+	DstEs             string   `short:"d" long:"dest"    description:"destination elasticsearch instance, url or elastic cloud id"`
+	SrcAuth           string   `long:"source-auth"       description:"basic auth for the source instance, user:pass"`
+	DstAuth           string   `long:"dest-auth"         description:"basic auth for the destination instance, user:pass"`
+	SrcApiKey         string   `long:"source-api-key"    description:"api key for the source instance, either id:key or base64 encoded"`
+	DstApiKey         string   `long:"dest-api-key"      description:"api key for the destination instance, either id:key or base64 encoded"`
+	SrcToken          string   `long:"source-token"      description:"bearer token for the source instance"`
+	DstToken          string   `long:"dest-token"        description:"bearer token for the destination instance"`
+	SrcHeaders        []string `long:"source-header"     description:"header sent with every request to the source instance, key:value. can be repeated"`
+	DstHeaders        []string `long:"dest-header"       description:"header sent with every request to the destination instance, key:value. can be repeated"`
+	DocBufferCount    int      `short:"c" long:"count"   description:"number of documents at a time: ie \"size\" in the scroll request" default:"100"`
+	ScrollTime        string   `short:"t" long:"time"    description:"scroll time" default:"1m"`
+	Destructive       bool     `short:"f" long:"force"   description:"delete destination index before copying" default:"false"`
+	ShardsCount       int      `long:"shards"            description:"set a number of shards on newly created indexes"`
+	DocsOnly          bool     `long:"docs-only"         description:"load documents only, do not try to recreate indexes" default:"false"`
+	CreateIndexesOnly bool     `long:"index-only"        description:"only create indexes, do not load documents" default:"false"`
+	EnableReplication bool     `long:"replicate"         description:"enable replication while indexing into the new indexes" default:"false"`
+	IndexNames        string   `short:"i" long:"indexes" description:"list of indexes to copy, comma separated. wildcards and /regexes/ are matched against the source indexes, ie logstash-2023.*,/^metrics-\\d+$/" default:"_all"`
+	CopyAllIndexes    bool     `short:"a" long:"all"     description:"copy indexes starting with . and _" default:"false"`
+	Workers           int      `short:"w" long:"workers" description:"concurrency" default:"1"`
+	Slices            int      `long:"slices"            description:"read the source with this many sliced scrolls in parallel, es 5 and later"`
+	Strategy          string   `long:"strategy"          description:"scroll: read documents and bulk them to the destination, reindex: have the destination pull them with _reindex from remote, es 5 and later" default:"scroll"`
+	Pit               bool     `long:"pit"               description:"read the source with a point in time and search_after instead of a scroll, es 7.10 and opensearch 2.4 and later"`
+	CopySettings      bool     `long:"settings"          description:"copy index settings from source" default:"true"`
+	WaitForGreen      bool     `long:"green"             description:"wait for both hosts cluster status to be green before dump. otherwise yellow is okay" default:"false"`
+	CACert            string   `long:"ca-cert"           description:"pem encoded ca bundle used to verify both hosts"`
+	ClientCert        string   `long:"client-cert"       description:"pem encoded client certificate for mutual tls"`
+	ClientKey         string   `long:"client-key"        description:"pem encoded key for --client-cert"`
+	Insecure          bool     `long:"insecure"          description:"skip verifying tls certificates" default:"false"`
+	Proxy             string   `long:"proxy"             description:"http or socks5 proxy for both hosts, ie socks5://localhost:1080"`
+	Http1             bool     `long:"http1"             description:"stick to http/1.1 with hosts that offer http/2"`
+	ConnectTimeout    int      `long:"connect-timeout"   description:"seconds to wait for a connection to either host, 0 waits forever" default:"30"`
+	ScrollTimeout     int      `long:"scroll-timeout"    description:"seconds to wait for the source to answer, ie with a page of documents. 0 waits forever" default:"300"`
+	BulkTimeout       int      `long:"bulk-timeout"      description:"seconds to wait for the destination to answer, ie to index a bulk request. 0 waits forever" default:"300"`
+	AwsRegion         string   `long:"aws-region"        description:"sign requests with aws sigv4 for this region, for amazon managed domains"`
+	Output            string   `short:"o" long:"output"  description:"dump documents to a file instead of a destination instance, ie file:///backups/dump.json"`
+	Input             string   `long:"input"             description:"restore documents from a dump file instead of a source instance, ie file:///backups/dump.json"`
+	SplitSize         int      `long:"split-size"        description:"start a new dump part every this many mb per index, parts are listed in a manifest"`
+	SplitDocs         int      `long:"split-docs"        description:"start a new dump part every this many documents per index"`
+	DumpMetadataDir   string   `long:"dump-metadata"     description:"write mappings, settings and aliases of each index to json files in this directory"`
+	LoadMetadataDir   string   `long:"restore-metadata"  description:"create indexes on the destination from files written by --dump-metadata"`
+	OutputFormat      string   `long:"output-format"     description:"bulk: lines ready for the _bulk api, docs: one {_index,_type,_id,_source} object per line, parquet: one column per field for analytics, csv: one column per field for spreadsheets, avro: records typed from the mappings, elasticdump: data and metadata files of the node elasticdump tool" default:"bulk"`
+	AwsProfile        string   `long:"aws-profile"       description:"profile in the aws shared credentials file, environment credentials are used if empty"`
+	ParquetSchema     string   `long:"parquet-schema"    description:"json file with the [{name,type}] columns of a parquet dump, inferred from the mappings if empty"`
+	CsvColumns        string   `long:"csv-columns"       description:"comma separated fields to write as columns of a csv dump, ie _id,user.name. all mapped fields if empty"`
+	CsvDelimiter      string   `long:"csv-delimiter"     description:"field delimiter of a csv dump, \\t for tabs" default:","`
+	Passphrase        string   `long:"passphrase"        description:"encrypt dumps with a key derived from this passphrase, or decrypt them on restore"`
+	EncryptionKey     string   `long:"encryption-key"    description:"file with a random key to encrypt dumps with, or decrypt them on restore"`
+	SkipVerify        bool     `long:"skip-verify"       description:"don't check split and archive dumps against their manifest before restoring" default:"false"`
+	Query             string   `short:"q" long:"query"   description:"only copy documents matching this lucene query string, ie \"status:active AND created:[2020-01-01 TO *]\""`
+	Since             string   `long:"since"             description:"only copy documents with --time-field at or after this date, date math or a duration ago, ie 2023-01-01, now-1M or 30d"`
+	Until             string   `long:"until"             description:"only copy documents with --time-field before this date, same formats as --since"`
+	TimeField         string   `long:"time-field"        description:"date field --since and --until apply to" default:"@timestamp"`
+	Types             string   `long:"types"             description:"only copy these document types, comma separated, for versions before 6 with several types per index"`
+	SourceInclude     string   `long:"source-include"    description:"only copy these fields of _source, comma separated, wildcards allowed, ie user.*,message"`
+	SourceExclude     string   `long:"source-exclude"    description:"leave these fields out of _source, comma separated, wildcards allowed, ie payload,*.embedding"`
+	ExcludeIndexes    string   `long:"exclude-indexes"   description:"indexes not to copy, comma separated names, wildcards or /regexes/ like --indexes"`
+	Templates         string   `long:"templates"         description:"copy the legacy index templates matching these comma separated names or wildcards, ie logs-*. to es 7.8 and later as composable templates"`
+	MaxDocs           int      `long:"max-docs"          description:"stop once this many documents are copied"`
+	MaxIndexDocs      int      `long:"max-index-docs"    description:"copy at most this many documents of each index"`
+	Sample            string   `long:"sample"            description:"copy a random share of the documents, ie 5% or 0.05"`
+	Routing           string   `long:"routing"           description:"only copy documents with these comma separated custom routing values"`
+	IdsFile           string   `long:"ids-file"          description:"only copy the documents with the ids listed in this file, one per line"`
+	Rename            string   `long:"rename"            description:"write indexes under another name on the destination, comma separated old:new pairs"`
+	DestPrefix        string   `long:"dest-prefix"       description:"prepend this to every index name on the destination"`
+	DestSuffix        string   `long:"dest-suffix"       description:"append this to every index name on the destination"`
+	CopyAliases       bool     `long:"aliases"           description:"copy the aliases of the indexes with their filters and routing once the documents are copied"`
+	RenameAlias       string   `long:"rename-alias"      description:"create aliases under another name on the destination, comma separated old:new pairs"`
+	TimeIndex         string   `long:"time-index"        description:"write documents to indexes named after this and their --time-field, ie logs for logs-2024.01.02"`
+	TimeIndexFormat   string   `long:"time-index-format" description:"date format of --time-index names" default:"yyyy.MM.dd"`
+	DestIndexTemplate string   `long:"dest-index-template" description:"go template of the destination index of each document, ie {{.tenant}}-logs"`
+	RenameType        string   `long:"rename-type"       description:"write documents of a type under another type, comma separated old:new pairs"`
+	CollapseTypes     bool     `long:"collapse-types"    description:"drop _type and merge the mappings of all types, for versions from 7 on" default:"false"`
+	RenameFields      []string `long:"rename-field"      description:"rename a field in documents and mappings, old.path:new.path. can be repeated"`
+	DropFields        []string `long:"drop-field"        description:"remove a field from documents, a dotted path with wildcards or a /regex/. can be repeated"`
+	SetFields         []string `long:"set-field"         description:"add a field with a constant value to every document, key=value. can be repeated"`
+	Mask              string   `long:"mask"              description:"redact fields, comma separated field=full, field=partial or field=last4"`
+	HashFields        string   `long:"hash-field"        description:"replace the values of these comma separated fields with their salted sha256"`
+	HashSalt          string   `long:"hash-salt"         description:"salt for --hash-field, keep it secret"`
+	EncryptFields     string   `long:"encrypt-field"     description:"encrypt the values of these comma separated fields with --field-key"`
+	DecryptFields     string   `long:"decrypt-field"     description:"decrypt the values of these comma separated fields with --field-key"`
+	FieldKey          string   `long:"field-key"         description:"file with the key of --encrypt-field and --decrypt-field, at least 16 random bytes"`
+	Coerce            string   `long:"coerce"            description:"convert the values of fields, comma separated field:type with int, float, bool or string"`
+	NormalizeDates    []string `long:"normalize-date"    description:"rewrite the dates of a field in --date-output format, field or field:format||format. can be repeated"`
+	DateOutput        string   `long:"date-output"       description:"format of --normalize-date, iso8601, epoch_millis or a java date format" default:"iso8601"`
+	TransformScript   string   `long:"transform-script"  description:"javascript file with a transform(doc) function that can change or drop every document"`
+	TransformCmd      string   `long:"transform-cmd"     description:"command to pipe documents through, one json document a line on its stdin and stdout"`
+	Transforms        []string `long:"transform"         description:"run a transformer built into the tool, name or name:argument. can be repeated"`
+	IdStrategy        string   `long:"id-strategy"       description:"ids on the destination, keep, autogen, hash of _source or field:path" default:"keep"`
+	StripRouting      bool     `long:"strip-routing"     description:"index documents without their custom routing" default:"false"`
+	JoinField         string   `long:"join-field"        description:"name of the join field _parent relations become on es 6 and later" default:"join_field"`
+	RemapRouting      string   `long:"remap-routing"     description:"index documents with another routing value, comma separated old:new pairs"`
+	Pipeline          string   `long:"pipeline"          description:"ingest pipeline of the destination to run documents through"`
+	CopyPipelines     string   `long:"copy-pipelines"    description:"copy the ingest pipelines the indexes have as default or final pipeline and --pipeline, or all of them with =all" optional:"yes" optional-value:"referenced"`
+	CopyIlm           bool     `long:"copy-ilm"          description:"copy the ilm policies of the indexes and keep their index.lifecycle settings"`
+	StripIlm          bool     `long:"strip-lifecycle"   description:"create indexes and templates without index.lifecycle settings, so ilm leaves them alone"`
+	ClusterSettings   string   `long:"copy-cluster-settings" description:"copy these comma separated persistent cluster settings, and those under them"`
+	CopyWatches       bool     `long:"copy-watches"      description:"copy the watcher watches once the documents are copied"`
+	BulkRetries       int      `long:"bulk-retries"      description:"times documents rejected by a busy destination are sent again" default:"3"`
+	RetryBudget       int      `long:"retry-budget"      description:"times documents may be sent again over the whole copy, after that rejected documents fail. 0 for no limit"`
+	RetryByIndex      bool     `long:"retry-by-index"    description:"break the retry summary down by index"`
+	StallTimeout      int      `long:"stall-timeout"     description:"stop once no documents were read or flushed for this many minutes, ie a hung scroll. 0 waits forever"`
+	ErrorsShown       int      `long:"errors-shown"      description:"print this many errors of each kind, source, destination, document and output, and only count the rest. 0 prints all" default:"100"`
+	DeadLetterFile    string   `long:"dead-letter"       description:"write documents the destination didn't index to this file, with the reason"`
+	StateFile         string   `long:"state"             description:"save the indexes copied so far to this file, to continue an interrupted copy with --resume"`
+	Resume            bool     `long:"resume"            description:"skip the indexes --state has as done and documents already on the destination"`
+	AbortOnError      bool     `long:"abort-on-error"    description:"stop reading once a document fails, rather than copying what can be"`
+	MaxErrors         int      `long:"max-errors"        description:"stop reading once more than this many documents failed, 0 for no limit"`
+	OnConflict        string   `long:"on-conflict"       description:"what to do with documents that exist on the destination: abort, skip or overwrite. reported as failed if empty"`
+	IfExists          string   `long:"if-exists"         description:"what to do with indexes that exist on the destination without --force: abort, skip or append. creating them fails if empty"`
+	RateLimitDocs     float64  `long:"rate-limit-docs"   description:"copy at most this many documents a second, across all workers"`
+	RateLimitBytes    string   `long:"rate-limit-bytes"  description:"copy at most this many bytes of documents a second, ie 5mb"`
+	VerifyCount       bool     `long:"verify-count"      description:"compare the number of documents of every index on source and destination once done"`
+	SkipPreflight     bool     `long:"skip-preflight"    description:"don't check that both hosts answer and allow the copy before starting"`
+	ForceVersion      bool     `long:"force-version"     description:"copy between es versions known not to work together, with a warning"`
+	AllowSameIndex    bool     `long:"allow-same-index"  description:"copy indexes onto themselves when source and destination are the same cluster"`
+	Verify            bool     `long:"verify"            description:"compare every document of source and destination by a hash of _source instead of copying"`
 }
 
 func main() {
@@ -73,14 +248,327 @@ func main() {
 
 	c := Config{
 		FlushLock: sync.Mutex{},
-		ErrChan:   make(chan error),
+		Backoff:   NewBackoff(),
 	}
 
 	// parse args
 	_, err := goflags.Parse(&c)
 	if err != nil {
-		fmt.Println(err)
-		return
+		if flagsErr, ok := err.(*goflags.Error); ok && flagsErr.Type == goflags.ErrHelp {
+			return
+		}
+		Println(err)
+		os.Exit(exitUsage)
+	}
+
+	// keep stdout clean for documents
+	if c.Output == "-" {
+		logOutput = os.Stderr
+	}
+
+	// look up any credentials that refer to the keyring, vault, etc
+	for _, cred := range []*string{&c.SrcAuth, &c.DstAuth, &c.SrcApiKey, &c.DstApiKey, &c.SrcToken, &c.DstToken, &c.Passphrase, &c.HashSalt} {
+		if *cred, err = ResolveCredential(*cred); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if c.SrcEs == "" && c.Input == "" {
+		Println("one of --source or --input is required")
+		os.Exit(exitUsage)
+	}
+	if c.DstEs == "" && c.Output == "" && c.DumpMetadataDir == "" {
+		Println("one of --dest or --output is required")
+		os.Exit(exitUsage)
+	}
+	if c.Passphrase != "" && c.EncryptionKey != "" {
+		Println("only one of --passphrase or --encryption-key can be used")
+		os.Exit(exitUsage)
+	}
+	masker.Add(c.Passphrase, c.HashSalt)
+	if c.IdsFile != "" {
+		if c.Ids, err = ReadIds(c.IdsFile); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.Rename != "" {
+		if c.Renames, err = ParseRenames(c.Rename); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.RemapRouting != "" {
+		if c.Routings, err = ParseRenames(c.RemapRouting); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.RenameType != "" {
+		if c.TypeNames, err = ParseRenames(c.RenameType); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.RenameAlias != "" {
+		if c.Aliases, err = ParseRenames(c.RenameAlias); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if len(c.RenameFields) > 0 {
+		if c.Moves, err = ParseFieldMoves(c.RenameFields); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if len(c.DropFields) > 0 {
+		if c.Drops, err = ParseFieldPatterns(c.DropFields); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.Mask != "" {
+		if c.Masks, err = ParseMaskRules(c.Mask); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.HashFields != "" {
+		rules, err := HashRules(c.HashFields, c.HashSalt)
+		if err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+		c.Masks = append(c.Masks, rules...)
+	}
+	if c.EncryptFields != "" || c.DecryptFields != "" {
+		if c.FieldKey == "" {
+			Println("--encrypt-field and --decrypt-field need a --field-key")
+			os.Exit(exitUsage)
+		}
+		if c.FieldAead, err = NewFieldAead(c.FieldKey); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+		if c.Encrypted, err = ParseFieldPatterns([]string{c.EncryptFields}); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+		if c.Decrypted, err = ParseFieldPatterns([]string{c.DecryptFields}); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.DeadLetterFile != "" {
+		c.Dead = &DeadLetter{Path: c.DeadLetterFile}
+	}
+	if c.Coerce != "" {
+		if c.Coercions, err = ParseCoercions(c.Coerce); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if len(c.NormalizeDates) > 0 {
+		if c.Dates, err = ParseDateRules(c.NormalizeDates); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+		if err := CheckDateOutput(c.DateOutput); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if len(c.SetFields) > 0 {
+		if c.Sets, err = ParseFieldValues(c.SetFields); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if c.TransformScript != "" {
+		if c.Program, err = CompileScript(c.TransformScript); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	// every worker has transformers of its own, ie the script runs in a
+	// runtime per worker
+	chains := make([][]DocumentTransformer, c.Workers)
+	for i := range chains {
+		if chains[i], err = c.NewTransformChain(); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	if err := CheckIdStrategy(c.IdStrategy); err != nil {
+		Println(err)
+		os.Exit(exitUsage)
+	}
+	if c.DestIndexTemplate != "" {
+		if c.IndexTmpl, err = ParseIndexTemplate(c.DestIndexTemplate); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.TimeIndex != "" {
+		if _, err := formatJavaDate(c.TimeIndexFormat, time.Now()); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.Sample != "" {
+		if c.Sampling, err = ParseSample(c.Sample); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	c.DocRate = NewRateLimit(c.RateLimitDocs)
+	c.Retries = NewRetryStats(c.RetryBudget)
+	c.Errors = NewErrorLog(c.ErrorsShown)
+	if c.RateLimitBytes != "" {
+		rate, err := ParseByteRate(c.RateLimitBytes)
+		if err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+		c.ByteRate = NewRateLimit(rate)
+	}
+
+	if err := c.SetupHosts(); err != nil {
+		Println(err)
+		os.Exit(exitUsage)
+	}
+	if c.Src != nil {
+		version := hostInfo(c.Src)
+		if version.OpenSearch() {
+			dropEsHeaders(c.Src, "source")
+		}
+		major, minor, _ := version.Es()
+		c.Scan = scanVersion(major, minor)
+		c.TrackHits = major >= 7
+		c.AskMeta = major < 5
+		if c.Slices > 1 && major < 5 {
+			Println("sliced scrolls need es 5 or later on the source, reading with one scroll")
+			c.Slices = 0
+		}
+		if c.Pit && !version.Pit() {
+			Println("--pit needs es 7.10 or opensearch 2.4 or later on the source")
+			os.Exit(exitUsage)
+		}
+	}
+	if c.Dst != nil {
+		version := hostInfo(c.Dst)
+		if version.OpenSearch() {
+			dropEsHeaders(c.Dst, "destination")
+		}
+		major, _, _ := version.Es()
+		c.Typeless = major >= 7
+		c.NoParent = major >= 6
+	}
+
+	if c.VerifyCount {
+		if err := c.CheckVerifyCount(); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.Verify {
+		if err := c.CheckVerify(); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+	}
+	if c.Resume && c.StateFile == "" {
+		Println("--resume needs the --state file of the interrupted copy")
+		os.Exit(exitUsage)
+	}
+	if c.Slices > 1 && c.StateFile != "" {
+		Println("--slices can't be used with --state, which scrolls one index at a time")
+		os.Exit(exitUsage)
+	}
+	if c.Pit && (c.Slices > 1 || c.StateFile != "") {
+		Println("--pit can't be used with --slices or --state")
+		os.Exit(exitUsage)
+	}
+	if c.CopyAliases && (c.SrcEs == "" || c.DstEs == "" || c.TimeIndex != "" || c.DestIndexTemplate != "") {
+		Println("--aliases copies from one es to another, and can't be used with --time-index or --dest-index-template")
+		os.Exit(exitUsage)
+	}
+	if err := c.CheckCopyPipelines(); err != nil {
+		Println(err)
+		os.Exit(exitUsage)
+	}
+	if c.CopyPipelines != "" && (c.SrcEs == "" || c.DstEs == "") {
+		Println("--copy-pipelines copies from one es to another")
+		os.Exit(exitUsage)
+	}
+	if c.CopyIlm && (c.SrcEs == "" || c.DstEs == "" || c.StripIlm) {
+		Println("--copy-ilm copies from one es to another, and can't be used with --strip-lifecycle")
+		os.Exit(exitUsage)
+	}
+	if c.ClusterSettings != "" && (c.SrcEs == "" || c.DstEs == "") {
+		Println("--copy-cluster-settings copies from one es to another")
+		os.Exit(exitUsage)
+	}
+	if c.CopyWatches && (c.SrcEs == "" || c.DstEs == "") {
+		Println("--copy-watches copies from one es to another")
+		os.Exit(exitUsage)
+	}
+	if err := c.CheckStrategy(); err != nil {
+		Println(err)
+		os.Exit(exitUsage)
+	}
+	if err := CheckConflictPolicy(c.OnConflict); err != nil {
+		Println(err)
+		os.Exit(exitUsage)
+	}
+	if err := CheckExistsPolicy(c.IfExists); err != nil {
+		Println(err)
+		os.Exit(exitUsage)
+	}
+
+	// documents indexed since the last checkpoint come back as conflicts
+	if c.Resume && c.OnConflict == "" {
+		c.OnConflict = "skip"
+	}
+	if c.StateFile != "" {
+		if c.Src == nil || c.Dst == nil || c.Input != "" {
+			Println("--state only works copying from one es to another")
+			os.Exit(exitUsage)
+		}
+		if c.TransformCmd != "" {
+			Println("--state can't be used with --transform-cmd")
+			os.Exit(exitUsage)
+		}
+		if c.State, err = LoadState(c.StateFile, c.Src.Url); err != nil {
+			Println(err)
+			os.Exit(exitUsage)
+		}
+		if len(c.State.Done) > 0 && !c.Resume {
+			Println(c.StateFile, "has the state of an earlier copy, add --resume to continue it or remove the file")
+			os.Exit(exitUsage)
+		}
+		if len(c.State.Done) > 0 {
+			Println("resuming, done already:", c.State.resumedIndexes())
+		}
+	}
+
+	// catch corrupted dumps before anything is created or indexed
+	if c.Input != "" && !c.SkipVerify {
+		if err := c.VerifyDump(c.Input); err != nil {
+			Println(err)
+			os.Exit(exitFailure)
+		}
+	}
+
+	// restoring from a file, indexes have to exist or be created by es unless
+	// we have their metadata
+	if c.Input != "" && c.LoadMetadataDir == "" && !IsArchive(c.Input) {
+		c.DocsOnly = true
 	}
 
 	// enough of a buffer to hold all the search results across all workers
@@ -88,184 +576,628 @@ func main() {
 
 	// get all indexes from source
 	idxs := Indexes{}
-	if err := c.GetIndexes(c.SrcEs, &idxs); err != nil {
-		fmt.Println(err)
+	var source DocSource
+	if c.LoadMetadataDir != "" {
+		if idxs, err = LoadMetadata(c.LoadMetadataDir); err != nil {
+			Println(err)
+			os.Exit(exitUnreachable)
+		}
+	} else if IsArchive(c.Input) {
+		// archives carry the metadata for their indexes
+		archive, err := c.NewArchiveReader(c.Input)
+		if err != nil {
+			Println(err)
+			os.Exit(exitUnreachable)
+		}
+		idxs, source = archive.Indexes, archive
+	} else if c.Src != nil {
+		if err := c.GetDataStreams(); err != nil {
+			Println(err)
+			os.Exit(exitUnreachable)
+		}
+		if err := c.GetIndexes(c.Src, &idxs); err != nil {
+			Println(err)
+			os.Exit(exitUnreachable)
+		}
+		c.AddStreams(&idxs)
+		if len(c.Streams) > 0 && c.OnConflict == "overwrite" {
+			Println("--on-conflict overwrite can't be used with data streams, they only take new documents")
+			os.Exit(exitUsage)
+		}
+	}
+
+	// compare what was copied before rather than copying
+	if c.Verify {
+		ok, err := c.VerifyContent(idxs)
+		if err != nil {
+			Println(err)
+			os.Exit(exitUnreachable)
+		}
+		if !ok {
+			os.Exit(exitMismatch)
+		}
 		return
 	}
 
-	// copy index settings if user asked
+	// documents change on the way to dumps too, so do their mappings
+	if len(c.Moves) > 0 {
+		idxs.MoveMappingFields(c.Moves)
+	}
+
+	// dumping to a file, there are no indexes to create. parquet, csv and
+	// avro schemas come from the mappings so this waits until indexes are loaded
+	if c.Output != "" {
+		switch c.OutputFormat {
+		case "parquet":
+			c.Columns, err = c.ParquetSchemaFor(idxs)
+		case "csv":
+			c.Columns, err = c.CsvColumnsFor(idxs)
+		case "avro":
+			c.Avro, err = AvroSchemaFor(idxs)
+		}
+		if err != nil {
+			Println(err)
+			os.Exit(exitFailure)
+		}
+		if c.Out, err = c.NewDocWriter(c.Output, c.OutputFormat); err != nil {
+			Println(err)
+			os.Exit(exitFailure)
+		}
+		c.DocsOnly = true
+	}
+
+	// archives start with the metadata of every index
+	if archive, ok := c.Out.(*ArchiveWriter); ok {
+		if err := archive.WriteMetadata(&c, &idxs); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
+		}
+	}
+
+	// write index metadata to files if user asked
+	if c.DumpMetadataDir != "" && c.Src != nil {
+		if err := c.DumpMetadata(c.DumpMetadataDir, &idxs); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
+		}
+		if c.Dst == nil && c.Output == "" {
+			Println("Metadata dumped, done")
+			return
+		}
+	}
+
+	// copy index settings if user asked, --shards still sets the shards.
+	// sorting can only be set when an index is created, so it always is
+	if c.Src != nil && c.LoadMetadataDir == "" {
+		prefix := "sort."
+		if c.CopySettings {
+			prefix = ""
+		}
+		if err := c.CopyIndexSettings(&idxs, prefix); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
+		}
+	}
 	if c.ShardsCount > 0 {
 		for name, _ := range idxs {
 			idxs.SetShardCount(name, fmt.Sprint(c.ShardsCount))
 		}
-	} else if c.CopySettings == true {
-		if err := c.CopyShardingSettings(&idxs); err != nil {
-			fmt.Println(err)
-			return
+	}
+
+	// keep or drop what ilm manages the indexes by
+	if c.CopyIlm && c.Src != nil && c.LoadMetadataDir == "" {
+		if err := c.CopyLifecycle(&idxs); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
 		}
 	}
+	if c.StripIlm {
+		idxs.StripLifecycle()
+	}
 
 	// disable replication
 	if c.EnableReplication == false {
 		idxs.DisableReplication()
 	}
 
-	if c.DocsOnly == false {
+	// _parent relations by the source types, before types are renamed
+	if err := c.PrepareParents(idxs); err != nil {
+		Println(err)
+		os.Exit(exitIndexes)
+	}
+
+	if c.TypeNames != nil || c.CollapseTypes || c.Typeless {
+		idxs.RenameTypes(c.DestType)
+	}
+
+	// indexes are scrolled one by one by their source name with --state
+	var srcNames []string
+	for name := range idxs {
+		srcNames = append(srcNames, name)
+	}
+	srcNames = append(srcNames, c.StreamNames()...)
+
+	// settings are copied by their source name, so rename after that. time
+	// based and templated indexes are created as documents show up instead
+	if c.TimeIndex != "" || c.IndexTmpl != nil {
+		c.Lazy = NewLazyIndexes(idxs)
+	} else {
+		if c.IfExists == "skip" && c.Dst != nil && !c.Destructive && !c.DocsOnly && !c.Resume {
+			if srcNames, err = c.SkipExisting(&idxs, srcNames); err != nil {
+				Println(err)
+				os.Exit(exitIndexes)
+			}
+			if len(srcNames) == 0 {
+				Println("every index exists on the destination, nothing to copy")
+				return
+			}
+		}
+		idxs.Rename(c.DestIndex)
+	}
+
+	// copying an index onto itself is a mistake, and with --force loses it
+	if err := c.CheckSameIndexes(srcNames); err != nil {
+		Println(err)
+		os.Exit(exitIndexes)
+	}
+
+	// find out what can't work before creating anything. time based and
+	// templated indexes aren't known yet
+	if !c.SkipPreflight {
+		var srcIndexes, dstIndexes []string
+		if c.Input == "" {
+			srcIndexes = srcNames
+		}
+		if c.Lazy == nil {
+			for name := range idxs {
+				dstIndexes = append(dstIndexes, name)
+			}
+			sort.Strings(dstIndexes)
+		}
+		if problems := c.Preflight(srcIndexes, dstIndexes, idxs); len(problems) > 0 {
+			for _, err := range problems {
+				Println(err)
+			}
+			os.Exit(exitUnreachable)
+		}
+	}
+
+	// cluster settings like allocation awareness go before any index
+	if c.ClusterSettings != "" && c.Src != nil && c.Dst != nil && !c.Resume {
+		if err := c.CopyClusterSettings(); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
+		}
+	}
+
+	// pipelines go first, indexes and templates may name them in settings
+	if c.CopyPipelines != "" && c.Src != nil && c.Dst != nil && !c.Resume {
+		if err := c.CreatePipelines(srcNames); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
+		}
+	}
+
+	// and ilm policies, indexes can't be managed by a missing one
+	if c.CopyIlm && c.Src != nil && c.Dst != nil && !c.Resume {
+		if err := c.CreateIlmPolicies(idxs); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
+		}
+	}
+
+	// templates go first, indexes created as documents show up use them too
+	if c.Templates != "" && c.Src != nil && c.Dst != nil && !c.DocsOnly && !c.Resume {
+		if err := c.CopyTemplates(); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
+		}
+	}
+
+	// a resumed copy created its indexes the first time
+	if c.DocsOnly == false && c.Lazy == nil && !c.Resume {
 		// delete remote indexes if user asked
 		if c.Destructive == true {
 			if err := c.DeleteIndexes(&idxs); err != nil {
-				fmt.Println(err)
-				return
+				Println(err)
+				os.Exit(exitIndexes)
 			}
 		}
 
 		// create indexes on DstEs
 		if err := c.CreateIndexes(&idxs); err != nil {
-			fmt.Println(err)
-			return
+			Println(err)
+			os.Exit(exitIndexes)
+		}
+		if err := c.CreateDataStreams(); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
+		}
+	}
+
+	// percolator queries only index once every field they query is mapped
+	if c.Dst != nil && c.Lazy == nil {
+		if err := c.EnsurePercolatorMappings(idxs); err != nil {
+			Println(err)
+			os.Exit(exitIndexes)
 		}
 	}
 
 	// if we only want to create indexes, we are done here, return
 	if c.CreateIndexesOnly {
-		fmt.Println("Indexes created, done")
+		if c.CopyAliases || c.Rollover != nil {
+			if err := c.CreateAliases(srcNames); err != nil {
+				Println(err)
+				os.Exit(exitIndexes)
+			}
+		}
+		if c.CopyWatches {
+			if err := c.CreateWatches(); err != nil {
+				Println(err)
+				os.Exit(exitIndexes)
+			}
+		}
+		Println("Indexes created, done")
 		return
 	}
 
 	// wait for cluster state to be okay before dumping
 	timer := time.NewTimer(time.Second * 3)
 	for {
-		if status, ready := c.ClusterReady(c.SrcEs); !ready {
-			fmt.Printf("%s at %s is %s, delaying dump\n", status.Name, c.SrcEs, status.Status)
-			<-timer.C
-			continue
+		if c.Src != nil {
+			if status, ready := c.ClusterReady(c.Src); !ready {
+				if status.Status == "unreachable" {
+					Printf("%s can't be reached\n", c.SrcEs)
+					os.Exit(exitUnreachable)
+				}
+				Printf("%s at %s is %s, delaying dump\n", status.Name, c.SrcEs, status.Status)
+				<-timer.C
+				continue
+			}
 		}
-		if status, ready := c.ClusterReady(c.DstEs); !ready {
-			fmt.Printf("%s at %s is %s, delaying dump\n", status.Name, c.DstEs, status.Status)
-			<-timer.C
-			continue
+		if c.Dst != nil {
+			if status, ready := c.ClusterReady(c.Dst); !ready {
+				if status.Status == "unreachable" {
+					Printf("%s can't be reached\n", c.DstEs)
+					os.Exit(exitUnreachable)
+				}
+				Printf("%s at %s is %s, delaying dump\n", status.Name, c.DstEs, status.Status)
+				<-timer.C
+				continue
+			}
 		}
 
 		timer.Stop()
 		break
 	}
-	fmt.Println("starting dump..")
+	Println("starting dump..")
 
-	// start scroll
-	scroll, err := c.NewScroll()
-	if err != nil {
-		fmt.Println(err)
+	// the destination copies the documents itself
+	if c.Strategy == "reindex" {
+		if status := c.Reindex(srcNames); status != 0 {
+			os.Exit(status)
+		}
 		return
 	}
 
+	// start scroll, or read from the dump when restoring. archives are
+	// already open by now
+	var total int
+	if c.Input != "" && source == nil {
+		if source, err = c.NewDumpSource(c.Input); err != nil {
+			Println(err)
+			os.Exit(exitUnreachable)
+		}
+	} else if c.State != nil {
+		// totals are only known as every index is scrolled
+		source = c.NewIndexScrolls(srcNames)
+	} else if source == nil && c.Pit {
+		pit, err := c.NewPitReader(c.IndexNames)
+		if err != nil {
+			Println(err)
+			os.Exit(exitUnreachable)
+		}
+		source, total = pit, pit.Total
+	} else if source == nil && c.Slices > 1 {
+		sliced, err := c.NewSlicedScroll(c.IndexNames, c.Slices)
+		if err != nil {
+			Println(err)
+			os.Exit(exitUnreachable)
+		}
+		source, total = sliced, sliced.Total
+	} else if source == nil {
+		scroll, err := c.NewScroll(c.IndexNames)
+		if err != nil {
+			Println(err)
+			os.Exit(exitUnreachable)
+		}
+		source, total = scroll, scroll.Hits.Total.Value
+	}
+
+	if c.Sampling > 0 {
+		total = int(float64(total) * c.Sampling)
+	}
+
+	// stop early if the user only wants some documents. when scrolling we
+	// know the indexes, so the scroll can stop once each one is done
+	indexCount := 0
+	if c.Input == "" {
+		indexCount = len(idxs)
+	}
+	c.Limit = NewLimit(c.MaxDocs, c.MaxIndexDocs, indexCount)
+	if c.MaxDocs > 0 && total > c.MaxDocs {
+		total = c.MaxDocs
+	}
+
 	// create a progressbar and start a docCount
-	bar := pb.StartNew(scroll.Hits.Total)
+	bar := pb.New(total)
+	bar.Output = logOutput
+	bar.Start()
+	if scrolls, ok := source.(*IndexScrolls); ok {
+		scrolls.Bar = bar
+	}
 	var docCount int
 
+	// documents go through --transform-cmd on their way to the workers
+	var docs <-chan map[string]interface{} = c.DocChan
+	if c.TransformCmd != "" {
+		if docs, err = c.StartTransformCmd(c.DocChan); err != nil {
+			Println(err)
+			os.Exit(exitFailure)
+		}
+	}
+
 	wg := sync.WaitGroup{}
 	wg.Add(c.Workers)
 	for i := 0; i < c.Workers; i++ {
-		go c.NewWorker(&docCount, bar, &wg)
+		go c.NewWorker(docs, &docCount, bar, &wg, chains[i])
 	}
 
-	// print errors
-	go func() {
-		for {
-			err := <-c.ErrChan
-			fmt.Println(err)
+	// loop scrolling until done, or until a signal asks to stop
+	c.TrapSignals()
+	c.StartWatchdog(time.Duration(c.StallTimeout) * time.Minute)
+	for !c.Limit.Reached() && !c.Stopped() && source.Next(&c) == false {
+	}
+	if clearer, ok := source.(Clearer); ok {
+		if err := clearer.Clear(&c); err != nil {
+			c.Errors.Add(notices, err)
 		}
-	}()
-
-	// loop scrolling until done
-	for scroll.Next(&c) == false {
+	}
+	if scroll, ok := source.(*Scroll); ok && scroll.Partial {
+		c.Partial = true
+	}
+	if sliced, ok := source.(*SlicedScroll); ok && sliced.Partial() {
+		c.Partial = true
+	}
+	if pit, ok := source.(*PitReader); ok && pit.Partial {
+		c.Partial = true
 	}
 
 	// finished, close doc chan and wait for goroutines to be done
 	close(c.DocChan)
 	wg.Wait()
-	bar.FinishPrint(fmt.Sprintln("Indexed", docCount, "documents"))
+
+	if c.Out != nil {
+		if err := c.Out.Close(); err != nil {
+			c.Errors.Add(outputErrors, err)
+		}
+		c.Errors.Print()
+		bar.FinishPrint(fmt.Sprintln("Dumped", docCount, "documents to", c.Output))
+		if c.Stopped() {
+			Println("stopped early, the dump is incomplete")
+			os.Exit(c.stopStatus())
+		}
+		if c.Partial {
+			Println("reading the source failed, the dump is incomplete")
+			os.Exit(exitPartial)
+		}
+		if status := c.Errors.ExitStatus(); status != 0 {
+			os.Exit(status)
+		}
+		return
+	}
+	if c.Dead != nil {
+		if err := c.Dead.Close(); err != nil {
+			c.Errors.Add(outputErrors, err)
+		}
+	}
+	if (c.CopyAliases || c.Rollover != nil) && !c.Stopped() {
+		if err := c.CreateAliases(srcNames); err != nil {
+			c.Errors.Add(destErrors, err)
+		}
+	}
+	if c.CopyWatches && !c.Stopped() {
+		if err := c.CreateWatches(); err != nil {
+			c.Errors.Add(destErrors, err)
+		}
+	}
+	c.Errors.Print()
+	if c.Existing > 0 {
+		Println(c.Existing, "documents were on the destination already")
+	}
+	c.Retries.Print(c.RetryByIndex)
+	if c.Stopped() {
+		bar.FinishPrint(fmt.Sprintln("Stopped early, indexed", docCount-c.Failed-c.Existing, "documents,", c.Failed, "failed"))
+		os.Exit(c.stopStatus())
+	}
+
+	// counts are off anyway when the copy didn't finish
+	counted := true
+	if c.VerifyCount && !c.Stopped() && !c.Partial {
+		if counted, err = c.VerifyCounts(srcNames); err != nil {
+			Println(err)
+		}
+	}
+	if c.Partial {
+		bar.FinishPrint(fmt.Sprintln("Reading the source failed, indexed", docCount-c.Failed-c.Existing, "documents,", c.Failed, "failed"))
+		os.Exit(exitPartial)
+	}
+	if c.Failed > 0 {
+		bar.FinishPrint(fmt.Sprintln("Indexed", docCount-c.Failed-c.Existing, "documents,", c.Failed, "failed"))
+		os.Exit(exitPartial)
+	}
+	bar.FinishPrint(fmt.Sprintln("Indexed", docCount-c.Existing, "documents"))
+	if !counted {
+		os.Exit(exitMismatch)
+	}
+	if status := c.Errors.ExitStatus(); status != 0 {
+		os.Exit(status)
+	}
 }
 
 // Stream from source es instance. "done" is an indicator that the stream is
 // over
 func (s *Scroll) Next(c *Config) (done bool) {
 
-	//  curl -XGET 'http://es-0.9:9200/_search/scroll?scroll=5m'
-	id := bytes.NewBufferString(s.ScrollId)
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/_search/scroll?scroll=%s", c.SrcEs, c.ScrollTime), id)
-	if err != nil {
-		c.ErrChan <- err
+	// the page that came with starting the scroll goes first
+	if s.First {
+		s.First = false
+		return s.send(c, s.Hits.Docs)
 	}
-	resp, err := http.DefaultClient.Do(req)
+
+	//  curl -XGET 'http://es-0.9:9200/_search/scroll?scroll=5m'
+	resp, err := c.Src.Request("GET", c.scrollNextPath(), c.scrollBody(s.ScrollId, c.Scan))
 	if err != nil {
-		c.ErrChan <- err
+		c.Errors.Add(sourceErrors, err)
+		s.Partial = true
+		return true
 	}
 	defer resp.Body.Close()
 
+	// the scroll context is gone when es took longer than --time to be
+	// asked for the next page, or restarted
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		if contextMissing(string(b)) {
+			return s.restart(c)
+		}
+		c.Errors.Add(sourceErrors, fmt.Errorf("scroll response: %s %s", resp.Status, string(b)))
+		s.Partial = true
+		return true
+	}
+
 	// decode elasticsearch scroll response
 	dec := json.NewDecoder(resp.Body)
 	scroll := &Scroll{}
 	err = dec.Decode(&scroll)
 	if err != nil {
-		c.ErrChan <- err
-		return
+		c.Errors.Add(sourceErrors, err)
+		s.Partial = true
+		return true
 	}
 
-	// XXX this might be bad, but assume we are done
-	/*
-		switch resp.StatusCode {
-		case 200:
-			break
-		case 404:
-			// this may indicate bug
-			c.ErrChan <- fmt.Errorf("looks like we dumped all we could...")
-		default:
-			c.ErrChan <- fmt.Errorf("scroll response: %s", stream)
-			// flush and quit
-			return true
+	// show any failures, shards that lost their context won't send the
+	// rest of their documents
+	expired := false
+	for _, failure := range scroll.Shards.Failures {
+		if contextMissing(failure.Reason) {
+			expired = true
+			continue
 		}
-	*/
+		c.Errors.Add(sourceErrors, fmt.Errorf("%s", failure.Reason))
+	}
 
-	// show any failures
-	for _, failure := range scroll.Shards.Failures {
-		c.ErrChan <- fmt.Errorf(failure.Reason)
+	if scroll.ScrollId != "" {
+		s.ScrollId = scroll.ScrollId
 	}
+	done = s.send(c, scroll.Hits.Docs)
+	if expired {
+		return s.restart(c)
+	}
+	return done
+}
+
+// restart starts the scroll over when es lost its context, up to
+// scrollRestarts times. The documents it sends from then on are marked, so
+// what was indexed already is skipped and not counted twice. Only a scroll
+// that can't start over is a source error, the copy finishes otherwise
+func (s *Scroll) restart(c *Config) (done bool) {
 
-	// write all the docs into a channel
-	for _, docI := range scroll.Hits.Docs {
-		c.DocChan <- docI.(map[string]interface{})
+	if s.restarts >= scrollRestarts || !c.canRestart() {
+		c.Errors.Add(sourceErrors, fmt.Errorf("scroll expired after %d documents, a longer --time may help", s.Count))
+		s.Expired, s.Partial = true, true
+		return true
 	}
+	c.Errors.Add(notices, fmt.Errorf("scroll expired after %d documents, starting it over", s.Count))
+
+	c.Restarts.Lock()
+	defer c.Restarts.Unlock()
+	c.Checkpoint()
+	scroll, err := c.newScroll(s.indexes, s.slice)
+	if err != nil {
+		c.Errors.Add(sourceErrors, err)
+		s.Partial = true
+		return true
+	}
+	Printf("restarting the scroll of %s\n", s.indexes)
+	scroll.restarts, scroll.restarted = s.restarts+1, true
+	*s = *scroll
+	return false
+}
+
+// send writes a page of docs into DocChan, an empty page means the scroll
+// is exhausted
+func (s *Scroll) send(c *Config, docs []interface{}) (done bool) {
+
+	if len(docs) == 0 {
+		return true
+	}
+	for _, docI := range docs {
+		doc := docI.(map[string]interface{})
+		if s.restarted {
+			doc["_restarted"] = true
+		}
+		c.DocChan <- doc
+	}
+	s.Count += len(docs)
 
 	return
 }
 
-func (c *Config) NewWorker(docCount *int, bar *pb.ProgressBar, wg *sync.WaitGroup) {
+func (c *Config) NewWorker(docs <-chan map[string]interface{}, docCount *int, bar *pb.ProgressBar, wg *sync.WaitGroup, chain []DocumentTransformer) {
 
 	mainBuf := bytes.Buffer{}
 	docBuf := bytes.Buffer{}
 	docEnc := json.NewEncoder(&docBuf)
+	restarted := false // mainBuf has documents a restarted reader sent again
 
 READ_DOCS:
 	for {
 		var err error
-		docI, open := <-c.DocChan
+		docI, open := <-docs
+
+		// if channel is closed flush and gtfo
+		if !open {
+			goto WORKER_DONE
+		}
+		c.Progressed()
+
+		// a checkpoint waits until every worker has sent what it has
+		if release, ok := docI["_checkpoint"].(chan bool); ok {
+			if c.Out == nil {
+				c.sentAgain(c.BulkPost(&mainBuf, restarted), docCount, bar)
+			}
+			c.Flushed.Done()
+			<-release
+			continue
+		}
 
 		// this check is in case the document is an error with scroll stuff
 		if status, ok := docI["status"]; ok {
 			if status.(int) == 404 {
-				fmt.Println("error: ", docI["response"])
+				c.Errors.Add(sourceErrors, fmt.Errorf("error: %v", docI["response"]))
 				continue
 			}
 		}
 
-		// sanity check
-		for _, key := range []string{"_index", "_type", "_source", "_id"} {
+		// sanity check, hits from es 8 have no _type
+		for _, key := range []string{"_index", "_source", "_id"} {
 			if _, ok := docI[key]; !ok {
-				fmt.Println("failed parsing document: %v", docI)
-				break READ_DOCS
+				c.Errors.Add(docErrors, fmt.Errorf("failed parsing document: %v", docI))
+				continue READ_DOCS
 			}
 		}
+		docI = withType(docI)
 
 		doc := Document{
 			Index:  docI["_index"].(string),
@@ -274,32 +1206,88 @@ READ_DOCS:
 			Id:     docI["_id"].(string),
 		}
 
-		// if channel is closed flush and gtfo
-		if !open {
-			goto WORKER_DONE
-		}
+		// custom routing has to go along for documents to be found by it,
+		// and children need their parent
+		doc.Routing = hitMeta(docI, "_routing")
+		doc.Parent = hitMeta(docI, "_parent")
 
 		// sanity check
 		if len(doc.Index) == 0 || len(doc.Id) == 0 || len(doc.Type) == 0 {
-			c.ErrChan <- fmt.Errorf("failed decoding document: %+v", doc)
+			c.Errors.Add(docErrors, fmt.Errorf("failed decoding document: %+v", doc))
 			continue
 		}
 
+		// not listed, not sampled or over --max-docs, keep draining the channel
+		if c.Ids != nil && !c.Ids[doc.Id] || !c.Sampled(&doc) || !c.Limit.Take(doc.Index) {
+			continue
+		}
+
+		// dumps get the same documents as a destination would
+		c.JoinParent(&doc)
+		if err = c.TransformSource(&doc); err != nil {
+			c.Errors.Add(docErrors, err)
+			continue
+		}
+		if !c.runTransformChain(chain, &doc) {
+			continue
+		}
+
+		// --rate-limit-docs holds back every worker alike
+		c.DocRate.Wait(1)
+
+		// dumping to a file, no need to build up bulk requests
+		if c.Out != nil {
+			if c.ByteRate != nil {
+				b, _ := json.Marshal(doc.source)
+				c.ByteRate.Wait(len(b))
+			}
+			c.FlushLock.Lock()
+			err = c.Out.WriteDoc(&doc)
+			c.FlushLock.Unlock()
+			if err != nil {
+				c.Errors.Add(outputErrors, err)
+				continue
+			}
+			bar.Increment()
+			(*docCount)++
+			continue
+		}
+
+		// index, type and id on the destination
+		if doc.Id, err = c.DestId(&doc); err != nil {
+			c.Errors.Add(docErrors, err)
+			continue
+		}
+		if doc.Index, err = c.DestIndexFor(&doc); err == errIndexSkipped {
+			bar.Increment()
+			continue
+		} else if err != nil {
+			c.Errors.Add(destErrors, err)
+			continue
+		}
+		doc.Type = c.DestType(doc.Type)
+		doc.Routing = c.DestRouting(doc.Routing)
+
 		// encode the doc and and the _source field for a bulk request
 		post := map[string]Document{
 			"create": doc,
 		}
 		if err = docEnc.Encode(post); err != nil {
-			c.ErrChan <- err
+			c.Errors.Add(docErrors, err)
 		}
 		if err = docEnc.Encode(doc.source); err != nil {
-			c.ErrChan <- err
+			c.Errors.Add(docErrors, err)
 		}
-
-		// if we approach the 100mb es limit, flush to es and reset mainBuf
-		if mainBuf.Len()+docBuf.Len() > 100000000 {
-			c.BulkPost(&mainBuf)
+		c.ByteRate.Wait(docBuf.Len())
+
+		// if we approach the 100mb es limit, or less while es is busy, flush
+		// to es and reset mainBuf. documents a restarted reader sends again
+		// go in bulk requests of their own
+		again := docI["_restarted"] == true
+		if mainBuf.Len()+docBuf.Len() > c.Backoff.Size() || mainBuf.Len() > 0 && again != restarted {
+			c.sentAgain(c.BulkPost(&mainBuf, restarted), docCount, bar)
 		}
+		restarted = again
 
 		// append the doc to the main buffer
 		mainBuf.Write(docBuf.Bytes())
@@ -313,20 +1301,83 @@ WORKER_DONE:
 	if docBuf.Len() > 0 {
 		mainBuf.Write(docBuf.Bytes())
 	}
-	c.BulkPost(&mainBuf)
+	if c.Out == nil {
+		c.sentAgain(c.BulkPost(&mainBuf, restarted), docCount, bar)
+	}
 	wg.Done()
 }
 
-func (c *Config) GetIndexes(host string, idxs *Indexes) (err error) {
+// sentAgain takes the documents a restarted reader sent again that were
+// indexed already off the count, they were counted the first time
+func (c *Config) sentAgain(again int, docCount *int, bar *pb.ProgressBar) {
+
+	if again > 0 {
+		bar.Add(-again)
+		*docCount -= again
+	}
+}
+
+func (c *Config) GetIndexes(host *Host, idxs *Indexes) (err error) {
+
+	// wildcards and regexes are matched against every index of the source
+	patterns, err := ParseIndexPatterns(c.IndexNames)
+	if err != nil {
+		return err
+	}
+	resolve := false
+	for _, p := range patterns {
+		resolve = resolve || p.IsPattern()
+	}
+	names := c.IndexNames
+	if resolve {
+		names = "_all"
+	}
 
-	resp, err := http.Get(fmt.Sprintf("%s/%s/_mapping", host, c.IndexNames))
+	resp, err := host.Request("GET", fmt.Sprintf("/%s/_mapping", names), nil)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed getting mappings: %s %s", resp.Status, string(b))
+	}
 	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(idxs)
+	if err = dec.Decode(idxs); err != nil {
+		return err
+	}
+
+	if resolve {
+		for name := range *idxs {
+			matched := false
+			for _, p := range patterns {
+				matched = matched || p.Match(name)
+			}
+			if !matched {
+				delete(*idxs, name)
+			}
+		}
+	}
+
+	// drop whatever the user doesnt want after all
+	var excludes []IndexPattern
+	excludes, err = ParseIndexPatterns(c.ExcludeIndexes)
+	if err != nil {
+		return err
+	}
+	for name := range *idxs {
+		for _, p := range excludes {
+			if p.Match(name) {
+				delete(*idxs, name)
+				break
+			}
+		}
+	}
+
+	if (resolve || len(excludes) > 0) && len(*idxs) == 0 && len(c.Streams) == 0 {
+		return fmt.Errorf("no indexes match %s", c.IndexNames)
+	}
 
 	// always ignore internal _ indexes
 	for name, _ := range *idxs {
@@ -348,9 +1399,9 @@ func (c *Config) GetIndexes(host string, idxs *Indexes) (err error) {
 		}
 	}
 
-	// if _all indexes limit the list of indexes to only these that we kept
-	// after looking at mappings
-	if c.IndexNames == "_all" {
+	// if _all indexes, patterns or excludes limit the list of indexes to
+	// only these that we kept after looking at mappings
+	if c.IndexNames == "_all" || resolve || len(excludes) > 0 {
 		var newIndexes []string
 		for name, _ := range *idxs {
 			newIndexes = append(newIndexes, name)
@@ -367,18 +1418,43 @@ func (c *Config) GetIndexes(host string, idxs *Indexes) (err error) {
 		}
 	}
 
+	// only create the types that are copied
+	if c.Types != "" {
+		idxs.FilterTypes(strings.Split(c.Types, ","))
+	}
+
 	return
 }
 
 // CreateIndexes on remodeleted ES instance
 func (c *Config) CreateIndexes(idxs *Indexes) (err error) {
 
+	// all are checked before creating any, so abort leaves nothing behind
+	existing := map[string]bool{}
+	if c.IfExists != "" {
+		if existing, err = c.existingIndexes(idxs); err != nil {
+			return err
+		}
+	}
+	if c.IfExists == "abort" && len(existing) > 0 {
+		var names []string
+		for name := range existing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("indexes exist on the destination: %s", strings.Join(names, ", "))
+	}
+
 	for name, idx := range *idxs {
+		if existing[name] {
+			Println("appending to existing index: ", name)
+			continue
+		}
 		body := bytes.Buffer{}
 		enc := json.NewEncoder(&body)
 		enc.Encode(idx)
 
-		resp, err := http.Post(fmt.Sprintf("%s/%s", c.DstEs, name), "", &body)
+		resp, err := c.Dst.Request("PUT", fmt.Sprintf("/%s", name), &body)
 		if err != nil {
 			return err
 		}
@@ -389,7 +1465,7 @@ func (c *Config) CreateIndexes(idxs *Indexes) (err error) {
 			return fmt.Errorf("failed creating index: %s", string(b))
 		}
 
-		fmt.Println("created index: ", name)
+		Println("created index: ", name)
 	}
 
 	return
@@ -402,11 +1478,7 @@ func (c *Config) DeleteIndexes(idxs *Indexes) (err error) {
 		enc := json.NewEncoder(&body)
 		enc.Encode(idx)
 
-		req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/%s", c.DstEs, name), nil)
-		if err != nil {
-			return err
-		}
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := c.Dst.Request("DELETE", fmt.Sprintf("/%s", name), nil)
 		if err != nil {
 			return err
 		}
@@ -423,25 +1495,32 @@ func (c *Config) DeleteIndexes(idxs *Indexes) (err error) {
 			return fmt.Errorf("failed deleting index: %s", string(b))
 		}
 
-		fmt.Println("deleted index: ", name)
+		Println("deleted index: ", name)
 	}
 
 	return
 }
 
-func (c *Config) CopyShardingSettings(idxs *Indexes) (err error) {
+// CopyIndexSettings gives every index the settings of its source index
+// starting with prefix, all of them for an empty one: analysis, similarity,
+// sorting, max_result_window and so on, leaving out the read only ones.
+// Lifecycle settings are left to --copy-ilm. Destinations before es 6 can't
+// sort indexes
+func (c *Config) CopyIndexSettings(idxs *Indexes, prefix string) (err error) {
 
-	// get all settings
-	allSettings := map[string]interface{}{}
+	// flat so old and new versions look the same, index.number_of_shards
+	allSettings := map[string]struct {
+		Settings map[string]interface{} `json:"settings"`
+	}{}
 
-	resp, err := http.Get(fmt.Sprintf("%s/_all/_settings", c.SrcEs))
+	resp, err := c.Src.Request("GET", "/_all/_settings?flat_settings=true", nil)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
 		b, _ := ioutil.ReadAll(resp.Body)
-		resp.Body.Close()
 		return fmt.Errorf("failed getting settings for index: %s", string(b))
 	}
 
@@ -450,23 +1529,37 @@ func (c *Config) CopyShardingSettings(idxs *Indexes) (err error) {
 		return err
 	}
 
+	sorting := true
+	if c.Dst != nil {
+		major, _ := hostVersion(c.Dst)
+		sorting = major >= 6
+	}
+
 	for name, index := range *idxs {
-		if settings, ok := allSettings[name]; !ok {
+		found, ok := allSettings[name]
+		if !ok {
 			return fmt.Errorf("couldnt find index %s", name)
-		} else {
-			// omg XXX
-			index.(map[string]interface{})["settings"] = map[string]interface{}{}
-			var shards string
-			if _, ok := settings.(map[string]interface{})["settings"].(map[string]interface{})["index"]; ok {
-				// try the new style syntax first, which has an index object
-				shards = settings.(map[string]interface{})["settings"].(map[string]interface{})["index"].(map[string]interface{})["number_of_shards"].(string)
-			} else {
-				// if not, could be running from old es, try the old style index.number_of_shards
-				shards = settings.(map[string]interface{})["settings"].(map[string]interface{})["index.number_of_shards"].(string)
+		}
+
+		// under an index object, where the other settings go too
+		idx := index.(map[string]interface{})
+		if _, ok := idx["settings"].(map[string]interface{}); !ok {
+			idx["settings"] = map[string]interface{}{}
+		}
+		if _, ok := idx["settings"].(map[string]interface{})["index"].(map[string]interface{}); !ok {
+			idx["settings"].(map[string]interface{})["index"] = map[string]interface{}{}
+		}
+		settings := idx["settings"].(map[string]interface{})["index"].(map[string]interface{})
+		for key, val := range found.Settings {
+			key = strings.TrimPrefix(key, "index.")
+			if !strings.HasPrefix(key, prefix) || readOnlySetting(key) || key == "lifecycle" || strings.HasPrefix(key, "lifecycle.") {
+				continue
 			}
-			index.(map[string]interface{})["settings"].(map[string]interface{})["index"] = map[string]interface{}{
-				"number_of_shards": shards,
+			if strings.HasPrefix(key, "sort.") && !sorting {
+				Printf("%s: dropping %s, the destination can't sort indexes before es 6\n", name, key)
+				continue
 			}
+			settings[key] = val
 		}
 	}
 
@@ -502,48 +1595,158 @@ func (idxs *Indexes) DisableReplication() {
 	}
 }
 
+// FilterTypes drops the mappings of document types that are not in types
+func (idxs *Indexes) FilterTypes(types []string) {
+
+	keep := map[string]bool{}
+	for _, t := range types {
+		keep[strings.TrimSpace(t)] = true
+	}
+
+	for _, index := range *idxs {
+		mappings, ok := index.(map[string]interface{})["mappings"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for name := range mappings {
+			if !keep[name] {
+				delete(mappings, name)
+			}
+		}
+	}
+}
+
 // make the initial scroll req
-func (c *Config) NewScroll() (scroll *Scroll, err error) {
+func (c *Config) NewScroll(indexes string) (scroll *Scroll, err error) {
+	return c.newScroll(indexes, nil)
+}
+
+// newScroll starts scrolling indexes, or one slice of them with slice set to
+// its id and max
+func (c *Config) newScroll(indexes string, slice map[string]int) (scroll *Scroll, err error) {
 
 	// curl -XGET 'http://es-0.9:9200/_search?search_type=scan&scroll=10m&size=50'
-	url := fmt.Sprintf("%s/%s/_search?search_type=scan&scroll=%s&size=%d", c.SrcEs, c.IndexNames, c.ScrollTime, c.DocBufferCount)
-	resp, err := http.Get(url)
+	path := c.scrollPath(indexes, c.Types, c.Scan)
+
+	// only the shards holding the routing values need to be scrolled
+	if c.Routing != "" {
+		path += "&routing=" + url.QueryEscape(c.Routing)
+	}
+
+	// only send a body when documents or fields are filtered, or to sort
+	query := c.ScrollQuery()
+	if !c.Scan {
+		if query == nil {
+			query = map[string]interface{}{}
+		}
+		query["sort"] = []string{"_doc"}
+	}
+	if c.TrackHits {
+		query["track_total_hits"] = true
+	}
+	if c.AskMeta {
+		if query == nil {
+			query = map[string]interface{}{}
+		}
+		query["fields"] = []string{"_source", "_routing", "_parent"}
+	}
+	if slice != nil {
+		query["slice"] = slice
+	}
+	var body io.Reader
+	if query != nil {
+		b, err := json.Marshal(query)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	resp, err := c.Src.Request("GET", path, body)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed starting scroll: %s %s", resp.Status, string(b))
+	}
+
 	dec := json.NewDecoder(resp.Body)
 
-	scroll = &Scroll{}
+	scroll = &Scroll{indexes: indexes, slice: slice}
 	err = dec.Decode(scroll)
+	scroll.First = !c.Scan
+
+	// slices are counted together by NewSlicedScroll
+	if err == nil && slice == nil {
+		scroll.Hits.Total.Value = c.exactTotal(indexes, scroll.Hits.Total)
+	}
 
 	return
 }
 
-// Post to es as bulk and reset the data buffer
-func (c *Config) BulkPost(data *bytes.Buffer) {
+// Post to es as bulk and reset the data buffer. restarted is set for the
+// documents of a reader that started over, the number of them that were
+// indexed already is returned
+func (c *Config) BulkPost(data *bytes.Buffer, restarted bool) (again int) {
 
 	c.FlushLock.Lock()
 	defer c.FlushLock.Unlock()
+	defer data.Reset()
 
-	data.WriteRune('\n')
-	resp, err := http.Post(fmt.Sprintf("%s/_bulk", c.DstEs), "", data)
-	if err != nil {
-		c.ErrChan <- err
-		return
-	}
+	// documents es rejected because it was busy are sent again in smaller
+	// requests after a pause, the lock holds the other workers back meanwhile
+	body := data.Bytes()
+	for attempt := 1; len(body) > 0; attempt++ {
+		var retry []byte
+		var wait time.Duration
+		failed := 0
+		for _, chunk := range splitBulk(body, c.Backoff.Size()) {
+			rejected, after, lost, skipped, err := c.postBulk(chunk, restarted)
+			if err != nil {
+				c.Errors.Add(destErrors, err)
+				c.failBulk(chunk, 0, err.Error())
+				failed += bulkDocs(chunk)
+				continue
+			}
+			c.Progressed()
+			retry = append(retry, rejected...)
+			failed += lost
+			again += skipped
+			if after > wait {
+				wait = after
+			}
+		}
+		c.Retries.Indexed(attempt, bulkDocs(body)-bulkDocs(retry)-failed)
+		if len(retry) == 0 {
+			if attempt == 1 {
+				c.Backoff.Accepted()
+			}
+			return again
+		}
 
-	defer resp.Body.Close()
-	defer data.Reset()
-	if resp.StatusCode != 200 {
-		b, _ := ioutil.ReadAll(resp.Body)
-		c.ErrChan <- fmt.Errorf("bad bulk response: %s", string(b))
-		return
+		c.Backoff.Rejected()
+		if attempt > c.BulkRetries {
+			c.Errors.AddKind(destErrors, "rejected", fmt.Errorf("gave up on %d documents es kept rejecting", bulkDocs(retry)))
+			c.Retries.GiveUp(bulkDocs(retry))
+			c.failBulk(retry, 429, fmt.Sprintf("rejected %d times", attempt))
+			return again
+		}
+		if !c.Retries.Rejected(retry, attempt) {
+			c.Errors.AddKind(destErrors, "rejected", fmt.Errorf("retry budget of %d spent, gave up on %d documents es rejected", c.RetryBudget, bulkDocs(retry)))
+			c.Retries.GiveUp(bulkDocs(retry))
+			c.failBulk(retry, 429, "retry budget spent")
+			return again
+		}
+		time.Sleep(backoffDelay(attempt, wait))
+		body = retry
 	}
+	return again
 }
 
-func (c *Config) ClusterReady(host string) (*ClusterHealth, bool) {
+func (c *Config) ClusterReady(host *Host) (*ClusterHealth, bool) {
 
 	health := ClusterStatus(host)
 	if health.Status == "red" {
@@ -561,11 +1764,11 @@ func (c *Config) ClusterReady(host string) (*ClusterHealth, bool) {
 	return health, false
 }
 
-func ClusterStatus(host string) *ClusterHealth {
+func ClusterStatus(host *Host) *ClusterHealth {
 
-	resp, err := http.Get(fmt.Sprintf("%s/_cluster/health", host))
+	resp, err := host.Request("GET", "/_cluster/health", nil)
 	if err != nil {
-		return &ClusterHealth{Name: host, Status: "unreachable"}
+		return &ClusterHealth{Name: host.Url, Status: "unreachable"}
 	}
 	defer resp.Body.Close()
 
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MappingProblem is something in the mappings of an index that the
+// destination version won't take. Fatal ones fail creating the index or
+// every document with the field, the others only warn
+type MappingProblem struct {
+	Index   string
+	Field   string
+	Problem string
+	Fatal   bool
+}
+
+func (p MappingProblem) String() string {
+	if p.Field == "" {
+		return fmt.Sprintf("%s: %s", p.Index, p.Problem)
+	}
+	return fmt.Sprintf("%s %s: %s", p.Index, p.Field, p.Problem)
+}
+
+// CheckMappings reports every field and type in idxs the dst version won't
+// take, instead of finding out from thousands of failed bulk items. It fails
+// when any can't work, unless --force-version
+func (c *Config) CheckMappings(idxs Indexes, dst Version) error {
+
+	dstMajor, _, err := dst.Es()
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range idxs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []MappingProblem
+	for _, name := range names {
+		index, _ := idxs[name].(map[string]interface{})
+		mappings, ok := index["mappings"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		problems = append(problems, mappingProblems(name, mappings, dstMajor)...)
+	}
+
+	fatal := 0
+	for _, p := range problems {
+		if p.Fatal {
+			fatal++
+			Println("won't work:", p)
+		} else {
+			Println("deprecated:", p)
+		}
+	}
+	if fatal == 0 {
+		return nil
+	}
+	err = fmt.Errorf("%d fields or types in the mappings won't work on %s", fatal, dst)
+	if c.ForceVersion {
+		Println(err)
+		return nil
+	}
+	return err
+}
+
+// mappingProblems checks the types of one index and the fields under them
+func mappingProblems(index string, mappings map[string]interface{}, dst int) []MappingProblem {
+
+	var problems []MappingProblem
+	add := func(field, problem string, fatal bool) {
+		problems = append(problems, MappingProblem{index, field, problem, fatal})
+	}
+
+	if _, typeless := mappings["properties"]; typeless {
+		fieldProblems("", mappings, dst, add)
+		return problems
+	}
+
+	types := []string{}
+	for name := range mappings {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+
+	if len(types) > 1 && dst >= 6 {
+		add("", fmt.Sprintf("has %d types, es 6 and later take one, use --collapse-types or --rename-type", len(types)), true)
+	} else if len(types) == 1 && dst >= 7 {
+		add("", fmt.Sprintf("mapping is under type %s, es 7 and later want typeless mappings, use --collapse-types", types[0]), true)
+	}
+
+	for _, name := range types {
+		mapping, ok := mappings[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if all, ok := mapping["_all"].(map[string]interface{}); ok && dst >= 6 {
+			if dst >= 7 || all["enabled"] != false {
+				add(name+"._all", "_all is gone from es 6, use copy_to", true)
+			}
+		}
+		for _, meta := range []string{"_timestamp", "_ttl"} {
+			if _, ok := mapping[meta]; ok && dst >= 5 {
+				add(name+"."+meta, meta+" is gone from es 5", true)
+			}
+		}
+		if name == ".percolator" && dst >= 5 {
+			add(name, "the .percolator type is gone from es 5, queries go in a percolator field", true)
+		}
+		if _, ok := mapping["_parent"]; ok && dst >= 7 {
+			add(name+"._parent", "_parent is gone from es 7, use a join field", true)
+		}
+		fieldProblems(name+".", mapping, dst, add)
+	}
+	return problems
+}
+
+// fieldProblems checks the fields under properties and their multi-fields
+func fieldProblems(prefix string, mapping map[string]interface{}, dst int, add func(string, string, bool)) {
+
+	for _, key := range []string{"properties", "fields"} {
+		fields, _ := mapping[key].(map[string]interface{})
+		for name, field := range fields {
+			field, ok := field.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			path := prefix + name
+
+			switch field["type"] {
+			case "string":
+				add(path, "string fields are text or keyword from es 5", dst >= 6)
+			case "multi_field":
+				add(path, "multi_field is gone, use fields", dst >= 5)
+			}
+			if index, ok := field["index"].(string); ok && dst >= 5 {
+				add(path, fmt.Sprintf("index %s is true or false from es 5", index), dst >= 6)
+			}
+			if _, ok := field["norms"].(map[string]interface{}); ok && dst >= 5 {
+				add(path, "norms is true or false from es 5", dst >= 6)
+			}
+			if _, ok := field["fielddata"].(map[string]interface{}); ok && dst >= 5 {
+				add(path, "fielddata is true or false from es 5", true)
+			}
+			if _, ok := field["include_in_all"]; ok && dst >= 6 {
+				add(path, "include_in_all is gone from es 6", true)
+			}
+			for _, param := range []string{"index_name", "path"} {
+				if _, ok := field[param]; ok && dst >= 2 {
+					add(path, param+" is gone from es 2", true)
+				}
+			}
+
+			fieldProblems(path+".", field, dst, add)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// settings elasticsearch reports but refuses when creating an index, or that
+// only make sense on the original one like its blocks and the source of a
+// shrink, and everything under them
+var readOnlySettings = []string{"uuid", "version", "creation_date", "creation_date_string", "provided_name",
+	"history_uuid", "verified_before_close", "frozen", "blocks", "resize", "shrink",
+	"routing.allocation.initial_recovery", "store.snapshot"}
+
+// readOnlySetting is whether key, without its index. prefix, is read only
+func readOnlySetting(key string) bool {
+
+	for _, setting := range readOnlySettings {
+		if key == setting || strings.HasPrefix(key, setting+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// DumpMetadata writes the mappings, settings and aliases of every index to
+// dir as <index>.mappings.json, <index>.settings.json and <index>.aliases.json,
+// or the way elasticdump does with --output-format elasticdump
+func (c *Config) DumpMetadata(dir string, idxs *Indexes) (err error) {
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for name, idx := range *idxs {
+		files, err := c.IndexMetadata(name, idx)
+		if err != nil {
+			return err
+		}
+		if c.OutputFormat == "elasticdump" {
+			if err = writeElasticdumpMetadata(dir, name, files); err != nil {
+				return err
+			}
+			Println("dumped metadata for index: ", name)
+			continue
+		}
+		for kind, data := range files {
+			if err = writeJsonFile(filepath.Join(dir, fmt.Sprintf("%s.%s.json", name, kind)), data); err != nil {
+				return err
+			}
+		}
+
+		Println("dumped metadata for index: ", name)
+	}
+
+	return
+}
+
+// IndexMetadata fetches the mappings, settings and aliases of an index from
+// the source. The mappings are taken from idx as returned by GetIndexes
+func (c *Config) IndexMetadata(name string, idx interface{}) (map[string]interface{}, error) {
+
+	settings := map[string]interface{}{}
+	if err := c.getJson(c.Src, fmt.Sprintf("/%s/_settings", name), &settings); err != nil {
+		return nil, err
+	}
+
+	aliases := map[string]interface{}{}
+	if err := c.getJson(c.Src, fmt.Sprintf("/%s/_alias", name), &aliases); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"mappings": idx.(map[string]interface{})["mappings"],
+		"settings": unwrap(settings, name, "settings"),
+		"aliases":  unwrap(aliases, name, "aliases"),
+	}, nil
+}
+
+// LoadMetadata reads index definitions written by DumpMetadata or
+// elasticdump so they can be created on the destination
+func LoadMetadata(dir string) (Indexes, error) {
+
+	if isElasticdumpDir(dir) {
+		return LoadElasticdumpMetadata(dir)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.mappings.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no index metadata found in %s", dir)
+	}
+
+	idxs := Indexes{}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".mappings.json")
+		idx := map[string]interface{}{}
+
+		for _, kind := range []string{"mappings", "settings", "aliases"} {
+			var data interface{}
+			err := readJsonFile(filepath.Join(dir, fmt.Sprintf("%s.%s.json", name, kind)), &data)
+			if os.IsNotExist(err) && kind != "mappings" {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if data != nil {
+				idx[kind] = data
+			}
+		}
+
+		cleanSettings(idx)
+		idxs[name] = idx
+	}
+
+	return idxs, nil
+}
+
+// cleanSettings drops the settings that only make sense on the original index
+func cleanSettings(idx map[string]interface{}) {
+
+	settings, ok := idx["settings"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if index, ok := settings["index"].(map[string]interface{}); ok {
+		for key := range index {
+			if readOnlySetting(key) {
+				delete(index, key)
+			}
+		}
+	}
+	for key := range settings {
+		if strings.HasPrefix(key, "index.") && readOnlySetting(strings.TrimPrefix(key, "index.")) {
+			delete(settings, key)
+		}
+	}
+}
+
+// getJson decodes the response of a GET against host
+func (c *Config) getJson(host *Host, path string, v interface{}) error {
+
+	resp, err := host.Request("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed getting %s: %s", path, string(b))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// unwrap pulls {name: {key: value}} out of a per index response
+func unwrap(resp map[string]interface{}, name, key string) interface{} {
+
+	if idx, ok := resp[name].(map[string]interface{}); ok {
+		return idx[key]
+	}
+	return nil
+}
+
+func writeJsonFile(path string, v interface{}) error {
+
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+func readJsonFile(path string, v interface{}) error {
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed reading %s: %s", path, err)
+	}
+	return nil
+}
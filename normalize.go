@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateRule rewrites the dates of the fields matching Field, parsed with the
+// first of Layouts that fits. No layouts means strict dates or epoch millis
+type DateRule struct {
+	Field   IndexPattern
+	Layouts []string
+}
+
+// ParseDateRules reads --normalize-date rules, field or field:format||format
+// with java date formats like es mappings
+func ParseDateRules(rules []string) ([]DateRule, error) {
+
+	dateRules := []DateRule{}
+	for _, rule := range rules {
+		parts := strings.SplitN(rule, ":", 2)
+		patterns, err := ParseIndexPatterns(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(patterns) == 0 {
+			return nil, fmt.Errorf("bad --normalize-date %s, use field:format||format", rule)
+		}
+
+		dateRule := DateRule{Field: patterns[0]}
+		if len(parts) > 1 {
+			for _, format := range strings.Split(parts[1], "||") {
+				layout, err := javaLayout(format)
+				if err != nil {
+					return nil, err
+				}
+				dateRule.Layouts = append(dateRule.Layouts, layout)
+			}
+		}
+		dateRules = append(dateRules, dateRule)
+	}
+
+	return dateRules, nil
+}
+
+// CheckDateOutput validates --date-output
+func CheckDateOutput(format string) error {
+
+	if format == "iso8601" || format == "epoch_millis" {
+		return nil
+	}
+	_, err := formatJavaDate(format, time.Now())
+	return err
+}
+
+// normalizeDate parses v with the layouts of rule and formats it as output,
+// iso8601, epoch_millis or a java date format
+func normalizeDate(rule DateRule, output string, v interface{}) (interface{}, error) {
+
+	switch value := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		dates := make([]interface{}, len(value))
+		for i, item := range value {
+			date, err := normalizeDate(rule, output, item)
+			if err != nil {
+				return nil, err
+			}
+			dates[i] = date
+		}
+		return dates, nil
+	}
+
+	var t time.Time
+	var err error
+	if s, ok := v.(string); ok && len(rule.Layouts) > 0 {
+		err = fmt.Errorf("%s doesn't match any of the formats", s)
+		for _, layout := range rule.Layouts {
+			var parseErr error
+			if t, parseErr = time.Parse(layout, strings.TrimSpace(s)); parseErr == nil {
+				err = nil
+				break
+			}
+		}
+	} else {
+		t, err = parseTimestamp(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch output {
+	case "iso8601":
+		return t.UTC().Format("2006-01-02T15:04:05.000Z"), nil
+	case "epoch_millis":
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+	return formatJavaDate(output, t.UTC())
+}
+
+// go layouts of java date pattern letters, by letter and count
+var javaLayoutTokens = map[string]string{
+	"yyyy": "2006", "yy": "06", "uuuu": "2006",
+	"MMMM": "January", "MMM": "Jan", "MM": "01", "M": "1",
+	"dd": "02", "d": "2",
+	"EEEE": "Monday", "EEE": "Mon",
+	"HH": "15", "hh": "03", "h": "3",
+	"mm": "04", "m": "4",
+	"ss": "05", "s": "5",
+	"SSS": "000", "SSSSSS": "000000", "SSSSSSSSS": "000000000",
+	"a": "PM",
+	"Z": "-0700", "ZZ": "-07:00", "XXX": "Z07:00", "X": "Z0700", "z": "MST",
+}
+
+// javaLayout turns a java date format into a go layout for parsing. Text
+// between single quotes is taken literally
+func javaLayout(format string) (string, error) {
+
+	var layout strings.Builder
+	for i := 0; i < len(format); {
+		c := format[i]
+
+		if c == '\'' {
+			end := strings.IndexByte(format[i+1:], '\'')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed quote in %s", format)
+			}
+			layout.WriteString(format[i+1 : i+1+end])
+			i += end + 2
+			continue
+		}
+
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			layout.WriteByte(c)
+			i++
+			continue
+		}
+
+		n := 1
+		for i+n < len(format) && format[i+n] == c {
+			n++
+		}
+		token := format[i : i+n]
+		i += n
+
+		goToken, ok := javaLayoutTokens[token]
+		if !ok {
+			return "", fmt.Errorf("unsupported date format %s in %s", token, format)
+		}
+		layout.WriteString(goToken)
+	}
+
+	return layout.String(), nil
+}
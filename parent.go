@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PrepareParents finds the _parent relations of the mappings of idxs, by
+// source index and child type. Documents are indexed with their parent on
+// es 5 and earlier. From es 6 an index has a single type and no _parent, so
+// the relations become a join field of --join-field and documents get it
+// set to their type, or their type and parent, the way es wants them.
+// Mandatory routing, of children or required by the mappings, can't be
+// stripped
+func (c *Config) PrepareParents(idxs Indexes) error {
+
+	c.Parents = map[string]map[string]string{}
+	routed := []string{}
+	for name, index := range idxs {
+		mappings, _ := index.(map[string]interface{})["mappings"].(map[string]interface{})
+		if mappings == nil {
+			continue
+		}
+		if required(mappings) || hasFieldType(mappings, "join") {
+			routed = append(routed, name)
+		}
+		if _, typeless := mappings["properties"]; typeless {
+			continue
+		}
+		for typ, mapping := range mappings {
+			mapping, _ := mapping.(map[string]interface{})
+			parent, _ := mapping["_parent"].(map[string]interface{})
+			if parent == nil {
+				continue
+			}
+			if c.Parents[name] == nil {
+				c.Parents[name] = map[string]string{}
+			}
+			c.Parents[name][typ] = fmt.Sprint(parent["type"])
+		}
+		if c.Parents[name] != nil {
+			routed = append(routed, name)
+		}
+	}
+	if len(c.Parents) == 0 {
+		c.Parents = nil
+	}
+
+	if len(routed) > 0 && c.StripRouting {
+		sort.Strings(routed)
+		return fmt.Errorf("--strip-routing can't be used with %s, their documents need their routing", routed[0])
+	}
+	if c.Parents == nil || !c.NoParent {
+		return nil
+	}
+	if c.Strategy == "reindex" {
+		return fmt.Errorf("--strategy reindex can't turn the _parent relations of the source into a join field, copy with --strategy scroll")
+	}
+
+	for name, relations := range c.Parents {
+		join := map[string]interface{}{}
+		for child, parent := range relations {
+			children, _ := join[parent].([]interface{})
+			join[parent] = append(children, child)
+		}
+		field := map[string]interface{}{"type": "join", "relations": join}
+
+		// es 5 requires routing for child types, merged with the others it
+		// would for parents too. the join field makes children need it
+		mappings := idxs[name].(map[string]interface{})["mappings"].(map[string]interface{})
+		for typ, mapping := range mappings {
+			mapping, ok := mapping.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			delete(mapping, "_parent")
+			if _, child := relations[typ]; child {
+				delete(mapping, "_routing")
+			}
+			if _, ok := mapping["properties"].(map[string]interface{}); !ok {
+				mapping["properties"] = map[string]interface{}{}
+			}
+			mapping["properties"].(map[string]interface{})[c.JoinField] = field
+		}
+		Printf("%s: _parent relations become join field %s\n", name, c.JoinField)
+	}
+	return nil
+}
+
+// JoinParent sets the join field of documents of an index with _parent
+// relations from es 6 on: the type of a parent, the type and parent of a
+// child. Children keep going to the shard of their parent
+func (c *Config) JoinParent(doc *Document) {
+
+	if !c.NoParent {
+		return
+	}
+	relations := c.Parents[doc.Index]
+	if doc.Parent != "" {
+		if _, child := relations[doc.Type]; child {
+			doc.source[c.JoinField] = map[string]interface{}{"name": doc.Type, "parent": doc.Parent}
+		}
+		if doc.Routing == "" {
+			doc.Routing = doc.Parent
+		}
+		doc.Parent = ""
+		return
+	}
+	for _, parent := range relations {
+		if parent == doc.Type {
+			doc.source[c.JoinField] = doc.Type
+			return
+		}
+	}
+}
+
+// hitMeta is a metadata field of a hit, es 2 and later have it next to
+// _source, es 1 under the fields asked for
+func hitMeta(hit map[string]interface{}, key string) string {
+
+	if value, ok := hit[key].(string); ok {
+		return value
+	}
+	fields, _ := hit["fields"].(map[string]interface{})
+	switch value := fields[key].(type) {
+	case string:
+		return value
+	case []interface{}:
+		if len(value) > 0 {
+			return fmt.Sprint(value[0])
+		}
+	}
+	return ""
+}
+
+// required is whether the mappings of any type require routing
+func required(mappings map[string]interface{}) bool {
+
+	if routing, ok := mappings["_routing"].(map[string]interface{}); ok {
+		return routing["required"] == true
+	}
+	for _, mapping := range mappings {
+		mapping, _ := mapping.(map[string]interface{})
+		if routing, ok := mapping["_routing"].(map[string]interface{}); ok && routing["required"] == true {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// rows buffered in memory before they are written out as a row group
+const parquetRowGroupRows = 50000
+
+// ParquetSchemaFor returns the columns of a parquet dump, either from the
+// --parquet-schema file or inferred from the mappings of idxs
+func (c *Config) ParquetSchemaFor(idxs Indexes) ([]Column, error) {
+
+	columns := []Column{{"_index", "string"}, {"_id", "string"}}
+
+	if c.ParquetSchema == "" {
+		fields, err := MappingColumns(idxs)
+		if err != nil {
+			return nil, fmt.Errorf("%s, use --parquet-schema", err)
+		}
+		return append(columns, fields...), nil
+	}
+
+	var schema []Column
+	if err := readJsonFile(c.ParquetSchema, &schema); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{"_index": true, "_id": true}
+	for _, col := range schema {
+		switch col.Type {
+		case "string", "long", "double", "boolean":
+		default:
+			return nil, fmt.Errorf("unsupported type %s for column %s in %s", col.Type, col.Name, c.ParquetSchema)
+		}
+		if seen[col.Name] {
+			return nil, fmt.Errorf("column %s is listed twice in %s", col.Name, c.ParquetSchema)
+		}
+		seen[col.Name] = true
+	}
+
+	return append(columns, schema...), nil
+}
+
+// ParquetWriter writes documents as a parquet file with one optional column
+// per field. Row groups are buffered in memory and every column chunk is a
+// single gzip compressed page
+type ParquetWriter struct {
+	w       io.WriteCloser
+	offset  int64
+	columns []Column
+	values  [][]interface{} // buffered values of each column, nil when missing
+	rows    int
+	groups  []parquetRowGroup
+	total   int64
+	started bool
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	bytes  int64
+	rows   int
+}
+
+type parquetChunk struct {
+	offset       int64
+	compressed   int64
+	uncompressed int64
+	values       int
+}
+
+// NewParquetWriter writes documents to w with the given columns
+func NewParquetWriter(w io.WriteCloser, columns []Column) (*ParquetWriter, error) {
+
+	if len(columns) == 0 {
+		w.Close()
+		return nil, fmt.Errorf("parquet dumps need a schema")
+	}
+
+	return &ParquetWriter{
+		w:       w,
+		columns: columns,
+		values:  make([][]interface{}, len(columns)),
+	}, nil
+}
+
+func (p *ParquetWriter) WriteDoc(doc *Document) error {
+
+	fields := flatDoc(doc)
+	for i, col := range p.columns {
+		p.values[i] = append(p.values[i], columnValue(col.Type, fields[col.Name]))
+	}
+	p.rows++
+
+	if p.rows >= parquetRowGroupRows {
+		return p.flushRowGroup()
+	}
+	return nil
+}
+
+func (p *ParquetWriter) write(b []byte) error {
+
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+// flushRowGroup writes the buffered rows, one data page per column
+func (p *ParquetWriter) flushRowGroup() error {
+
+	if !p.started {
+		if err := p.write([]byte("PAR1")); err != nil {
+			return err
+		}
+		p.started = true
+	}
+	if p.rows == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{rows: p.rows}
+	for i, col := range p.columns {
+		page := encodeParquetPage(col.Type, p.values[i])
+
+		compressed := &bytes.Buffer{}
+		gz := gzip.NewWriter(compressed)
+		gz.Write(page)
+		gz.Close()
+
+		header := &thrift{}
+		header.Begin()
+		header.I32(1, 0) // data page
+		header.I32(2, int32(len(page)))
+		header.I32(3, int32(compressed.Len()))
+		header.Struct(5)
+		header.I32(1, int32(p.rows))
+		header.I32(2, 0) // plain
+		header.I32(3, 3) // rle definition levels
+		header.I32(4, 3) // rle repetition levels
+		header.End()
+		header.End()
+
+		chunk := parquetChunk{
+			offset:       p.offset,
+			compressed:   int64(header.Len() + compressed.Len()),
+			uncompressed: int64(header.Len() + len(page)),
+			values:       p.rows,
+		}
+		if err := p.write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(compressed.Bytes()); err != nil {
+			return err
+		}
+
+		group.chunks = append(group.chunks, chunk)
+		group.bytes += chunk.uncompressed
+		p.values[i] = p.values[i][:0]
+	}
+
+	p.groups = append(p.groups, group)
+	p.total += int64(p.rows)
+	p.rows = 0
+
+	return nil
+}
+
+// encodeParquetPage encodes the definition levels followed by the plain
+// encoded values that are present
+func encodeParquetPage(typ string, values []interface{}) []byte {
+
+	// definition levels as rle runs, 1 for present and 0 for missing
+	levels := &bytes.Buffer{}
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && (values[j] == nil) == (values[i] == nil) {
+			j++
+		}
+		putUvarint(levels, uint64(j-i)<<1)
+		if values[i] == nil {
+			levels.WriteByte(0)
+		} else {
+			levels.WriteByte(1)
+		}
+		i = j
+	}
+
+	page := &bytes.Buffer{}
+	binary.Write(page, binary.LittleEndian, uint32(levels.Len()))
+	page.Write(levels.Bytes())
+
+	var bits byte
+	var nbits uint
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			binary.Write(page, binary.LittleEndian, uint32(len(v)))
+			page.WriteString(v)
+		case int64:
+			binary.Write(page, binary.LittleEndian, v)
+		case float64:
+			binary.Write(page, binary.LittleEndian, math.Float64bits(v))
+		case bool:
+			// booleans are bit packed, least significant bit first
+			if v {
+				bits |= 1 << nbits
+			}
+			if nbits++; nbits == 8 {
+				page.WriteByte(bits)
+				bits, nbits = 0, 0
+			}
+		}
+	}
+	if nbits > 0 {
+		page.WriteByte(bits)
+	}
+
+	return page.Bytes()
+}
+
+// Close writes the remaining rows and the file footer
+func (p *ParquetWriter) Close() error {
+
+	if err := p.flushRowGroup(); err != nil {
+		p.w.Close()
+		return err
+	}
+
+	physical := map[string]int32{"boolean": 0, "long": 2, "double": 5, "string": 6}
+
+	meta := &thrift{}
+	meta.Begin()
+	meta.I32(1, 1)
+	meta.List(2, thriftStruct, len(p.columns)+1)
+	meta.Begin()
+	meta.Str(4, "schema")
+	meta.I32(5, int32(len(p.columns)))
+	meta.End()
+	for _, col := range p.columns {
+		meta.Begin()
+		meta.I32(1, physical[col.Type])
+		meta.I32(3, 1) // optional
+		meta.Str(4, col.Name)
+		if col.Type == "string" {
+			meta.I32(6, 0) // utf8
+		}
+		meta.End()
+	}
+	meta.I64(3, p.total)
+	meta.List(4, thriftStruct, len(p.groups))
+	for _, group := range p.groups {
+		meta.Begin()
+		meta.List(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			meta.Begin()
+			meta.I64(2, chunk.offset)
+			meta.Struct(3)
+			meta.I32(1, physical[p.columns[i].Type])
+			meta.List(2, thriftI32, 2)
+			meta.IntElem(0) // plain
+			meta.IntElem(3) // rle
+			meta.List(3, thriftBinary, 1)
+			meta.StrElem(p.columns[i].Name)
+			meta.I32(4, 2) // gzip
+			meta.I64(5, int64(chunk.values))
+			meta.I64(6, chunk.uncompressed)
+			meta.I64(7, chunk.compressed)
+			meta.I64(9, chunk.offset)
+			meta.End()
+			meta.End()
+		}
+		meta.I64(2, group.bytes)
+		meta.I64(3, int64(group.rows))
+		meta.End()
+	}
+	meta.Str(6, "elasticsearch-dump")
+	meta.End()
+
+	binary.Write(meta, binary.LittleEndian, uint32(meta.Len()))
+	meta.WriteString("PAR1")
+	if err := p.write(meta.Bytes()); err != nil {
+		p.w.Close()
+		return err
+	}
+
+	return p.w.Close()
+}
+
+// thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thrift encodes the structs of a parquet footer with the compact protocol
+type thrift struct {
+	bytes.Buffer
+	last []int16 // last field id of each open struct
+}
+
+func (t *thrift) field(id int16, typ byte) {
+
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		putUvarint(&t.Buffer, zigzag(int64(id)))
+	}
+	*last = id
+}
+
+// Begin starts a struct, either on its own or as an element of a list
+func (t *thrift) Begin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thrift) End() {
+	t.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thrift) Struct(id int16) {
+	t.field(id, thriftStruct)
+	t.Begin()
+}
+
+func (t *thrift) I32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.IntElem(int64(v))
+}
+
+func (t *thrift) I64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.IntElem(v)
+}
+
+func (t *thrift) Str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.StrElem(s)
+}
+
+func (t *thrift) List(id int16, elem byte, n int) {
+
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.WriteByte(0xf0 | elem)
+	putUvarint(&t.Buffer, uint64(n))
+}
+
+// IntElem writes an integer list element
+func (t *thrift) IntElem(v int64) {
+	putUvarint(&t.Buffer, zigzag(v))
+}
+
+// StrElem writes a string list element
+func (t *thrift) StrElem(s string) {
+	putUvarint(&t.Buffer, uint64(len(s)))
+	t.WriteString(s)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func putUvarint(b *bytes.Buffer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
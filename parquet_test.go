@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// thriftReader decodes compact protocol structs into maps of field id to
+// value, just enough to read back what thrift writes
+type thriftReader struct {
+	b []byte
+	i int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.i:])
+	r.i += n
+	return v
+}
+
+func (r *thriftReader) int() int64 {
+	u := r.uvarint()
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+
+	switch typ {
+	case thriftI32, thriftI64:
+		return r.int()
+	case thriftBinary:
+		n := int(r.uvarint())
+		r.i += n
+		return string(r.b[r.i-n : r.i])
+	case thriftList:
+		h := r.b[r.i]
+		r.i++
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		list := []interface{}{}
+		for ; n > 0; n-- {
+			list = append(list, r.value(h&0x0f))
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic("unexpected thrift type")
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		h := r.b[r.i]
+		r.i++
+		if h == 0 {
+			return fields
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.int())
+		}
+		fields[id] = r.value(h & 0x0f)
+		last = id
+	}
+}
+
+func TestThriftRoundTrip(t *testing.T) {
+
+	long := make([]int64, 20)
+	for i := range long {
+		long[i] = int64(i*i) - 50
+	}
+
+	w := &thrift{}
+	w.Begin()
+	w.I32(1, -1)
+	w.I64(2, math.MaxInt64)
+	w.Str(3, "héllo")
+	w.I32(40, 7) // a delta too big for the short form
+	w.I32(20, 8) // and a negative one
+	w.List(21, thriftI64, len(long))
+	for _, v := range long {
+		w.IntElem(v)
+	}
+	w.List(22, thriftBinary, 2)
+	w.StrElem("a")
+	w.StrElem("")
+	w.List(23, thriftStruct, 1)
+	w.Begin()
+	w.I32(1, 5)
+	w.End()
+	w.Struct(24)
+	w.Struct(1)
+	w.I64(16, math.MinInt64)
+	w.End()
+	w.End()
+	w.End()
+
+	list := []interface{}{}
+	for _, v := range long {
+		list = append(list, v)
+	}
+	want := map[int16]interface{}{
+		1:  int64(-1),
+		2:  int64(math.MaxInt64),
+		3:  "héllo",
+		40: int64(7),
+		20: int64(8),
+		21: list,
+		22: []interface{}{"a", ""},
+		23: []interface{}{map[int16]interface{}{1: int64(5)}},
+		24: map[int16]interface{}{1: map[int16]interface{}{16: int64(math.MinInt64)}},
+	}
+
+	r := &thriftReader{b: w.Bytes()}
+	if got := r.readStruct(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if r.i != w.Len() {
+		t.Errorf("read %d of %d bytes", r.i, w.Len())
+	}
+}
+
+// readParquet reads a file written by ParquetWriter back into its columns,
+// checking the layout on the way
+func readParquet(t *testing.T, b []byte) (names []string, columns [][]interface{}, groups int) {
+
+	if !bytes.HasPrefix(b, []byte("PAR1")) || !bytes.HasSuffix(b, []byte("PAR1")) {
+		t.Fatal("missing PAR1 magic")
+	}
+	size := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	meta := (&thriftReader{b: b[len(b)-8-size : len(b)-8]}).readStruct()
+
+	schema := meta[2].([]interface{})
+	types := []int64{}
+	for _, el := range schema[1:] {
+		el := el.(map[int16]interface{})
+		names = append(names, el[4].(string))
+		types = append(types, el[1].(int64))
+	}
+	if n := schema[0].(map[int16]interface{})[5].(int64); int(n) != len(names) {
+		t.Fatalf("schema root has %d children, want %d", n, len(names))
+	}
+	columns = make([][]interface{}, len(names))
+
+	var rows int64
+	for _, group := range meta[4].([]interface{}) {
+		group := group.(map[int16]interface{})
+		groupRows := group[3].(int64)
+		rows += groupRows
+		groups++
+
+		for i, chunk := range group[1].([]interface{}) {
+			chunkMeta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			if path := chunkMeta[3].([]interface{}); path[0] != names[i] {
+				t.Errorf("chunk %d is for %v, want %s", i, path, names[i])
+			}
+			if chunkMeta[5].(int64) != groupRows {
+				t.Errorf("chunk %d has %d values, want %d", i, chunkMeta[5], groupRows)
+			}
+
+			offset := int(chunkMeta[9].(int64))
+			r := &thriftReader{b: b[offset:]}
+			header := r.readStruct()
+			compressed := int(header[3].(int64))
+			if int64(r.i+compressed) != chunkMeta[7].(int64) {
+				t.Errorf("chunk %d is %d bytes, metadata says %d", i, r.i+compressed, chunkMeta[7])
+			}
+
+			gz, err := gzip.NewReader(bytes.NewReader(b[offset+r.i : offset+r.i+compressed]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, err := ioutil.ReadAll(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(page)) != header[2].(int64) {
+				t.Errorf("page is %d bytes, header says %d", len(page), header[2])
+			}
+			columns[i] = append(columns[i], decodeParquetPage(t, types[i], page, int(groupRows))...)
+		}
+	}
+
+	if meta[3].(int64) != rows {
+		t.Errorf("file has %d rows, row groups have %d", meta[3], rows)
+	}
+	return names, columns, groups
+}
+
+func decodeParquetPage(t *testing.T, typ int64, page []byte, rows int) []interface{} {
+
+	n := int(binary.LittleEndian.Uint32(page))
+	r := &thriftReader{b: page[4 : 4+n]}
+	present := []bool{}
+	for r.i < len(r.b) {
+		h := r.uvarint()
+		if h&1 != 0 {
+			t.Fatal("bit packed definition levels")
+		}
+		level := r.b[r.i]
+		r.i++
+		for k := 0; k < int(h>>1); k++ {
+			present = append(present, level == 1)
+		}
+	}
+	if len(present) != rows {
+		t.Fatalf("%d definition levels for %d rows", len(present), rows)
+	}
+
+	data := page[4+n:]
+	values := []interface{}{}
+	bit := 0
+	for _, ok := range present {
+		if !ok {
+			values = append(values, nil)
+			continue
+		}
+		switch typ {
+		case 0:
+			values = append(values, data[bit/8]&(1<<uint(bit%8)) != 0)
+			bit++
+		case 2:
+			values = append(values, int64(binary.LittleEndian.Uint64(data)))
+			data = data[8:]
+		case 5:
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(data)))
+			data = data[8:]
+		case 6:
+			l := int(binary.LittleEndian.Uint32(data))
+			values = append(values, string(data[4:4+l]))
+			data = data[4+l:]
+		}
+	}
+	if typ == 0 {
+		data = data[(bit+7)/8:]
+	}
+	if len(data) != 0 {
+		t.Errorf("%d bytes left over in a page of type %d", len(data), typ)
+	}
+
+	return values
+}
+
+func parquetDoc(t *testing.T, id, source string) *Document {
+
+	doc := &Document{Index: "idx", Id: id}
+	if err := json.Unmarshal([]byte(source), &doc.source); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+
+	columns := []Column{
+		{"_index", "string"},
+		{"_id", "string"},
+		{"name", "string"},
+		{"n", "long"},
+		{"x", "double"},
+		{"ok", "boolean"},
+		{"user.id", "string"},
+	}
+
+	tests := []struct {
+		id     string
+		source string
+		want   []interface{}
+	}{
+		{"1", `{"name":"a","n":1,"x":1.5,"ok":true,"user":{"id":"u1"}}`,
+			[]interface{}{"idx", "1", "a", int64(1), 1.5, true, "u1"}},
+		{"2", `{}`,
+			[]interface{}{"idx", "2", nil, nil, nil, nil, nil}},
+		{"3", `{"name":["x","y"],"n":"42","x":"-2","ok":"false"}`,
+			[]interface{}{"idx", "3", `["x","y"]`, int64(42), -2.0, false, nil}},
+		{"4", `{"name":"","n":1.5,"x":[1],"ok":1,"user":{"id":7}}`,
+			[]interface{}{"idx", "4", "", nil, nil, nil, "7"}},
+		{"5", `{"n":-9007199254740991,"x":1e300,"ok":false}`,
+			[]interface{}{"idx", "5", nil, int64(-9007199254740991), 1e300, false, nil}},
+		{"ünï", `{"name":"日本","ok":true}`,
+			[]interface{}{"idx", "ünï", "日本", nil, nil, true, nil}},
+	}
+
+	// enough rows for the booleans to span a few bytes
+	for i := 0; i < 3; i++ {
+		tests = append(tests, tests...)
+	}
+
+	var out bytes.Buffer
+	w, err := NewParquetWriter(nopWriteCloser{&out}, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		if err := w.WriteDoc(parquetDoc(t, tt.id, tt.source)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, got, groups := readParquet(t, out.Bytes())
+	if groups != 1 {
+		t.Errorf("got %d row groups, want 1", groups)
+	}
+	for i, col := range columns {
+		if names[i] != col.Name {
+			t.Errorf("column %d is %s, want %s", i, names[i], col.Name)
+		}
+	}
+	for row, tt := range tests {
+		for i := range columns {
+			if !reflect.DeepEqual(got[i][row], tt.want[i]) {
+				t.Errorf("row %d (%s) column %s: got %#v, want %#v", row, tt.source, columns[i].Name, got[i][row], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestParquetRowGroups(t *testing.T) {
+
+	tests := []struct {
+		rows   int
+		groups int
+	}{
+		{0, 0},
+		{1, 1},
+		{parquetRowGroupRows, 1},
+		{parquetRowGroupRows + 1, 2},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w, _ := NewParquetWriter(nopWriteCloser{&out}, []Column{{"_id", "string"}, {"n", "long"}})
+		for i := 0; i < tt.rows; i++ {
+			w.WriteDoc(&Document{Id: "x", source: map[string]interface{}{"n": float64(i)}})
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		_, got, groups := readParquet(t, out.Bytes())
+		if groups != tt.groups {
+			t.Errorf("%d rows: got %d row groups, want %d", tt.rows, groups, tt.groups)
+		}
+		if len(got[1]) != tt.rows {
+			t.Errorf("%d rows: read back %d", tt.rows, len(got[1]))
+			continue
+		}
+		for i, v := range got[1] {
+			if v != int64(i) {
+				t.Errorf("%d rows: row %d is %v", tt.rows, i, v)
+				break
+			}
+		}
+	}
+}
+
+func TestParquetNoSchema(t *testing.T) {
+
+	if _, err := NewParquetWriter(nopWriteCloser{&bytes.Buffer{}}, nil); err == nil {
+		t.Error("expected an error without columns")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// EnsurePercolatorMappings makes sure the destination indexes with
+// percolator fields have every field of the source mappings before any
+// document is copied. Queries are parsed against the mappings of their index
+// as they are indexed, so one on a field that isn't mapped yet fails, ie with
+// --docs-only or when appending to an index dynamic mapping made. Indexes
+// that don't exist are created with their mappings, dynamic mapping would
+// take the queries for objects
+func (c *Config) EnsurePercolatorMappings(idxs Indexes) error {
+
+	var names []string
+	for name, index := range idxs {
+		mappings, _ := index.(map[string]interface{})["mappings"].(map[string]interface{})
+		if hasFieldType(mappings, "percolator") && !c.isStream(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		idx := idxs[name].(map[string]interface{})
+		mappings := idx["mappings"].(map[string]interface{})
+
+		resp, err := c.Dst.Request("GET", "/"+name+"/_mapping", nil)
+		if err != nil {
+			return err
+		}
+		found := map[string]struct {
+			Mappings map[string]interface{} `json:"mappings"`
+		}{}
+		switch resp.StatusCode {
+		case 200:
+			err = json.NewDecoder(resp.Body).Decode(&found)
+			resp.Body.Close()
+			if err != nil {
+				return err
+			}
+		case 404:
+			resp.Body.Close()
+		default:
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return fmt.Errorf("failed getting mappings of %s: %s", name, string(b))
+		}
+
+		// an alias answers with the index behind it
+		if len(found) == 0 {
+			body, err := json.Marshal(idx)
+			if err != nil {
+				return err
+			}
+			if err := c.putPath("/"+name, body); err != nil {
+				return fmt.Errorf("failed creating index %s: %s", name, err)
+			}
+			Println("created index for its percolator queries: ", name)
+			continue
+		}
+		have := map[string]bool{}
+		for _, index := range found {
+			have = mappedFields(index.Mappings)
+		}
+
+		missing := 0
+		for field := range mappedFields(mappings) {
+			if !have[field] {
+				missing++
+			}
+		}
+		if missing == 0 {
+			continue
+		}
+
+		// es merges the mappings, adding the fields it doesn't have
+		paths := map[string]interface{}{"/" + name + "/_mapping": mappings}
+		if _, typeless := mappings["properties"]; !typeless {
+			paths = map[string]interface{}{}
+			for typ, mapping := range mappings {
+				paths["/"+name+"/_mapping/"+typ] = mapping
+			}
+		}
+		for path, mapping := range paths {
+			body, err := json.Marshal(mapping)
+			if err != nil {
+				return err
+			}
+			if err := c.putPath(path, body); err != nil {
+				return fmt.Errorf("failed adding the fields of percolator queries to %s: %s", name, err)
+			}
+		}
+		Printf("added %d fields to the mappings of %s for its percolator queries\n", missing, name)
+	}
+	return nil
+}
+
+// putPath sends body to path on the destination
+func (c *Config) putPath(path string, body []byte) error {
+
+	resp, err := c.Dst.Request("PUT", path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s", string(b))
+	}
+	return nil
+}
+
+// hasFieldType is whether a field anywhere in mappings is of type typ
+func hasFieldType(mappings interface{}, typ string) bool {
+
+	switch v := mappings.(type) {
+	case map[string]interface{}:
+		if v["type"] == typ {
+			return true
+		}
+		for _, val := range v {
+			if hasFieldType(val, typ) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mappedFields are the paths of the fields in mappings, of every type
+func mappedFields(mappings map[string]interface{}) map[string]bool {
+
+	fields := map[string]bool{}
+	var walk func(prefix string, mapping map[string]interface{})
+	walk = func(prefix string, mapping map[string]interface{}) {
+		properties, _ := mapping["properties"].(map[string]interface{})
+		for name, field := range properties {
+			fields[prefix+name] = true
+			if field, ok := field.(map[string]interface{}); ok {
+				walk(prefix+name+".", field)
+			}
+		}
+	}
+
+	if _, typeless := mappings["properties"]; typeless {
+		walk("", mappings)
+		return fields
+	}
+	for _, mapping := range mappings {
+		if mapping, ok := mapping.(map[string]interface{}); ok {
+			walk("", mapping)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// CheckCopyPipelines validates --copy-pipelines
+func (c *Config) CheckCopyPipelines() error {
+
+	switch c.CopyPipelines {
+	case "", "referenced", "all":
+		return nil
+	}
+	return fmt.Errorf("bad --copy-pipelines %s, use referenced or all", c.CopyPipelines)
+}
+
+// CreatePipelines copies ingest pipelines to the destination before any
+// index is created: every one with --copy-pipelines=all, otherwise those
+// the indexes of names have as default or final pipeline, --pipeline and
+// the ones those run in turn. Pipelines the destination has already are
+// kept unless --force
+func (c *Config) CreatePipelines(names []string) error {
+
+	// es answers 404 when there are no pipelines at all
+	resp, err := c.Src.Request("GET", "/_ingest/pipeline", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	pipelines := map[string]interface{}{}
+	switch resp.StatusCode {
+	case 200:
+		if err := json.NewDecoder(resp.Body).Decode(&pipelines); err != nil {
+			return err
+		}
+	case 404:
+	default:
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed getting pipelines: %s", string(b))
+	}
+
+	wanted := map[string]bool{}
+	if c.CopyPipelines == "all" {
+		for name := range pipelines {
+			wanted[name] = true
+		}
+	} else {
+		used, err := c.indexPipelines(names)
+		if err != nil {
+			return err
+		}
+		if c.Pipeline != "" {
+			used = append(used, c.Pipeline)
+		}
+		for len(used) > 0 {
+			name := used[0]
+			used = used[1:]
+			if wanted[name] {
+				continue
+			}
+			pipeline, ok := pipelines[name]
+			if !ok {
+				return fmt.Errorf("pipeline %s is used but not on the source", name)
+			}
+			wanted[name] = true
+			used = append(used, nestedPipelines(pipeline)...)
+		}
+	}
+
+	var sorted []string
+	for name := range wanted {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		if !c.Destructive {
+			resp, err := c.Dst.Request("GET", "/_ingest/pipeline/"+name, nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				Println("keeping existing pipeline: ", name)
+				continue
+			}
+		}
+
+		body, err := json.Marshal(pipelines[name])
+		if err != nil {
+			return err
+		}
+		resp, err := c.Dst.Request("PUT", "/_ingest/pipeline/"+name, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("failed creating pipeline %s: %s", name, string(b))
+		}
+		Println("created pipeline: ", name)
+	}
+	return nil
+}
+
+// indexPipelines are the default and final pipelines of the indexes of names
+func (c *Config) indexPipelines(names []string) ([]string, error) {
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+	settings := map[string]struct {
+		Settings struct {
+			Index struct {
+				Default string `json:"default_pipeline"`
+				Final   string `json:"final_pipeline"`
+			} `json:"index"`
+		} `json:"settings"`
+	}{}
+	if err := c.getJson(c.Src, "/"+strings.Join(names, ",")+"/_settings", &settings); err != nil {
+		return nil, err
+	}
+
+	var used []string
+	for _, index := range settings {
+		for _, name := range []string{index.Settings.Index.Default, index.Settings.Index.Final} {
+			if name != "" && name != "_none" {
+				used = append(used, name)
+			}
+		}
+	}
+	return used, nil
+}
+
+// nestedPipelines are the pipelines a pipeline processor runs, anywhere in
+// pipeline including on_failure. Names from templates can't be known
+func nestedPipelines(pipeline interface{}) []string {
+
+	var names []string
+	switch v := pipeline.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			if processor, ok := val.(map[string]interface{}); ok && key == "pipeline" {
+				if name, ok := processor["name"].(string); ok && !strings.Contains(name, "{{") {
+					names = append(names, name)
+				}
+			}
+			names = append(names, nestedPipelines(val)...)
+		}
+	case []interface{}:
+		for _, val := range v {
+			names = append(names, nestedPipelines(val)...)
+		}
+	}
+	return names
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+)
+
+// PitReader pages through the source with a point in time and search_after
+// rather than a scroll, es 7.10 and later. It is what newer clusters
+// recommend for reading everything, and all some hosted ones allow.
+// opensearch 2.4 and later have it under other paths
+type PitReader struct {
+	Id      string
+	Total   int
+	Count   int  // documents read so far
+	Partial bool // stopped on an error, not at the end
+	sort    []interface{}
+	after   []interface{}
+	first   []interface{} // the page read when opening, sent first
+	os      bool          // opensearch
+
+	// what is read, to start over when es lost the point in time
+	indexes   string
+	expired   bool
+	restarts  int
+	restarted bool // documents are sent again
+}
+
+type pitPage struct {
+	PitId string `json:"pit_id"`
+	Hits  struct {
+		Total HitsTotal                `json:"total"`
+		Docs  []map[string]interface{} `json:"hits"`
+	} `json:"hits"`
+	Shards struct {
+		Failures []struct {
+			Reason interface{} `json:"reason"`
+		} `json:"failures"`
+	} `json:"_shards"`
+}
+
+// NewPitReader opens a point in time on indexes and reads the first page
+func (c *Config) NewPitReader(indexes string) (*PitReader, error) {
+
+	version := hostInfo(c.Src)
+	path := fmt.Sprintf("/%s/_pit?keep_alive=%s", indexes, c.ScrollTime)
+	if version.OpenSearch() {
+		path = fmt.Sprintf("/%s/_search/point_in_time?keep_alive=%s", indexes, c.ScrollTime)
+	}
+	if c.Routing != "" {
+		path += "&routing=" + url.QueryEscape(c.Routing)
+	}
+	resp, err := c.Src.Request("POST", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed opening a point in time: %s %s", resp.Status, string(b))
+	}
+	pit := struct {
+		Id    string `json:"id"`
+		PitId string `json:"pit_id"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&pit); err != nil {
+		return nil, err
+	}
+	if version.OpenSearch() {
+		pit.Id = pit.PitId
+	}
+
+	// _shard_doc is the cheapest order, from 7.12. before it _id breaks ties
+	p := &PitReader{Id: pit.Id, indexes: indexes, sort: []interface{}{map[string]string{"_id": "asc"}}, os: version.OpenSearch()}
+	if major, minor, _ := version.Es(); major > 7 || major == 7 && minor >= 12 {
+		p.sort = []interface{}{map[string]string{"_shard_doc": "asc"}}
+	}
+
+	page, err := p.search(c, true)
+	if err != nil {
+		p.Clear(c)
+		return nil, err
+	}
+	p.Total = c.exactTotal(indexes, page.Hits.Total)
+	p.first = p.docs(page)
+	return p, nil
+}
+
+func (p *PitReader) Next(c *Config) (done bool) {
+
+	docs := p.first
+	p.first = nil
+	if docs == nil {
+		page, err := p.search(c, false)
+		if err != nil && p.expired {
+			return p.restart(c)
+		}
+		if err != nil {
+			c.Errors.Add(sourceErrors, err)
+			p.Partial = true
+			return true
+		}
+		docs = p.docs(page)
+	}
+
+	if len(docs) == 0 && !p.expired {
+		return true
+	}
+	for _, docI := range docs {
+		doc := docI.(map[string]interface{})
+		if p.restarted {
+			doc["_restarted"] = true
+		}
+		c.DocChan <- doc
+	}
+	p.Count += len(docs)
+	if p.expired {
+		return p.restart(c)
+	}
+	return false
+}
+
+// restart reads everything again with a new point in time when es lost the
+// last one, marking the documents like a scroll that starts over
+func (p *PitReader) restart(c *Config) (done bool) {
+
+	if p.restarts >= scrollRestarts || !c.canRestart() {
+		c.Errors.Add(sourceErrors, fmt.Errorf("point in time expired after %d documents, a longer --time may help", p.Count))
+		p.Partial = true
+		return true
+	}
+	c.Errors.Add(notices, fmt.Errorf("point in time expired after %d documents, starting it over", p.Count))
+
+	c.Restarts.Lock()
+	defer c.Restarts.Unlock()
+	c.Checkpoint()
+	pit, err := c.NewPitReader(p.indexes)
+	if err != nil {
+		c.Errors.Add(sourceErrors, err)
+		p.Partial = true
+		return true
+	}
+	Printf("restarting the point in time of %s\n", p.indexes)
+	pit.restarts, pit.restarted = p.restarts+1, true
+	*p = *pit
+	return false
+}
+
+// search gets the page after the last one, counting the hits with the first
+func (p *PitReader) search(c *Config, first bool) (*pitPage, error) {
+
+	body := c.ScrollQuery()
+	if body == nil {
+		body = map[string]interface{}{}
+	}
+	body["size"] = c.DocBufferCount
+	body["pit"] = map[string]string{"id": p.Id, "keep_alive": c.ScrollTime}
+	body["sort"] = p.sort
+	body["track_total_hits"] = first
+	if p.after != nil {
+		body["search_after"] = p.after
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.Src.Request("POST", "/_search?"+hitsFilter, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		if contextMissing(string(b)) {
+			p.expired = true
+		}
+		return nil, fmt.Errorf("search response: %s %s", resp.Status, string(b))
+	}
+
+	page := &pitPage{}
+	if err := json.NewDecoder(resp.Body).Decode(page); err != nil {
+		return nil, err
+	}
+	for _, failure := range page.Shards.Failures {
+		if contextMissing(fmt.Sprint(failure.Reason)) {
+			p.expired = true
+			continue
+		}
+		c.Errors.Add(sourceErrors, fmt.Errorf("%v", failure.Reason))
+		p.Partial = true
+	}
+
+	// es may hand out a new id for the point in time with every page
+	if page.PitId != "" {
+		p.Id = page.PitId
+	}
+	if n := len(page.Hits.Docs); n > 0 {
+		p.after, _ = page.Hits.Docs[n-1]["sort"].([]interface{})
+	}
+	return page, nil
+}
+
+func (p *PitReader) docs(page *pitPage) []interface{} {
+
+	docs := make([]interface{}, len(page.Hits.Docs))
+	for i, doc := range page.Hits.Docs {
+		delete(doc, "sort")
+		docs[i] = doc
+	}
+	return docs
+}
+
+// Clear closes the point in time rather than leaving it to expire
+func (p *PitReader) Clear(c *Config) error {
+
+	if p.Id == "" {
+		return nil
+	}
+	path, body := "/_pit", map[string]interface{}{"id": p.Id}
+	if p.os {
+		path, body = "/_search/point_in_time", map[string]interface{}{"pit_id": []string{p.Id}}
+	}
+	b, _ := json.Marshal(body)
+	resp, err := c.Src.Request("DELETE", path, strings.NewReader(string(b)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 404 {
+		return fmt.Errorf("failed closing point in time: %s", resp.Status)
+	}
+	return nil
+}
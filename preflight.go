@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Preflight makes sure both hosts answer and let the copy read, create
+// indexes and write, and that the destination takes the mappings of idxs and
+// has the analysis plugins they use, before anything is done. Every problem is returned rather than the first one
+func (c *Config) Preflight(srcNames, dstNames []string, idxs Indexes) []error {
+
+	var problems []error
+	var srcVersion, dstVersion Version
+	if c.Src != nil {
+		var err error
+		if srcVersion, err = checkHost(c.Src, "source"); err != nil {
+			problems = append(problems, err)
+		} else {
+			problems = append(problems, checkPrivileges(c.Src, "source", srcNames, []string{"read"})...)
+			if err := checkScroll(c.Src, srcNames); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+
+	if c.Dst != nil {
+		privileges := []string{"write"}
+		if !c.DocsOnly {
+			privileges = append(privileges, "create_index")
+		}
+		var err error
+		if dstVersion, err = checkHost(c.Dst, "destination"); err != nil {
+			problems = append(problems, err)
+		} else {
+			problems = append(problems, checkPrivileges(c.Dst, "destination", dstNames, privileges)...)
+			if err := c.CheckDiskSpace(srcNames); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+
+	if srcVersion.Number != "" && dstVersion.Number != "" {
+		if err := c.CheckVersions(srcVersion, dstVersion); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	// with --docs-only the destination mappings are already there
+	if dstVersion.Number != "" && !c.DocsOnly {
+		if err := c.CheckMappings(idxs, dstVersion); err != nil {
+			problems = append(problems, err)
+		}
+		if err := c.CheckAnalysisPlugins(idxs); err != nil {
+			problems = append(problems, err)
+		}
+	}
+
+	return problems
+}
+
+// checkHost tells apart a host that doesn't answer from credentials it
+// doesn't take, and returns its version
+func checkHost(host *Host, role string) (Version, error) {
+
+	resp, err := host.Request("GET", "/", nil)
+	if err != nil {
+		return Version{}, fmt.Errorf("the %s can't be reached: %s", role, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+	case 401, 403:
+		return Version{}, fmt.Errorf("the %s didn't take the credentials: %s", role, resp.Status)
+	default:
+		return Version{}, fmt.Errorf("the %s answered %s", role, resp.Status)
+	}
+
+	info := struct {
+		Version struct {
+			Number       string `json:"number"`
+			Distribution string `json:"distribution"`
+		} `json:"version"`
+	}{}
+	json.NewDecoder(resp.Body).Decode(&info)
+	return Version{info.Version.Number, info.Version.Distribution}, nil
+}
+
+// checkPrivileges asks es whether the user has privileges on the indexes.
+// Clusters without security, or too old to ask, allow everything
+func checkPrivileges(host *Host, role string, names, privileges []string) []error {
+
+	if len(names) == 0 {
+		return nil
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"index": []interface{}{map[string]interface{}{"names": names, "privileges": privileges}},
+	})
+	resp, err := host.Request("POST", "/_security/user/_has_privileges", bytes.NewReader(body))
+	if err != nil {
+		return []error{err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil
+	}
+
+	result := struct {
+		HasAll bool                       `json:"has_all_requested"`
+		Index  map[string]map[string]bool `json:"index"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.HasAll {
+		return nil
+	}
+
+	var problems []error
+	for _, name := range names {
+		var missing []string
+		for _, privilege := range privileges {
+			if !result.Index[name][privilege] {
+				missing = append(missing, privilege)
+			}
+		}
+		if len(missing) > 0 {
+			sort.Strings(missing)
+			problems = append(problems, fmt.Errorf("the %s user has no %s privilege on %s", role, strings.Join(missing, ", "), name))
+		}
+	}
+	return problems
+}
+
+// checkScroll starts and clears a scroll of a single document
+func checkScroll(host *Host, names []string) error {
+
+	if len(names) == 0 {
+		return nil
+	}
+	resp, err := host.Request("GET", "/"+strings.Join(names, ",")+"/_search?scroll=1m&size=1", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("the source doesn't allow scrolling: %s %s", resp.Status, string(b))
+	}
+	scroll := Scroll{}
+	if err := json.NewDecoder(resp.Body).Decode(&scroll); err == nil && scroll.ScrollId != "" {
+		clearScroll(host, scroll.ScrollId)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// a bare duration like 30d is taken as that long ago
+var relativeTime = regexp.MustCompile(`^[0-9]+[smhdwMy]$`)
+
+// ScrollQuery builds the body of the scroll request from the flags that
+// select documents and fields, nil when everything is copied. Clauses go in a
+// bool must so older versions without bool filters understand it too
+func (c *Config) ScrollQuery() map[string]interface{} {
+
+	body := map[string]interface{}{}
+
+	// heavy fields are best dropped before they are sent at all
+	source := map[string]interface{}{}
+	if c.SourceInclude != "" {
+		source["includes"] = strings.Split(c.SourceInclude, ",")
+	}
+	if c.SourceExclude != "" {
+		source["excludes"] = strings.Split(c.SourceExclude, ",")
+	}
+	if len(source) > 0 {
+		body["_source"] = source
+	}
+
+	must := []interface{}{}
+
+	// an ids query has no limit on the number of values, unlike terms
+	if c.Ids != nil {
+		ids := make([]string, 0, len(c.Ids))
+		for id := range c.Ids {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		must = append(must, map[string]interface{}{
+			"ids": map[string]interface{}{"values": ids},
+		})
+	}
+
+	if c.Query != "" {
+		must = append(must, map[string]interface{}{
+			"query_string": map[string]interface{}{"query": c.Query},
+		})
+	}
+
+	// routing only picks the shards, other tenants can share them
+	if c.Routing != "" {
+		must = append(must, map[string]interface{}{
+			"terms": map[string]interface{}{"_routing": strings.Split(c.Routing, ",")},
+		})
+	}
+
+	if c.Since != "" || c.Until != "" {
+		bounds := map[string]interface{}{}
+		if c.Since != "" {
+			bounds["gte"] = timeBound(c.Since)
+		}
+		if c.Until != "" {
+			bounds["lt"] = timeBound(c.Until)
+		}
+		must = append(must, map[string]interface{}{
+			"range": map[string]interface{}{c.TimeField: bounds},
+		})
+	}
+
+	if len(must) > 0 {
+		body["query"] = map[string]interface{}{
+			"bool": map[string]interface{}{"must": must},
+		}
+	}
+
+	if len(body) == 0 {
+		return nil
+	}
+	return body
+}
+
+// timeBound passes dates and date math through to es as is, and turns 30d
+// into now-30d
+func timeBound(t string) string {
+
+	if relativeTime.MatchString(t) {
+		return "now-" + t
+	}
+	return t
+}
+
+// ReadIds loads the ids of --ids-file, one per line
+func ReadIds(path string) (map[string]bool, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			ids[id] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no ids in %s", path)
+	}
+
+	return ids, nil
+}
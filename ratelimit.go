@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket shared by every worker. It fills up with Rate
+// tokens a second and holds a second worth of them, so short bursts pass
+type RateLimit struct {
+	sync.Mutex
+	Rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimit returns nil for no limit
+func NewRateLimit(rate float64) *RateLimit {
+
+	if rate <= 0 {
+		return nil
+	}
+	return &RateLimit{Rate: rate, tokens: rate, last: time.Now()}
+}
+
+// Wait takes n tokens, sleeping until the bucket had them. Taking more than
+// the bucket holds leaves it in debt, which the next callers wait out
+func (r *RateLimit) Wait(n int) {
+
+	if r == nil {
+		return
+	}
+
+	r.Lock()
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.Rate
+	if r.tokens > r.Rate {
+		r.tokens = r.Rate
+	}
+	r.last = now
+	r.tokens -= float64(n)
+	wait := time.Duration(-r.tokens / r.Rate * float64(time.Second))
+	r.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   float64
+}{
+	{"gb", 1 << 30},
+	{"mb", 1 << 20},
+	{"kb", 1 << 10},
+	{"b", 1},
+}
+
+// ParseByteRate reads --rate-limit-bytes, a size like 5mb or 512kb, plain
+// numbers are bytes
+func ParseByteRate(rate string) (float64, error) {
+
+	value, size := strings.ToLower(strings.TrimSpace(rate)), 1.0
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, size = strings.TrimSuffix(value, unit.suffix), unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad --rate-limit-bytes %s, use a size like 5mb", rate)
+	}
+
+	return n * size, nil
+}
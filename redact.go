@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// what masked values are replaced with
+const maskText = "****"
+
+// MaskRule redacts the fields matching Field, Mode is full, partial, last4
+// or hash with a salted sha256
+type MaskRule struct {
+	Field IndexPattern
+	Mode  string
+	Salt  string
+}
+
+// ParseMaskRules reads --mask, a comma separated list of field=mode
+func ParseMaskRules(list string) ([]MaskRule, error) {
+
+	rules := []MaskRule{}
+	for _, rule := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("bad mask %s, use field=full, field=partial or field=last4", rule)
+		}
+		switch parts[1] {
+		case "full", "partial", "last4":
+		default:
+			return nil, fmt.Errorf("unknown mask %s for %s, use full, partial or last4", parts[1], parts[0])
+		}
+
+		patterns, err := ParseIndexPatterns(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, MaskRule{Field: patterns[0], Mode: parts[1]})
+	}
+
+	return rules, nil
+}
+
+// HashRules reads --hash-field, a comma separated list of fields whose values
+// are replaced by their sha256 with salt
+func HashRules(list, salt string) ([]MaskRule, error) {
+
+	rules := []MaskRule{}
+	for _, field := range strings.Split(list, ",") {
+		patterns, err := ParseIndexPatterns(field)
+		if err != nil {
+			return nil, err
+		}
+		if len(patterns) == 0 {
+			return nil, fmt.Errorf("bad --hash-field %s", list)
+		}
+		rules = append(rules, MaskRule{Field: patterns[0], Mode: "hash", Salt: salt})
+	}
+
+	return rules, nil
+}
+
+// maskFields redacts the fields of obj matching a rule, the first rule that
+// matches wins. Objects in arrays are looked into as well
+func maskFields(prefix string, obj map[string]interface{}, rules []MaskRule) {
+
+	for key, v := range obj {
+		path := prefix + key
+
+		masked := false
+		for _, rule := range rules {
+			if rule.Field.Match(path) {
+				obj[key] = maskValue(v, rule)
+				masked = true
+				break
+			}
+		}
+		if masked {
+			continue
+		}
+
+		switch v := v.(type) {
+		case map[string]interface{}:
+			maskFields(path+".", v, rules)
+		case []interface{}:
+			for _, item := range v {
+				if item, ok := item.(map[string]interface{}); ok {
+					maskFields(path+".", item, rules)
+				}
+			}
+		}
+	}
+}
+
+// maskValue redacts a value, numbers become masked strings. Objects are
+// masked entirely
+func maskValue(v interface{}, rule MaskRule) interface{} {
+
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = maskValue(item, rule)
+		}
+		return masked
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return maskText
+	}
+
+	runes := []rune(s)
+	switch rule.Mode {
+	case "hash":
+		// the same value always gets the same hash, so it can still be
+		// joined on
+		sum := sha256.Sum256([]byte(rule.Salt + s))
+		return hex.EncodeToString(sum[:])
+	case "partial":
+		// keep the first letter and the domain of emails, the first and
+		// last letter of anything else
+		if at := strings.LastIndex(s, "@"); at > 0 {
+			return string(runes[0]) + maskText + s[at:]
+		}
+		if len(runes) > 4 {
+			return string(runes[0]) + maskText + string(runes[len(runes)-1])
+		}
+	case "last4":
+		if len(runes) > 4 {
+			return maskText + string(runes[len(runes)-4:])
+		}
+	}
+
+	return maskText
+}
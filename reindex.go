@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	pb "github.com/cheggaaa/pb"
+)
+
+// how often the tasks api is asked how a reindex is doing
+const reindexPoll = 2 * time.Second
+
+var strategies = map[string]bool{
+	"scroll":  true,
+	"reindex": true,
+}
+
+// CheckStrategy makes sure the flags given can be honored by --strategy.
+// With reindex documents go from one es to the other without passing
+// through here, so nothing can change them on the way
+func (c *Config) CheckStrategy() error {
+
+	if !strategies[c.Strategy] {
+		return fmt.Errorf("unknown --strategy %s, use scroll or reindex", c.Strategy)
+	}
+	if c.Strategy != "reindex" {
+		return nil
+	}
+	if c.Src == nil || c.Dst == nil {
+		return fmt.Errorf("--strategy reindex copies from one es to another")
+	}
+
+	unsupported := []struct {
+		set  bool
+		flag string
+	}{
+		{c.AwsRegion != "", "--aws-region"},
+		{c.StateFile != "", "--state"},
+		{c.Pit, "--pit"},
+		{c.Routing != "", "--routing"},
+		{c.Sample != "", "--sample"},
+		{c.MaxDocs > 0, "--max-docs"},
+		{c.MaxIndexDocs > 0, "--max-index-docs"},
+		{c.TimeIndex != "", "--time-index"},
+		{c.DestIndexTemplate != "", "--dest-index-template"},
+		{c.RenameType != "", "--rename-type"},
+		{c.CollapseTypes && !c.Typeless, "--collapse-types"},
+		{len(c.RenameFields) > 0, "--rename-field"},
+		{len(c.DropFields) > 0, "--drop-field"},
+		{len(c.SetFields) > 0, "--set-field"},
+		{c.Mask != "", "--mask"},
+		{c.HashFields != "", "--hash-field"},
+		{c.EncryptFields != "", "--encrypt-field"},
+		{c.DecryptFields != "", "--decrypt-field"},
+		{c.Coerce != "", "--coerce"},
+		{len(c.NormalizeDates) > 0, "--normalize-date"},
+		{c.TransformScript != "", "--transform-script"},
+		{c.TransformCmd != "", "--transform-cmd"},
+		{len(c.Transforms) > 0, "--transform"},
+		{c.IdStrategy != "keep", "--id-strategy"},
+		{c.StripRouting, "--strip-routing"},
+		{c.RemapRouting != "", "--remap-routing"},
+		{c.DeadLetterFile != "", "--dead-letter"},
+		{c.RateLimitBytes != "", "--rate-limit-bytes"},
+	}
+	for _, u := range unsupported {
+		if u.set {
+			return fmt.Errorf("%s can't be used with --strategy reindex, documents don't pass through the tool", u.flag)
+		}
+	}
+
+	if major, _ := hostVersion(c.Dst); major < 5 {
+		return fmt.Errorf("--strategy reindex needs es 5 or later on the destination")
+	}
+	return nil
+}
+
+// ReindexTask is a _reindex running on the destination
+type ReindexTask struct {
+	Index string // source index
+	Id    string
+	done  int
+	total int
+}
+
+type reindexStatus struct {
+	Total     int `json:"total"`
+	Created   int `json:"created"`
+	Updated   int `json:"updated"`
+	Deleted   int `json:"deleted"`
+	Conflicts int `json:"version_conflicts"`
+}
+
+type taskResult struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status reindexStatus `json:"status"`
+	} `json:"task"`
+	Response struct {
+		reindexStatus
+		Failures []struct {
+			Id     string      `json:"id"`
+			Index  string      `json:"index"`
+			Cause  interface{} `json:"cause"`
+			Reason interface{} `json:"reason"`
+		} `json:"failures"`
+	} `json:"response"`
+	Error interface{} `json:"error"`
+}
+
+// Reindex has the destination pull every index of names from the source
+// with _reindex, --workers indexes at a time, and follows the tasks until
+// they are done. It prints the outcome and returns the exit status
+func (c *Config) Reindex(names []string) int {
+
+	// a cluster reindexing into itself needs no remote, and can slice
+	local := sameCluster(c.Src, c.Dst)
+	if !local && c.Slices > 1 {
+		Println("reindexing from a remote can't be sliced, indexes are reindexed --workers at a time instead")
+	}
+
+	bar := pb.New(0)
+	bar.Output = logOutput
+	bar.Start()
+
+	c.TrapSignals()
+	c.StartWatchdog(time.Duration(c.StallTimeout) * time.Minute)
+
+	var lock sync.Mutex
+	total, copied := 0, 0
+	wg := sync.WaitGroup{}
+	workers := make(chan bool, c.Workers)
+	for _, name := range names {
+		if c.Stopped() {
+			break
+		}
+		workers <- true
+		wg.Add(1)
+		go func(name string) {
+			defer func() { <-workers; wg.Done() }()
+
+			task, err := c.startReindex(name, local)
+			if err != nil {
+				c.Errors.Add(destErrors, err)
+				c.Partial = true
+				return
+			}
+			n := c.followReindex(task, func(done, more int) {
+				lock.Lock()
+				total += more
+				bar.SetTotal(total)
+				lock.Unlock()
+				bar.Add(done)
+			})
+			lock.Lock()
+			copied += n
+			lock.Unlock()
+		}(name)
+	}
+	wg.Wait()
+
+	if (c.CopyAliases || c.Rollover != nil) && !c.Stopped() {
+		if err := c.CreateAliases(names); err != nil {
+			c.Errors.Add(destErrors, err)
+		}
+	}
+	if c.CopyWatches && !c.Stopped() {
+		if err := c.CreateWatches(); err != nil {
+			c.Errors.Add(destErrors, err)
+		}
+	}
+	c.Errors.Print()
+	if c.Existing > 0 {
+		Println(c.Existing, "documents were on the destination already")
+	}
+	switch {
+	case c.Stopped():
+		bar.FinishPrint(fmt.Sprintln("Stopped early, reindexed", copied, "documents,", c.Failed, "failed"))
+		return c.stopStatus()
+	case c.Partial:
+		bar.FinishPrint(fmt.Sprintln("Reindexing failed, reindexed", copied, "documents,", c.Failed, "failed"))
+		return exitPartial
+	case c.Failed > 0:
+		bar.FinishPrint(fmt.Sprintln("Reindexed", copied, "documents,", c.Failed, "failed"))
+		return exitPartial
+	}
+	bar.FinishPrint(fmt.Sprintln("Reindexed", copied, "documents"))
+
+	if c.VerifyCount {
+		ok, err := c.VerifyCounts(names)
+		if err != nil {
+			Println(err)
+		} else if !ok {
+			return exitMismatch
+		}
+	}
+	return c.Errors.ExitStatus()
+}
+
+// startReindex starts the _reindex of one index in the background
+func (c *Config) startReindex(name string, local bool) (*ReindexTask, error) {
+
+	source := map[string]interface{}{"index": name, "size": c.DocBufferCount}
+	for key, val := range c.ScrollQuery() {
+		source[key] = val
+	}
+	if c.Types != "" {
+		source["type"] = strings.Split(c.Types, ",")
+	}
+	if !local {
+		source["remote"] = c.reindexRemote()
+	}
+
+	// the conflict policies match those of the bulk requests
+	dest := map[string]interface{}{"index": c.DestIndex(name), "op_type": "create"}
+	if c.OnConflict == "overwrite" {
+		delete(dest, "op_type")
+	}
+	if c.Pipeline != "" {
+		dest["pipeline"] = c.Pipeline
+	}
+	body := map[string]interface{}{"source": source, "dest": dest, "conflicts": "proceed"}
+	if c.OnConflict == "abort" {
+		body["conflicts"] = "abort"
+	}
+
+	path := "/_reindex?wait_for_completion=false"
+	if local && c.Slices > 1 {
+		path += fmt.Sprintf("&slices=%d", c.Slices)
+	}
+	if c.RateLimitDocs > 0 {
+		path += fmt.Sprintf("&requests_per_second=%g", c.RateLimitDocs/float64(c.Workers))
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Dst.Request("POST", path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		err := fmt.Errorf("failed starting the reindex of %s: %s %s", name, resp.Status, string(b))
+		if strings.Contains(string(b), "whitelist") || strings.Contains(string(b), "allowlist") {
+			err = fmt.Errorf("%s. add the source to reindex.remote.whitelist in elasticsearch.yml of the destination", err)
+		}
+		return nil, err
+	}
+	task := struct {
+		Task string `json:"task"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&task); err != nil {
+		return nil, err
+	}
+	return &ReindexTask{Index: name, Id: task.Task}, nil
+}
+
+// reindexRemote is the source as the destination reaches it, with its
+// credentials and headers
+func (c *Config) reindexRemote() map[string]interface{} {
+
+	// es wants the port spelled out
+	host := c.Src.Url
+	if u, err := url.Parse(host); err == nil && u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		u.Host += ":" + port
+		host = u.String()
+	}
+
+	remote := map[string]interface{}{
+		"host":           host,
+		"socket_timeout": fmt.Sprintf("%ds", c.ScrollTimeout),
+	}
+	if c.ConnectTimeout > 0 {
+		remote["connect_timeout"] = fmt.Sprintf("%ds", c.ConnectTimeout)
+	}
+	if c.Src.Auth != "" {
+		user, pass := c.Src.Auth, ""
+		if i := strings.Index(c.Src.Auth, ":"); i >= 0 {
+			user, pass = c.Src.Auth[:i], c.Src.Auth[i+1:]
+		}
+		remote["username"], remote["password"] = user, pass
+	}
+
+	headers := map[string]string{}
+	for name, vals := range c.Src.Headers {
+		headers[name] = strings.Join(vals, ",")
+	}
+	if c.Src.ApiKey != "" {
+		headers["Authorization"] = "ApiKey " + c.Src.ApiKey
+	} else if c.Src.Token != "" {
+		headers["Authorization"] = "Bearer " + c.Src.Token
+	}
+	if len(headers) > 0 {
+		remote["headers"] = headers
+	}
+	return remote
+}
+
+// followReindex polls the task until it is done, cancelling it once asked
+// to stop. progress gets the documents done and the ones newly known of
+// since the last poll. It returns the documents reindexed
+func (c *Config) followReindex(t *ReindexTask, progress func(done, more int)) int {
+
+	cancelled := false
+	for {
+		result, err := c.reindexTask(t.Id)
+		if err != nil {
+			c.Errors.Add(destErrors, err)
+			c.Partial = true
+			return t.done
+		}
+
+		status := result.Task.Status
+		if result.Completed {
+			status = result.Response.reindexStatus
+		}
+		done := status.Created + status.Updated + status.Deleted + status.Conflicts
+		if done > t.done || status.Total > t.total {
+			progress(done-t.done, status.Total-t.total)
+			c.Progressed()
+			t.done, t.total = done, status.Total
+		}
+
+		if result.Completed {
+			return c.reindexDone(t, result)
+		}
+		if c.Stopped() && !cancelled {
+			if err := c.cancelTask(t.Id); err != nil {
+				c.Errors.Add(notices, err)
+			}
+			cancelled = true
+		}
+		time.Sleep(reindexPoll)
+	}
+}
+
+// reindexDone counts the failures and conflicts of a finished task
+func (c *Config) reindexDone(t *ReindexTask, result *taskResult) int {
+
+	if result.Error != nil {
+		b, _ := json.Marshal(result.Error)
+		if !strings.Contains(string(b), "task_cancelled_exception") {
+			c.Errors.Add(destErrors, fmt.Errorf("reindex of %s failed: %s", t.Index, b))
+			c.Partial = true
+		}
+	}
+	for _, failure := range result.Response.Failures {
+		reason := failure.Cause
+		if reason == nil {
+			reason = failure.Reason
+		}
+		b, _ := json.Marshal(reason)
+		c.Errors.AddKind(docErrors, "reindex", fmt.Errorf("failed reindexing %s/%s: %s", t.Index, failure.Id, b))
+	}
+
+	if c.OnConflict == "abort" && len(result.Response.Failures) > 0 {
+		c.Abort(fmt.Errorf("stopping, documents of %s exist on the destination", t.Index))
+	}
+
+	status := result.Response.reindexStatus
+	c.FlushLock.Lock()
+	c.Failed += len(result.Response.Failures)
+	if c.OnConflict == "skip" {
+		c.Existing += status.Conflicts
+	} else {
+		c.Failed += status.Conflicts
+	}
+	c.FlushLock.Unlock()
+	return status.Created + status.Updated
+}
+
+func (c *Config) reindexTask(id string) (*taskResult, error) {
+
+	resp, err := c.Dst.Request("GET", "/_tasks/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed getting task %s: %s %s", id, resp.Status, string(b))
+	}
+	result := &taskResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *Config) cancelTask(id string) error {
+
+	resp, err := c.Dst.Request("POST", "/_tasks/"+id+"/_cancel", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("failed cancelling task %s: %s", id, resp.Status)
+	}
+	return nil
+}
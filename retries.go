@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RetryStats counts the documents a busy destination made the copy send
+// again, so a copy that got there in the end can still be told apart from
+// a healthy one
+type RetryStats struct {
+	sync.Mutex
+	Budget   int         // retries allowed for the whole copy, 0 for no limit
+	Docs     int         // documents sent more than once
+	Retries  int         // times documents were sent again
+	GaveUp   int         // documents still rejected after the last retry
+	Attempts map[int]int // documents done with after that many attempts
+	Indexes  map[string]*IndexRetries
+}
+
+type IndexRetries struct {
+	Docs    int
+	Retries int
+}
+
+func NewRetryStats(budget int) *RetryStats {
+	return &RetryStats{
+		Budget:   budget,
+		Attempts: map[int]int{},
+		Indexes:  map[string]*IndexRetries{},
+	}
+}
+
+// Rejected counts the documents of body es rejected on attempt as retried,
+// unless that would go over the budget
+func (r *RetryStats) Rejected(body []byte, attempt int) bool {
+
+	r.Lock()
+	defer r.Unlock()
+
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	if r.Budget > 0 && r.Retries+len(lines)/2 > r.Budget {
+		return false
+	}
+	for i := 0; i+1 < len(lines); i += 2 {
+		idx := r.Indexes[actionIndex(lines[i])]
+		if idx == nil {
+			idx = &IndexRetries{}
+			r.Indexes[actionIndex(lines[i])] = idx
+		}
+		if attempt == 1 {
+			r.Docs++
+			idx.Docs++
+		}
+		r.Retries++
+		idx.Retries++
+	}
+	return true
+}
+
+// Indexed counts docs done with on attempt, after being rejected before
+func (r *RetryStats) Indexed(attempt, docs int) {
+
+	if attempt == 1 || docs == 0 {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.Attempts[attempt] += docs
+}
+
+func (r *RetryStats) GiveUp(docs int) {
+
+	r.Lock()
+	defer r.Unlock()
+	r.GaveUp += docs
+}
+
+// Print writes the summary, and the retries of every index with byIndex
+func (r *RetryStats) Print(byIndex bool) {
+
+	if r.Docs == 0 {
+		return
+	}
+
+	attempts := []int{}
+	for n := range r.Attempts {
+		attempts = append(attempts, n)
+	}
+	sort.Ints(attempts)
+	parts := []string{}
+	for _, n := range attempts {
+		parts = append(parts, fmt.Sprintf("%d took %d attempts", r.Attempts[n], n))
+	}
+	if r.GaveUp > 0 {
+		parts = append(parts, fmt.Sprintf("%d were given up on", r.GaveUp))
+	}
+	Printf("%d documents were sent again %d times: %s\n", r.Docs, r.Retries, strings.Join(parts, ", "))
+
+	if !byIndex {
+		return
+	}
+	names := []string{}
+	for name := range r.Indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		Printf("  %s: %d documents sent again %d times\n", name, r.Indexes[name].Docs, r.Indexes[name].Retries)
+	}
+}
+
+// actionIndex is the _index of a bulk action line
+func actionIndex(action []byte) string {
+
+	var a map[string]struct {
+		Index string `json:"_index"`
+	}
+	json.Unmarshal(action, &a)
+	for _, meta := range a {
+		return meta.Index
+	}
+	return ""
+}
+
+// bulkDocs is the number of documents in a bulk body
+func bulkDocs(body []byte) int {
+	return bytes.Count(body, []byte("\n")) / 2
+}
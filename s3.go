@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// size of each part in a multipart upload, s3 wants at least 5mb
+const s3PartSize = 16 << 20
+
+// S3Object is an object in a bucket, along with how to reach it
+type S3Object struct {
+	Endpoint string // scheme://host[/bucket] the key is relative to
+	Key      string
+	Signer   *Signer
+	Client   *http.Client
+}
+
+// NewS3Object parses s3://bucket/key. The region comes from --aws-region or
+// AWS_REGION, and AWS_ENDPOINT_URL switches to path style requests against
+// s3 compatible stores like minio
+func (c *Config) NewS3Object(location string) (*S3Object, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("bad s3 location %s, should be s3://bucket/key", location)
+	}
+
+	region := c.AwsRegion
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	signer, err := NewSigner(region, c.AwsProfile)
+	if err != nil {
+		return nil, err
+	}
+	signer.Service = "s3"
+
+	endpoint := fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+	if custom := os.Getenv("AWS_ENDPOINT_URL"); custom != "" {
+		endpoint = strings.TrimRight(custom, "/") + "/" + bucket
+	}
+
+	return &S3Object{
+		Endpoint: endpoint,
+		Key:      key,
+		Signer:   signer,
+		Client:   c.Client,
+	}, nil
+}
+
+// Request makes a signed request against the object, query is appended to
+// the object url as is
+func (o *S3Object) Request(method, query string, body []byte) (*http.Response, error) {
+
+	u := o.Endpoint + "/" + awsEscape(o.Key)
+	if query != "" {
+		u += "?" + query
+	}
+
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	o.Signer.Sign(req, body, time.Now())
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s failed: %s %s", method, o.Key, resp.Status, string(b))
+	}
+
+	return resp, nil
+}
+
+// NewReader streams the object
+func (o *S3Object) NewReader() (io.ReadCloser, error) {
+
+	resp, err := o.Request("GET", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// NewWriter uploads everything written to it as the object. Small objects
+// are a single put, anything over a part goes up as a multipart upload
+func (o *S3Object) NewWriter() io.WriteCloser {
+	return &S3Writer{obj: o}
+}
+
+// S3Writer buffers a part at a time and uploads it
+type S3Writer struct {
+	obj      *S3Object
+	buf      bytes.Buffer
+	uploadId string
+	parts    []s3Part
+}
+
+type s3Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+func (w *S3Writer) Write(p []byte) (int, error) {
+
+	n, _ := w.buf.Write(p)
+	for w.buf.Len() >= s3PartSize {
+		if err := w.uploadPart(w.buf.Next(s3PartSize)); err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (w *S3Writer) uploadPart(part []byte) error {
+
+	if w.uploadId == "" {
+		resp, err := w.obj.Request("POST", "uploads=", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		result := struct {
+			UploadId string `xml:"UploadId"`
+		}{}
+		if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return fmt.Errorf("failed starting multipart upload: %s", err)
+		}
+		w.uploadId = result.UploadId
+	}
+
+	number := len(w.parts) + 1
+	resp, err := w.obj.Request("PUT", fmt.Sprintf("partNumber=%d&uploadId=%s", number, url.QueryEscape(w.uploadId)), part)
+	if err != nil {
+		w.abort()
+		return err
+	}
+	resp.Body.Close()
+
+	w.parts = append(w.parts, s3Part{Number: number, ETag: resp.Header.Get("ETag")})
+
+	return nil
+}
+
+func (w *S3Writer) abort() {
+
+	if resp, err := w.obj.Request("DELETE", "uploadId="+url.QueryEscape(w.uploadId), nil); err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (w *S3Writer) Close() error {
+
+	// never got to a full part, just put it
+	if w.uploadId == "" {
+		resp, err := w.obj.Request("PUT", "", w.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := w.obj.Request("POST", "uploadId="+url.QueryEscape(w.uploadId), complete)
+	if err != nil {
+		w.abort()
+		return err
+	}
+	defer resp.Body.Close()
+
+	// s3 can fail a completed upload with a 200 and an error body
+	b, _ := ioutil.ReadAll(resp.Body)
+	if bytes.Contains(b, []byte("<Error>")) {
+		w.abort()
+		return fmt.Errorf("failed completing upload of %s: %s", w.obj.Key, string(b))
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// CheckSameIndexes refuses to copy indexes onto themselves, when source and
+// destination are one cluster and renames leave names as they are. With
+// --force that would delete the source before reading it. Time based and
+// templated indexes aren't known before the copy and aren't checked
+func (c *Config) CheckSameIndexes(srcNames []string) error {
+
+	if c.Src == nil || c.Dst == nil || c.Lazy != nil || !sameCluster(c.Src, c.Dst) {
+		return nil
+	}
+
+	var same []string
+	for _, name := range srcNames {
+		if c.DestIndex(name) == name {
+			same = append(same, name)
+		}
+	}
+	if len(same) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("source and destination are the same cluster, %s would be copied onto itself. use --rename, --dest-prefix or --dest-suffix", strings.Join(same, ", "))
+	if c.AllowSameIndex {
+		Println(err)
+		return nil
+	}
+	return err
+}
+
+// sameCluster compares the cluster uuids of two hosts, or their addresses
+// for versions without one
+func sameCluster(a, b *Host) bool {
+
+	idA, idB := clusterUuid(a), clusterUuid(b)
+	if idA != "" && idB != "" {
+		return idA == idB
+	}
+
+	ua, errA := url.Parse(a.Url)
+	ub, errB := url.Parse(b.Url)
+	if errA != nil || errB != nil {
+		return a.Url == b.Url
+	}
+	return strings.EqualFold(ua.Host, ub.Host) && ua.Path == ub.Path
+}
+
+// clusterUuid is empty when the host doesn't answer or is older than es 5
+func clusterUuid(host *Host) string {
+
+	resp, err := host.Request("GET", "/", nil)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	info := struct {
+		Uuid string `json:"cluster_uuid"`
+	}{}
+	if resp.StatusCode != 200 || json.NewDecoder(resp.Body).Decode(&info) != nil {
+		return ""
+	}
+	return info.Uuid
+}
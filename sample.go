@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// ParseSample reads the share of documents --sample keeps, either as a
+// percentage like 5% or a fraction like 0.05
+func ParseSample(sample string) (float64, error) {
+
+	value, percent := strings.TrimSpace(sample), false
+	if strings.HasSuffix(value, "%") {
+		value, percent = strings.TrimSuffix(value, "%"), true
+	}
+
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad --sample %s, use a percentage like 5%%", sample)
+	}
+	if percent {
+		rate /= 100
+	}
+	if rate <= 0 || rate > 1 {
+		return 0, fmt.Errorf("--sample %s has to be above 0 and at most 100%%", sample)
+	}
+
+	return rate, nil
+}
+
+// Sampled tells if the document is part of the sample. The choice is made by
+// hashing the id rather than at random so running again picks the same
+// documents, and scan scrolls ignore scoring anyway
+func (c *Config) Sampled(doc *Document) bool {
+
+	if c.Sampling == 0 || c.Sampling >= 1 {
+		return true
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(doc.Index + "/" + doc.Id))
+
+	return float64(h.Sum64()%1000000) < c.Sampling*1000000
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/dop251/goja"
+)
+
+// Script runs the transform function of --transform-script on documents.
+// Runtimes are not safe to share, so every worker has its own
+type Script struct {
+	vm        *goja.Runtime
+	transform goja.Callable
+}
+
+// CompileScript loads --transform-script once, workers run the program in
+// runtimes of their own
+func CompileScript(path string) (*goja.Program, error) {
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return goja.Compile(path, string(src), false)
+}
+
+// NewScript runs the compiled script in a new runtime and finds its
+// transform(doc) function
+func (c *Config) NewScript() (*Script, error) {
+
+	vm := goja.New()
+	if _, err := vm.RunProgram(c.Program); err != nil {
+		return nil, err
+	}
+
+	transform, ok := goja.AssertFunction(vm.Get("transform"))
+	if !ok {
+		return nil, fmt.Errorf("%s has no transform(doc) function", c.TransformScript)
+	}
+
+	return &Script{vm, transform}, nil
+}
+
+// Transform passes doc as {_index, _type, _id, _source} to the script. It can
+// change doc in place or return a new one, null or false drops it
+func (s *Script) Transform(doc *Document) (bool, error) {
+
+	arg := s.vm.ToValue(map[string]interface{}{
+		"_index":   doc.Index,
+		"_type":    doc.Type,
+		"_id":      doc.Id,
+		"_source":  doc.source,
+		"_routing": doc.Routing,
+	})
+
+	result, err := s.transform(goja.Undefined(), arg)
+	if err != nil {
+		return false, fmt.Errorf("transform of %s/%s failed: %s", doc.Index, doc.Id, err)
+	}
+
+	switch {
+	case goja.IsUndefined(result):
+		result = arg
+	case goja.IsNull(result), result.Export() == false:
+		return false, nil
+	}
+
+	out, ok := result.Export().(map[string]interface{})
+	if !ok {
+		return false, fmt.Errorf("transform of %s/%s returned %s, not a document", doc.Index, doc.Id, result)
+	}
+	source, ok := out["_source"].(map[string]interface{})
+	if !ok {
+		return false, fmt.Errorf("transform of %s/%s left no _source", doc.Index, doc.Id)
+	}
+
+	// fields the script removed keep their value
+	for field, value := range map[string]*string{"_index": &doc.Index, "_type": &doc.Type, "_id": &doc.Id, "_routing": &doc.Routing} {
+		if v, ok := out[field]; ok && v != nil {
+			*value = fmt.Sprint(v)
+		}
+	}
+	doc.source = source
+
+	return true, nil
+}
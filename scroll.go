@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// times the scroll of an index is started over when es lost its context
+const scrollRestarts = 3
+
+// canRestart tells if reading can start over when es lost the scroll or
+// point in time. Documents read again have to come back as conflicts to be
+// skipped, so they need ids of their own and to go to es untouched by
+// --transform-cmd, and --max-docs would count them twice
+func (c *Config) canRestart() bool {
+	return c.Dst != nil && c.Out == nil && c.TransformCmd == "" && c.IdStrategy != "autogen" &&
+		c.OnConflict != "abort" && c.MaxDocs == 0 && c.MaxIndexDocs == 0
+}
+
+// Clearer is a DocSource holding on to something on the source, like a
+// scroll context, that should be let go when stopping early
+type Clearer interface {
+	Clear(c *Config) error
+}
+
+// Clear lets go of the scroll context rather than leaving it to expire
+func (s *Scroll) Clear(c *Config) error {
+
+	if s.ScrollId == "" {
+		return nil
+	}
+	return clearScroll(c.Src, s.ScrollId)
+}
+
+// clearScroll sends the id in the body, ids can be too long for the path and
+// es 7 deprecated it there. es 1 takes it as is, later versions as json
+func clearScroll(host *Host, id string) error {
+
+	body := id
+	if major, _ := hostVersion(host); major >= 2 {
+		b, _ := json.Marshal(map[string][]string{"scroll_id": {id}})
+		body = string(b)
+	}
+	resp, err := host.Request("DELETE", "/_search/scroll", strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 404 {
+		return fmt.Errorf("failed clearing scroll: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *IndexScrolls) Clear(c *Config) error {
+
+	if s.scroll == nil {
+		return nil
+	}
+	return s.scroll.Clear(c)
+}
+
+// contextMissing tells if an error of es is about a scroll context it
+// doesn't have anymore, the wording changed between versions
+func contextMissing(reason string) bool {
+	return strings.Contains(reason, "search_context_missing") ||
+		strings.Contains(reason, "SearchContextMissing") ||
+		strings.Contains(reason, "No search context found")
+}
+
+// HitsTotal is hits.total of a search, a number before es 7 and an object
+// like {"value": 25, "relation": "eq"} from then on. With relation gte the
+// value is only a lower bound, es stopped counting at track_total_hits
+type HitsTotal struct {
+	Value    int    `json:"value"`
+	Relation string `json:"relation"`
+}
+
+func (t *HitsTotal) UnmarshalJSON(b []byte) error {
+
+	var n int
+	if err := json.Unmarshal(b, &n); err == nil {
+		*t = HitsTotal{Value: n, Relation: "eq"}
+		return nil
+	}
+	type total HitsTotal
+	return json.Unmarshal(b, (*total)(t))
+}
+
+// exactTotal is the number of documents a search of indexes matches. When
+// es only gave a lower bound they are counted with the _count api
+func (c *Config) exactTotal(indexes string, total HitsTotal) int {
+
+	if total.Relation != "gte" {
+		return total.Value
+	}
+	path := "/" + indexes + "/_count"
+	if c.Types != "" {
+		path = "/" + indexes + "/" + c.Types + "/_count"
+	}
+	if c.Routing != "" {
+		path += "?routing=" + url.QueryEscape(c.Routing)
+	}
+	n, err := count(c.Src, path, c.countQuery())
+	if err != nil {
+		c.Errors.Add(notices, fmt.Errorf("progress is off, %s", err))
+		return total.Value
+	}
+	return n
+}
+
+// hitsFilter leaves out of pages of hits what isn't read, like _score, so
+// es sends and we parse less. error is kept for the reason of a failure
+const hitsFilter = "filter_path=_scroll_id,pit_id,timed_out,error,status,_shards.failures," +
+	"hits.total,hits.hits._index,hits.hits._type,hits.hits._id,hits.hits._routing,hits.hits._parent,hits.hits.fields,hits.hits._source,hits.hits.sort"
+
+// scrollPath starts scrolling indexes. search_type=scan is deprecated from
+// es 2.1 and gone in 5, later versions scroll sorted by _doc instead, which
+// is as cheap and has the first page in the response that starts it
+func (c *Config) scrollPath(indexes, types string, scan bool) string {
+
+	if types != "" {
+		indexes += "/" + types
+	}
+	path := fmt.Sprintf("/%s/_search?scroll=%s&size=%d&%s", indexes, c.ScrollTime, c.DocBufferCount, hitsFilter)
+	if scan {
+		path += "&search_type=scan"
+	}
+	return path
+}
+
+// scrollNextPath asks for the next page of a scroll
+func (c *Config) scrollNextPath() string {
+	return fmt.Sprintf("/_search/scroll?scroll=%s&%s", c.ScrollTime, hitsFilter)
+}
+
+// scrollBody asks for the next page of scroll id. Versions from 2.1 take it
+// as json, older ones as is
+func (c *Config) scrollBody(id string, scan bool) *strings.Reader {
+
+	if scan {
+		return strings.NewReader(id)
+	}
+	b, _ := json.Marshal(map[string]string{"scroll": c.ScrollTime, "scroll_id": id})
+	return strings.NewReader(string(b))
+}
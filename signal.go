@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// TrapSignals stops reading on the first SIGINT or SIGTERM, the documents
+// read so far are still indexed. A second signal quits right away
+func (c *Config) TrapSignals() {
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		Printf("%s, finishing the documents read so far. again to quit now\n", sig)
+		atomic.CompareAndSwapInt32(&c.stopped, 0, stopSignal)
+		<-signals
+		os.Exit(exitStopped)
+	}()
+}
+
+// why reading stopped early
+const (
+	stopSignal = 1
+	stopAbort  = 2
+)
+
+// Stopped tells if a signal, or --on-conflict abort, asked to stop reading
+func (c *Config) Stopped() bool {
+	return atomic.LoadInt32(&c.stopped) != 0
+}
+
+// Abort stops reading because of err, the documents read so far are still
+// indexed
+func (c *Config) Abort(err error) {
+
+	if atomic.CompareAndSwapInt32(&c.stopped, 0, stopAbort) {
+		c.Errors.Add(notices, err)
+	}
+}
+
+// stopStatus is the exit status after stopping early
+func (c *Config) stopStatus() int {
+
+	if atomic.LoadInt32(&c.stopped) == stopAbort {
+		return exitPartial
+	}
+	return exitStopped
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vectors from the aws sigv4 test suite
+func TestSignTestSuite(t *testing.T) {
+
+	signer := &Signer{
+		Region:    "us-east-1",
+		Service:   "service",
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		method    string
+		url       string
+		signature string
+	}{
+		{"get-vanilla", "GET", "/", "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"get-vanilla-empty-query-key", "GET", "/?Param1=value1", "a67d582fa61cc504c4bae71f336f98b97f1ea3c7a6bfe1b6e45aec72011b9aeb"},
+		{"get-vanilla-query-order-key-case", "GET", "/?Param2=value2&Param1=value1", "b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500"},
+		{"get-unreserved", "GET", "/-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz", "07ef7494c76fa4850883e2b006601f940f8a34d404d0cfa977f52a65bbf5f24f"},
+		{"post-vanilla", "POST", "/", "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+		{"post-vanilla-query", "POST", "/?Param1=value1", "28038455d6de14eafc1f9222cf5aa6f1a96197d7deb8263271d420d138af7f11"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, "http://example.amazonaws.com"+tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer.Sign(req, nil, now)
+
+		want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+			"SignedHeaders=host;x-amz-date, Signature=" + tt.signature
+		if got := req.Header.Get("Authorization"); got != want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, want)
+		}
+	}
+}
+
+func TestSignS3PayloadHash(t *testing.T) {
+
+	signer := &Signer{Region: "us-east-1", Service: "s3", AccessKey: "a", SecretKey: "b"}
+	req, _ := http.NewRequest("PUT", "http://bucket.s3.amazonaws.com/key", nil)
+	signer.Sign(req, []byte(""), time.Now())
+
+	// sha256 of the empty body
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("got payload hash %s", got)
+	}
+	if !strings.Contains(req.Header.Get("Authorization"), "SignedHeaders=host;x-amz-content-sha256;x-amz-date,") {
+		t.Errorf("payload hash not signed: %s", req.Header.Get("Authorization"))
+	}
+}
+
+func TestAwsEscape(t *testing.T) {
+
+	tests := []struct {
+		in, out string
+	}{
+		{"/", "/"},
+		{"/idx/_doc/1", "/idx/_doc/1"},
+		{"/idx/_doc/a%20b", "/idx/_doc/a%2520b"},
+		{"/a+b", "/a%2Bb"},
+		{"/-._~", "/-._~"},
+	}
+	for _, tt := range tests {
+		if got := awsEscape(tt.in); got != tt.out {
+			t.Errorf("awsEscape(%q) = %q, want %q", tt.in, got, tt.out)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import "sync"
+
+// SlicedScroll reads the source with several sliced scrolls at once, es 5
+// and later, so reading scales with the shards of the source rather than
+// waiting on one scroll. Every call to Next gets a page of each slice in
+// parallel
+type SlicedScroll struct {
+	Slices []*Scroll
+	Total  int
+	done   []bool
+}
+
+// NewSlicedScroll starts max slices of a scroll of indexes
+func (c *Config) NewSlicedScroll(indexes string, max int) (*SlicedScroll, error) {
+
+	s := &SlicedScroll{done: make([]bool, max)}
+	gte := false
+	for id := 0; id < max; id++ {
+		scroll, err := c.newScroll(indexes, map[string]int{"id": id, "max": max})
+		if err != nil {
+			s.Clear(c)
+			return nil, err
+		}
+		s.Slices = append(s.Slices, scroll)
+		s.Total += scroll.Hits.Total.Value
+		gte = gte || scroll.Hits.Total.Relation == "gte"
+	}
+	if gte {
+		s.Total = c.exactTotal(indexes, HitsTotal{Value: s.Total, Relation: "gte"})
+	}
+	return s, nil
+}
+
+func (s *SlicedScroll) Next(c *Config) (done bool) {
+
+	wg := sync.WaitGroup{}
+	for i, scroll := range s.Slices {
+		if s.done[i] {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, scroll *Scroll) {
+			defer wg.Done()
+			s.done[i] = scroll.Next(c)
+		}(i, scroll)
+	}
+	wg.Wait()
+
+	for _, d := range s.done {
+		if !d {
+			return false
+		}
+	}
+	return true
+}
+
+// Partial tells if any slice stopped on an error rather than at its end
+func (s *SlicedScroll) Partial() bool {
+
+	for _, scroll := range s.Slices {
+		if scroll.Partial {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SlicedScroll) Clear(c *Config) (err error) {
+
+	for _, scroll := range s.Slices {
+		if e := scroll.Clear(c); e != nil {
+			err = e
+		}
+	}
+	return err
+}
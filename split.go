@@ -0,0 +1,355 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Manifest lists the parts of a split dump
+type Manifest struct {
+	Format string         `json:"format"`
+	Parts  []ManifestPart `json:"parts"`
+}
+
+type ManifestPart struct {
+	Index  string `json:"index"`
+	File   string `json:"file"` // relative to the manifest
+	Docs   int    `json:"docs"`
+	Bytes  int64  `json:"bytes"`
+	Sha256 string `json:"sha256"`
+}
+
+// SplitWriter writes each index to its own series of parts, starting a new
+// part once the current one reaches --split-size or --split-docs, and writes a
+// manifest of all the parts on close
+type SplitWriter struct {
+	c        *Config
+	prefix   string // location without extensions, parts are prefix.index.n.ext
+	ext      string
+	format   string
+	current  map[string]*splitPart
+	manifest Manifest
+}
+
+type splitPart struct {
+	DocWriter
+	ManifestPart
+	counter *checksumWriter
+}
+
+// NewSplitWriter writes parts next to location. For a location of
+// /backups/dump.json.gz the parts are /backups/dump.<index>.00001.json.gz
+// and the manifest is /backups/dump.manifest.json
+func (c *Config) NewSplitWriter(location, format string) (*SplitWriter, error) {
+
+	prefix, ext := splitExt(location)
+	if location == "-" {
+		return nil, fmt.Errorf("can't split a dump written to stdout")
+	}
+
+	return &SplitWriter{
+		c:        c,
+		prefix:   prefix,
+		ext:      ext,
+		format:   format,
+		current:  map[string]*splitPart{},
+		manifest: Manifest{Format: format},
+	}, nil
+}
+
+func (s *SplitWriter) WriteDoc(doc *Document) error {
+
+	part := s.current[doc.Index]
+	if part != nil && s.full(part) {
+		if err := s.closePart(part); err != nil {
+			return err
+		}
+		part = nil
+	}
+
+	if part == nil {
+		var err error
+		if part, err = s.newPart(doc.Index); err != nil {
+			return err
+		}
+	}
+
+	if err := part.WriteDoc(doc); err != nil {
+		return err
+	}
+	part.Docs++
+
+	return nil
+}
+
+func (s *SplitWriter) full(part *splitPart) bool {
+
+	if s.c.SplitDocs > 0 && part.Docs >= s.c.SplitDocs {
+		return true
+	}
+	if s.c.SplitSize > 0 && part.counter.n >= int64(s.c.SplitSize)<<20 {
+		return true
+	}
+	return false
+}
+
+func (s *SplitWriter) newPart(index string) (*splitPart, error) {
+
+	number := 1
+	for _, part := range s.manifest.Parts {
+		if part.Index == index {
+			number++
+		}
+	}
+	location := fmt.Sprintf("%s.%s.%05d%s", s.prefix, index, number, s.ext)
+
+	raw, err := s.c.OpenRawOutput(location)
+	if err != nil {
+		return nil, err
+	}
+
+	// checksum the part as compressed, before any encryption, the same way
+	// OpenRawInput reads it back
+	counter := &checksumWriter{w: raw, h: sha256.New()}
+	w, err := s.c.NewFormatWriter(Compress(counter, location), s.format)
+	if err != nil {
+		return nil, err
+	}
+
+	part := &splitPart{
+		DocWriter:    w,
+		ManifestPart: ManifestPart{Index: index, File: baseName(location)},
+		counter:      counter,
+	}
+	s.current[index] = part
+
+	return part, nil
+}
+
+func (s *SplitWriter) closePart(part *splitPart) error {
+
+	if err := part.DocWriter.Close(); err != nil {
+		return err
+	}
+	part.Bytes = part.counter.n
+	part.Sha256 = hex.EncodeToString(part.counter.h.Sum(nil))
+	s.manifest.Parts = append(s.manifest.Parts, part.ManifestPart)
+	delete(s.current, part.Index)
+
+	return nil
+}
+
+// Close finishes all open parts and writes the manifest
+func (s *SplitWriter) Close() error {
+
+	for _, part := range s.current {
+		if err := s.closePart(part); err != nil {
+			return err
+		}
+	}
+
+	w, err := s.c.OpenRawOutput(s.prefix + ".manifest.json")
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s.manifest, "", "  ")
+	if err != nil {
+		w.Close()
+		return err
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// checksumWriter counts and hashes everything written through it
+type checksumWriter struct {
+	w io.WriteCloser
+	h hash.Hash
+	n int64
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *checksumWriter) Close() error {
+	return c.w.Close()
+}
+
+// ManifestReader restores every part listed in a manifest, reading up to
+// --workers parts at the same time
+type ManifestReader struct {
+	location string
+	manifest Manifest
+}
+
+// NewManifestReader reads the manifest at location
+func (c *Config) NewManifestReader(location string) (*ManifestReader, error) {
+
+	r, err := c.OpenInput(location)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	m := &ManifestReader{location: location}
+	if err := json.NewDecoder(r).Decode(&m.manifest); err != nil {
+		return nil, fmt.Errorf("failed reading manifest %s: %s", location, err)
+	}
+
+	return m, nil
+}
+
+// Next reads all the parts, done is always set since the parts are read in
+// one go
+func (m *ManifestReader) Next(c *Config) (done bool) {
+
+	parts := make(chan ManifestPart)
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < c.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				reader, err := c.NewDocReader(partLocation(m.location, part.File))
+				if err != nil {
+					c.Errors.Add(sourceErrors, err)
+					continue
+				}
+				for !c.Limit.Reached() && !c.Stopped() && reader.Next(c) == false {
+				}
+				reader.Close()
+			}
+		}()
+	}
+
+	for _, part := range m.manifest.Parts {
+		if c.Stopped() {
+			break
+		}
+		parts <- part
+	}
+	close(parts)
+	wg.Wait()
+
+	return true
+}
+
+// Verify checks the size and checksum of every part in the manifest
+func (m *ManifestReader) Verify(c *Config) error {
+
+	for _, part := range m.manifest.Parts {
+		location := partLocation(m.location, part.File)
+
+		r, err := c.OpenRawInput(location)
+		if err != nil {
+			return fmt.Errorf("failed verifying %s: %s", location, err)
+		}
+		h := sha256.New()
+		n, err := io.Copy(h, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("failed verifying %s: %s", location, err)
+		}
+
+		if err := part.check(location, n, hex.EncodeToString(h.Sum(nil))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// check compares the size and checksum of a part with what the manifest says,
+// manifests without checksums only have their sizes checked
+func (part ManifestPart) check(location string, n int64, sum string) error {
+
+	if n != part.Bytes {
+		return fmt.Errorf("%s is %d bytes but should be %d, the dump is truncated or corrupted", location, n, part.Bytes)
+	}
+	if part.Sha256 != "" && sum != part.Sha256 {
+		return fmt.Errorf("%s has checksum %s but should have %s, the dump is corrupted", location, sum, part.Sha256)
+	}
+	return nil
+}
+
+// VerifyDump checks the parts of a split dump or an archive against their
+// manifest. Single files have no checksums and stdin can't be read twice, so
+// both are left alone
+func (c *Config) VerifyDump(location string) error {
+
+	if location == "-" {
+		return nil
+	}
+
+	switch {
+	case IsArchive(location):
+		return c.VerifyArchive(location)
+	case strings.HasSuffix(trimQuery(location), ".manifest.json"):
+		m, err := c.NewManifestReader(location)
+		if err != nil {
+			return err
+		}
+		return m.Verify(c)
+	}
+
+	return nil
+}
+
+// NewDumpSource opens a dump for restoring, either a single file or all the
+// parts in a manifest
+func (c *Config) NewDumpSource(location string) (DocSource, error) {
+
+	if strings.HasSuffix(trimQuery(location), ".manifest.json") {
+		return c.NewManifestReader(location)
+	}
+	return c.NewDocReader(location)
+}
+
+// splitExt separates the name of a dump from its extensions, ie
+// dump.json.gz becomes dump and .json.gz
+func splitExt(location string) (string, string) {
+
+	base := baseName(location)
+	if i := strings.Index(base, "."); i > 0 {
+		return location[:len(location)-len(base)+i], base[i:]
+	}
+	return location, ".json"
+}
+
+// baseName is the last element of a path or url
+func baseName(location string) string {
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+// partLocation is where a part of a manifest is, next to it. Urls resolve
+// the part against their path, the query of a presigned manifest url
+// doesn't go with it
+func partLocation(manifest, file string) string {
+
+	u, err := url.Parse(manifest)
+	if err != nil || u.Scheme == "" || u.Scheme == "file" {
+		return dirName(manifest) + file
+	}
+	return u.ResolveReference(&url.URL{Path: file}).String()
+}
+
+// dirName is everything up to and including the last /
+func dirName(location string) string {
+	return location[:strings.LastIndex(location, "/")+1]
+}
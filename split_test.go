@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPartLocation(t *testing.T) {
+
+	tests := []struct {
+		manifest, file, want string
+	}{
+		{"dump.manifest.json", "dump.idx.00001.json", "dump.idx.00001.json"},
+		{"/backups/dump.manifest.json", "dump.idx.00001.json", "/backups/dump.idx.00001.json"},
+		{"backups/daily/dump.manifest.json", "dump.idx.00001.json.gz", "backups/daily/dump.idx.00001.json.gz"},
+		{"file:///backups/dump.manifest.json", "dump.idx.00001.json", "file:///backups/dump.idx.00001.json"},
+		{"s3://bucket/dir/dump.manifest.json", "dump.idx.00001.json", "s3://bucket/dir/dump.idx.00001.json"},
+		{"https://host/dir/dump.manifest.json?X-Amz-Signature=abc/def", "dump.idx.00001.json", "https://host/dir/dump.idx.00001.json"},
+		{"http://host:8080/dump.manifest.json", "dump.idx.00002.json", "http://host:8080/dump.idx.00002.json"},
+	}
+	for _, tt := range tests {
+		if got := partLocation(tt.manifest, tt.file); got != tt.want {
+			t.Errorf("partLocation(%s, %s) = %s, want %s", tt.manifest, tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestSplitExt(t *testing.T) {
+
+	tests := []struct {
+		location, prefix, ext string
+	}{
+		{"dump.json", "dump", ".json"},
+		{"dump.json.gz", "dump", ".json.gz"},
+		{"/backups/v1.2/dump.json.gz", "/backups/v1.2/dump", ".json.gz"},
+		{"/backups/dump", "/backups/dump", ".json"},
+		{"s3://bucket/dump.parquet", "s3://bucket/dump", ".parquet"},
+		{".hidden", ".hidden", ".json"},
+	}
+	for _, tt := range tests {
+		prefix, ext := splitExt(tt.location)
+		if prefix != tt.prefix || ext != tt.ext {
+			t.Errorf("splitExt(%s) = %s, %s, want %s, %s", tt.location, prefix, ext, tt.prefix, tt.ext)
+		}
+	}
+}
+
+func TestSplitRoundTrip(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		file      string
+		format    string
+		splitDocs int
+		docs      map[string]int // documents per index
+		parts     map[string]int // parts per index
+		encrypted bool
+	}{
+		{"one part each", "dump.json", "bulk", 100, map[string]int{"a": 3, "b": 5}, map[string]int{"a": 1, "b": 1}, false},
+		{"full parts", "dump.json", "docs", 2, map[string]int{"a": 4, "b": 5}, map[string]int{"a": 2, "b": 3}, false},
+		{"gzipped", "dump.json.gz", "bulk", 3, map[string]int{"a": 10}, map[string]int{"a": 4}, false},
+		{"encrypted", "dump.json.gz", "elasticdump", 4, map[string]int{"a": 4, "b": 1}, map[string]int{"a": 1, "b": 1}, true},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		c := &Config{SplitDocs: tt.splitDocs, Workers: 2, DocBufferCount: 3, Errors: NewErrorLog(0)}
+		if tt.encrypted {
+			key := filepath.Join(dir, "dump.key")
+			ioutil.WriteFile(key, []byte("0123456789abcdef0123456789abcdef"), 0600)
+			c.EncryptionKey = key
+		}
+
+		w, err := c.NewSplitWriter(filepath.Join(dir, tt.file), tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total := 0
+		for index, n := range tt.docs {
+			for i := 0; i < n; i++ {
+				doc := &Document{Index: index, Id: fmt.Sprintf("%s%d", index, i), source: map[string]interface{}{"n": float64(i)}}
+				if err := w.WriteDoc(doc); err != nil {
+					t.Fatal(err)
+				}
+				total++
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		location := filepath.Join(dir, "dump.manifest.json")
+		m, err := c.NewManifestReader(location)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		parts, docs := map[string]int{}, map[string]int{}
+		for _, part := range m.manifest.Parts {
+			parts[part.Index]++
+			docs[part.Index] += part.Docs
+			if part.Docs > tt.splitDocs {
+				t.Errorf("%s: part %s has %d docs", tt.name, part.File, part.Docs)
+			}
+			if !strings.HasPrefix(part.File, "dump."+part.Index+".0000") || strings.Contains(part.File, "/") {
+				t.Errorf("%s: part is named %s", tt.name, part.File)
+			}
+		}
+		if fmt.Sprint(parts) != fmt.Sprint(tt.parts) || fmt.Sprint(docs) != fmt.Sprint(tt.docs) {
+			t.Errorf("%s: got parts %v with docs %v, want %v with %v", tt.name, parts, docs, tt.parts, tt.docs)
+		}
+		if err := m.Verify(c); err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+		}
+
+		c.DocChan = make(chan map[string]interface{}, total+1)
+		if !m.Next(c) {
+			t.Errorf("%s: Next is not done", tt.name)
+		}
+		close(c.DocChan)
+		ids := []string{}
+		for doc := range c.DocChan {
+			ids = append(ids, doc["_index"].(string)+"/"+doc["_id"].(string))
+		}
+		want := []string{}
+		for index, n := range tt.docs {
+			for i := 0; i < n; i++ {
+				want = append(want, fmt.Sprintf("%s/%s%d", index, index, i))
+			}
+		}
+		sort.Strings(ids)
+		sort.Strings(want)
+		if fmt.Sprint(ids) != fmt.Sprint(want) {
+			t.Errorf("%s: restored %v, want %v", tt.name, ids, want)
+		}
+		if n := c.Errors.Count(sourceErrors); n > 0 {
+			t.Errorf("%s: %d errors restoring", tt.name, n)
+		}
+	}
+}
+
+func TestSplitVerifyDamaged(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		damage func(path string)
+		err    string
+	}{
+		{"truncated", func(path string) {
+			b, _ := ioutil.ReadFile(path)
+			ioutil.WriteFile(path, b[:len(b)-1], 0644)
+		}, "truncated or corrupted"},
+		{"changed", func(path string) {
+			b, _ := ioutil.ReadFile(path)
+			b[0] ^= 1
+			ioutil.WriteFile(path, b, 0644)
+		}, "has checksum"},
+		{"missing", func(path string) {
+			os.Remove(path)
+		}, "failed verifying"},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		c := &Config{SplitDocs: 2}
+		w, _ := c.NewSplitWriter(filepath.Join(dir, "dump.json"), "bulk")
+		for i := 0; i < 5; i++ {
+			w.WriteDoc(&Document{Index: "idx", Id: fmt.Sprint(i), source: map[string]interface{}{}})
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		tt.damage(filepath.Join(dir, "dump.idx.00002.json"))
+		err := c.VerifyDump(filepath.Join(dir, "dump.manifest.json"))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got %v, want %s", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestManifestWithoutChecksums(t *testing.T) {
+
+	// manifests written before checksums only have their sizes checked
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "dump.idx.00001.json"), []byte("{}\n"), 0644)
+	manifest, _ := json.Marshal(Manifest{Format: "bulk", Parts: []ManifestPart{{Index: "idx", File: "dump.idx.00001.json", Docs: 1, Bytes: 3}}})
+	ioutil.WriteFile(filepath.Join(dir, "dump.manifest.json"), manifest, 0644)
+
+	c := &Config{}
+	if err := c.VerifyDump(filepath.Join(dir, "dump.manifest.json")); err != nil {
+		t.Error(err)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "dump.idx.00001.json"), []byte("{}"), 0644)
+	if err := c.VerifyDump(filepath.Join(dir, "dump.manifest.json")); err == nil {
+		t.Error("expected an error for a truncated part")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// functions available to --dest-index-template
+var indexTemplateFuncs = template.FuncMap{
+	// {{date .timestamp "yyyy.MM"}}
+	"date": func(value interface{}, format string) (string, error) {
+		t, err := parseTimestamp(value)
+		if err != nil {
+			return "", err
+		}
+		return formatJavaDate(format, t.UTC())
+	},
+	"lower": strings.ToLower,
+}
+
+// ParseIndexTemplate parses --dest-index-template. Fields the template uses
+// have to be in every document, there is no way to pick a name otherwise
+func ParseIndexTemplate(text string) (*template.Template, error) {
+
+	tmpl, err := template.New("dest-index-template").Funcs(indexTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("bad --dest-index-template: %s", err)
+	}
+	return tmpl, nil
+}
+
+// TemplateIndexFor runs --dest-index-template on the _source of doc, with
+// --dest-prefix and --dest-suffix. Index names are lowercase in es, so the
+// name the template makes is too
+func (c *Config) TemplateIndexFor(doc *Document) (string, error) {
+
+	var name bytes.Buffer
+	if err := c.IndexTmpl.Execute(&name, doc.source); err != nil {
+		return "", fmt.Errorf("no index for %s/%s: %s", doc.Index, doc.Id, err)
+	}
+	if name.Len() == 0 {
+		return "", fmt.Errorf("no index for %s/%s: the template is empty", doc.Index, doc.Id)
+	}
+
+	index := c.DestPrefix + strings.ToLower(name.String()) + c.DestSuffix
+	return index, c.Lazy.Create(c, index, doc.Index)
+}
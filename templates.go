@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// CopyTemplates copies the legacy _template index templates of the source
+// matching --templates. From es 7.8 on the destination gets composable
+// _index_template ones instead, legacy templates are deprecated there.
+// Templates already on the destination are kept unless --force
+func (c *Config) CopyTemplates() error {
+
+	patterns, err := ParseIndexPatterns(c.Templates)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Src.Request("GET", "/_template", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("failed getting templates: %s", string(b))
+	}
+	templates := map[string]map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&templates); err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range templates {
+		matched := false
+		for _, p := range patterns {
+			matched = matched || p.Match(name)
+		}
+		if matched && (name[0] != '.' || c.CopyAllIndexes) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return fmt.Errorf("no templates match %s", c.Templates)
+	}
+
+	major, minor := hostVersion(c.Dst)
+	composable := major > 7 || major == 7 && minor >= 8
+	for _, name := range names {
+		template := c.legacyTemplate(templates[name])
+		api := "_template"
+		if composable {
+			template, api = composableTemplate(template), "_index_template"
+		}
+
+		if !c.Destructive {
+			resp, err := c.Dst.Request("HEAD", "/"+api+"/"+name, nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode == 200 {
+				Println("keeping existing template: ", name)
+				continue
+			}
+		}
+
+		body, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+		resp, err := c.Dst.Request("PUT", "/"+api+"/"+name, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("failed creating template %s: %s", name, string(b))
+		}
+		Println("created template: ", name)
+	}
+	return nil
+}
+
+// legacyTemplate brings a legacy template up to what the destination takes:
+// index_patterns rather than the template of es 5, and its mappings typeless
+// or with renamed types like those of the indexes, and no lifecycle settings
+// with --strip-lifecycle
+func (c *Config) legacyTemplate(template map[string]interface{}) map[string]interface{} {
+
+	if pattern, ok := template["template"].(string); ok {
+		template["index_patterns"] = []interface{}{pattern}
+		delete(template, "template")
+	}
+
+	if c.TypeNames != nil || c.CollapseTypes || c.Typeless {
+		if mappings, ok := template["mappings"].(map[string]interface{}); ok && len(mappings) > 0 {
+			idxs := Indexes{"template": template}
+			idxs.RenameTypes(c.DestType)
+		}
+	}
+	if c.StripIlm {
+		stripLifecycle(template["settings"])
+	}
+	return template
+}
+
+// composableTemplate turns a legacy template into an _index_template, its
+// order becomes the priority
+func composableTemplate(legacy map[string]interface{}) map[string]interface{} {
+
+	inner := map[string]interface{}{}
+	for _, key := range []string{"settings", "mappings", "aliases"} {
+		if val, ok := legacy[key].(map[string]interface{}); ok && len(val) > 0 {
+			inner[key] = val
+		}
+	}
+
+	template := map[string]interface{}{
+		"index_patterns": legacy["index_patterns"],
+		"template":       inner,
+	}
+	if order, ok := legacy["order"]; ok {
+		template["priority"] = order
+	}
+	if version, ok := legacy["version"]; ok {
+		template["version"] = version
+	}
+	return template
+}
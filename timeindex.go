@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timestamps that are not epoch millis
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// TimeIndexFor is the index doc goes to by its --time-field, ie
+// logs-2024.01.02, with --dest-prefix and --dest-suffix
+func (c *Config) TimeIndexFor(doc *Document) (string, error) {
+
+	value, ok := lookupField(doc.source, c.TimeField)
+	if !ok {
+		return "", fmt.Errorf("document %s/%s has no %s", doc.Index, doc.Id, c.TimeField)
+	}
+	t, err := parseTimestamp(value)
+	if err != nil {
+		return "", fmt.Errorf("document %s/%s: %s", doc.Index, doc.Id, err)
+	}
+	date, err := formatJavaDate(c.TimeIndexFormat, t.UTC())
+	if err != nil {
+		return "", err
+	}
+	name := c.DestPrefix + c.TimeIndex + "-" + date + c.DestSuffix
+
+	return name, c.Lazy.Create(c, name, doc.Index)
+}
+
+// lookupField finds a dotted field in source, either nested or with the dots
+// in its name
+func lookupField(source map[string]interface{}, field string) (interface{}, bool) {
+
+	if v, ok := source[field]; ok {
+		return v, true
+	}
+
+	parts := strings.SplitN(field, ".", 2)
+	if len(parts) < 2 {
+		return nil, false
+	}
+	inner, ok := source[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupField(inner, parts[1])
+}
+
+// parseTimestamp reads dates the way es stores them by default, either
+// strict dates or epoch millis
+func parseTimestamp(value interface{}) (time.Time, error) {
+
+	switch v := value.(type) {
+	case float64:
+		return time.Unix(0, int64(v)*int64(time.Millisecond)), nil
+	case json.Number:
+		millis, err := v.Int64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("bad timestamp %s", v)
+		}
+		return time.Unix(0, millis*int64(time.Millisecond)), nil
+	case string:
+		if millis, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Unix(0, millis*int64(time.Millisecond)), nil
+		}
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("bad timestamp %v", value)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DocumentTransformer changes documents on their way to the destination or
+// dump, after the field flags and --transform-script. Transform returns
+// false to drop the document
+type DocumentTransformer interface {
+	Transform(doc *Document) (bool, error)
+}
+
+// TransformerFactory makes a transformer for --transform name:arg. Every
+// worker gets its own, state shared between workers needs a lock
+type TransformerFactory func(c *Config, arg string) (DocumentTransformer, error)
+
+var transformers = map[string]TransformerFactory{}
+
+// RegisterTransformer makes a transformer available to --transform. Call it
+// from the init function of a file built with the tool, ie
+//
+//	func init() {
+//		RegisterTransformer("geoip", NewGeoipTransformer)
+//	}
+func RegisterTransformer(name string, factory TransformerFactory) {
+
+	if _, ok := transformers[name]; ok {
+		panic("transformer " + name + " registered twice")
+	}
+	transformers[name] = factory
+}
+
+// NewTransformChain builds the transformers of a worker in the order they
+// run, --transform-script first then every --transform
+func (c *Config) NewTransformChain() ([]DocumentTransformer, error) {
+
+	chain := []DocumentTransformer{}
+	if c.Program != nil {
+		script, err := c.NewScript()
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, script)
+	}
+
+	for _, spec := range c.Transforms {
+		parts := strings.SplitN(spec, ":", 2)
+		factory, ok := transformers[parts[0]]
+		if !ok && len(transformers) == 0 {
+			return nil, fmt.Errorf("unknown transformer %s, none are built in", parts[0])
+		}
+		if !ok {
+			names := []string{}
+			for name := range transformers {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown transformer %s, built in are: %s", parts[0], strings.Join(names, ", "))
+		}
+
+		arg := ""
+		if len(parts) > 1 {
+			arg = parts[1]
+		}
+		t, err := factory(c, arg)
+		if err != nil {
+			return nil, fmt.Errorf("transformer %s: %s", parts[0], err)
+		}
+		chain = append(chain, t)
+	}
+
+	return chain, nil
+}
+
+// runTransformChain passes doc through chain, false when it is dropped.
+// Documents a transformer fails on are dropped as well
+func (c *Config) runTransformChain(chain []DocumentTransformer, doc *Document) bool {
+
+	for _, t := range chain {
+		keep, err := t.Transform(doc)
+		if err != nil {
+			c.Errors.Add(docErrors, err)
+			return false
+		}
+		if !keep {
+			return false
+		}
+	}
+	return true
+}
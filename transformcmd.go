@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// StartTransformCmd pipes the documents of in through --transform-cmd, one
+// json document a line on its stdin. Documents it writes to stdout in the
+// same format go to the returned channel, so it can change, drop or add
+// documents. Pipes block when either side falls behind
+func (c *Config) StartTransformCmd(in <-chan map[string]interface{}) (<-chan map[string]interface{}, error) {
+
+	cmd := exec.Command("sh", "-c", c.TransformCmd)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed starting --transform-cmd: %s", err)
+	}
+
+	out := make(chan map[string]interface{}, cap(in))
+	fed := make(chan bool)
+
+	// once the command stops reading the rest is drained, the source would
+	// block otherwise
+	go func() {
+		w := bufio.NewWriter(stdin)
+		enc := json.NewEncoder(w)
+		var failed error
+		for doc := range in {
+			if failed != nil {
+				continue
+			}
+			failed = enc.Encode(doc)
+			// dont hold documents back while the source is waiting on es
+			if failed == nil && len(in) == 0 {
+				failed = w.Flush()
+			}
+			if failed != nil {
+				c.Errors.Add(docErrors, fmt.Errorf("failed writing to --transform-cmd: %s", failed))
+			}
+		}
+		if failed == nil {
+			w.Flush()
+		}
+		stdin.Close()
+		close(fed)
+	}()
+
+	go func() {
+		r := bufio.NewReaderSize(stdout, 1<<20)
+		for {
+			line, err := r.ReadBytes('\n')
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				if doc, err := transformedDoc(line); err != nil {
+					c.Errors.Add(docErrors, err)
+				} else {
+					out <- doc
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			// nothing reads what the command still writes, it would block
+			// on a full pipe and never exit
+			if err != nil {
+				c.Errors.Add(docErrors, fmt.Errorf("failed reading from --transform-cmd: %s", err))
+				cmd.Process.Kill()
+				break
+			}
+		}
+
+		if err := cmd.Wait(); err != nil {
+			c.Errors.Add(docErrors, fmt.Errorf("--transform-cmd failed: %s", err))
+		}
+		<-fed
+		close(out)
+	}()
+
+	return out, nil
+}
+
+// transformedDoc checks a line written by --transform-cmd has everything a
+// worker needs
+func transformedDoc(line []byte) (map[string]interface{}, error) {
+
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(line, &doc); err != nil {
+		return nil, fmt.Errorf("--transform-cmd wrote a bad document: %s", err)
+	}
+
+	_, ok1 := doc["_index"].(string)
+	_, ok2 := doc["_id"].(string)
+	_, ok3 := doc["_source"].(map[string]interface{})
+	if !ok1 || !ok2 || !ok3 {
+		return nil, fmt.Errorf("--transform-cmd wrote a document without _index, _id or _source: %s", line)
+	}
+
+	return withType(doc), nil
+}
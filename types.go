@@ -0,0 +1,114 @@
+package main
+
+import "sort"
+
+// DestType is the _type a document is written with on the destination,
+// empty when --collapse-types drops it or the destination has no types
+func (c *Config) DestType(typ string) string {
+
+	if c.CollapseTypes || c.Typeless {
+		return ""
+	}
+	if name, ok := c.TypeNames[typ]; ok {
+		return name
+	}
+	return typ
+}
+
+// DestRouting is the routing value a document is indexed with
+func (c *Config) DestRouting(routing string) string {
+
+	if c.StripRouting {
+		return ""
+	}
+	if name, ok := c.Routings[routing]; ok {
+		return name
+	}
+	return routing
+}
+
+// RenameTypes moves the mappings of every type under its destination type.
+// Types renamed to the same name have their mappings merged, and an empty
+// name merges them into a typeless mapping
+func (idxs *Indexes) RenameTypes(destType func(string) string) {
+
+	for _, index := range *idxs {
+		index, ok := index.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		mappings, ok := index["mappings"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, typeless := mappings["properties"]; typeless {
+			continue
+		}
+
+		// the first type by name wins when fields clash
+		types := []string{}
+		for name := range mappings {
+			types = append(types, name)
+		}
+		sort.Strings(types)
+
+		renamed := map[string]interface{}{}
+		typeless := map[string]interface{}{}
+		for _, name := range types {
+			mapping, ok := mappings[name].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			newName := destType(name)
+			if newName == "" {
+				mergeMapping(typeless, mapping)
+				continue
+			}
+			if _, ok := renamed[newName]; !ok {
+				renamed[newName] = map[string]interface{}{}
+			}
+			mergeMapping(renamed[newName].(map[string]interface{}), mapping)
+		}
+
+		if len(typeless) > 0 {
+			index["mappings"] = typeless
+		} else {
+			index["mappings"] = renamed
+		}
+	}
+}
+
+// mergeMapping adds the fields of src to dst, fields dst already has are kept
+func mergeMapping(dst, src map[string]interface{}) {
+
+	for key, value := range src {
+		existing, ok := dst[key]
+		if !ok {
+			dst[key] = value
+			continue
+		}
+		d, ok1 := existing.(map[string]interface{})
+		s, ok2 := value.(map[string]interface{})
+		if key == "properties" && ok1 && ok2 {
+			mergeProperties(d, s)
+		}
+	}
+}
+
+// mergeProperties merges objects mapped in both, other fields keep the
+// mapping of dst
+func mergeProperties(dst, src map[string]interface{}) {
+
+	for field, mapping := range src {
+		existing, ok := dst[field]
+		if !ok {
+			dst[field] = mapping
+			continue
+		}
+		d, ok1 := existing.(map[string]interface{})
+		s, ok2 := mapping.(map[string]interface{})
+		if ok1 && ok2 && d["properties"] != nil && s["properties"] != nil {
+			mergeMapping(d, s)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// CheckVerifyCount refuses --verify-count along with flags that leave out
+// documents on purpose, the counts couldn't match
+func (c *Config) CheckVerifyCount() error {
+
+	switch {
+	case c.Src == nil || c.Dst == nil || c.Input != "":
+		return fmt.Errorf("--verify-count only works copying from one es to another")
+	case c.Sample != "" || c.MaxDocs > 0 || c.MaxIndexDocs > 0:
+		return fmt.Errorf("--verify-count can't be used with --sample, --max-docs or --max-index-docs")
+	case c.TransformScript != "" || c.TransformCmd != "" || len(c.Transforms) > 0:
+		return fmt.Errorf("--verify-count can't be used with transforms, they can drop documents")
+	case c.TimeIndex != "" || c.IndexTmpl != nil:
+		return fmt.Errorf("--verify-count can't be used with --time-index or --dest-index-template")
+	}
+	return nil
+}
+
+// CheckVerify refuses --verify along with flags that change or leave out
+// documents on purpose, every one of them would be a mismatch
+func (c *Config) CheckVerify() error {
+
+	if c.Src == nil || c.Dst == nil {
+		return fmt.Errorf("--verify needs both --source and --dest")
+	}
+	changing := []struct {
+		set  bool
+		flag string
+	}{
+		{c.Sample != "", "--sample"},
+		{c.MaxDocs > 0, "--max-docs"},
+		{c.MaxIndexDocs > 0, "--max-index-docs"},
+		{c.TimeIndex != "", "--time-index"},
+		{c.DestIndexTemplate != "", "--dest-index-template"},
+		{len(c.RenameFields) > 0, "--rename-field"},
+		{len(c.DropFields) > 0, "--drop-field"},
+		{len(c.SetFields) > 0, "--set-field"},
+		{c.Mask != "", "--mask"},
+		{c.HashFields != "", "--hash-field"},
+		{c.EncryptFields != "", "--encrypt-field"},
+		{c.DecryptFields != "", "--decrypt-field"},
+		{c.Coerce != "", "--coerce"},
+		{len(c.NormalizeDates) > 0, "--normalize-date"},
+		{c.TransformScript != "", "--transform-script"},
+		{c.TransformCmd != "", "--transform-cmd"},
+		{len(c.Transforms) > 0, "--transform"},
+		{c.IdStrategy != "keep", "--id-strategy"},
+	}
+	for _, u := range changing {
+		if u.set {
+			return fmt.Errorf("%s can't be used with --verify, the documents it changes or leaves out wouldn't match", u.flag)
+		}
+	}
+	return nil
+}
+
+// VerifyCounts compares the number of documents of every source index, with
+// the query of the copy, to its destination index. Indexes renamed to the same
+// destination are added up. Mismatches are printed, false if there were any
+func (c *Config) VerifyCounts(names []string) (bool, error) {
+
+	want := map[string]int{}
+	for _, name := range names {
+		path := "/" + name + "/_count"
+		if c.Types != "" {
+			path = "/" + name + "/" + c.Types + "/_count"
+		}
+		n, err := count(c.Src, path, c.countQuery())
+		if err != nil {
+			return false, err
+		}
+		want[c.DestIndex(name)] += n
+	}
+
+	var dests []string
+	for name := range want {
+		dests = append(dests, name)
+	}
+	sort.Strings(dests)
+
+	// documents are only counted once es refreshed
+	resp, err := c.Dst.Request("POST", "/"+strings.Join(dests, ",")+"/_refresh", nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	ok := true
+	for _, name := range dests {
+		n, err := count(c.Dst, "/"+name+"/_count", nil)
+		if err != nil {
+			return false, err
+		}
+		if n != want[name] {
+			Printf("%s has %d documents, the source had %d\n", name, n, want[name])
+			ok = false
+		}
+	}
+	if ok {
+		Printf("document counts match for %d indexes\n", len(dests))
+	}
+
+	return ok, nil
+}
+
+// countQuery is the query of the scroll without what _count doesn't take
+func (c *Config) countQuery() []byte {
+
+	query := c.ScrollQuery()
+	if query == nil || query["query"] == nil {
+		return nil
+	}
+	b, _ := json.Marshal(map[string]interface{}{"query": query["query"]})
+	return b
+}
+
+func count(host *Host, path string, query []byte) (int, error) {
+
+	var body io.Reader
+	if query != nil {
+		body = bytes.NewReader(query)
+	}
+	resp, err := host.Request("POST", path, body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return 0, fmt.Errorf("failed counting %s: %s", path, string(b))
+	}
+	result := struct {
+		Count int `json:"count"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, err
+	}
+
+	return result.Count, nil
+}
+
+// VerifyContent compares every document of the source indexes to the one
+// with its id on the destination by a hash of _source. The hashes of a source
+// index are kept in memory while its destination is scrolled. Missing,
+// changed and extra documents are printed, false if there were any
+func (c *Config) VerifyContent(idxs Indexes) (bool, error) {
+
+	// indexes renamed to the same destination are compared together
+	sources := map[string][]string{}
+	for name := range idxs {
+		dest := c.DestIndex(name)
+		sources[dest] = append(sources[dest], name)
+	}
+	var dests []string
+	for name := range sources {
+		dests = append(dests, name)
+	}
+	sort.Strings(dests)
+
+	dstScan := scanScroll(c.Dst)
+	ok, compared := true, 0
+	for _, dest := range dests {
+		// by id and source index, sources can have the same ids
+		want := map[string]map[string][sha1.Size]byte{}
+		for _, name := range sources[dest] {
+			err := c.scan(c.Src, c.Scan, name, c.Types, c.countQuery(), func(id string, source json.RawMessage) {
+				if want[id] == nil {
+					want[id] = map[string][sha1.Size]byte{}
+				}
+				want[id][name] = sha1.Sum(source)
+			})
+			if err != nil {
+				return false, err
+			}
+		}
+
+		err := c.scan(c.Dst, dstScan, dest, "", nil, func(id string, source json.RawMessage) {
+			hashes, found := want[id]
+			matched := ""
+			for name, hash := range hashes {
+				if hash == sha1.Sum(source) {
+					matched = name
+				}
+			}
+			switch {
+			case !found:
+				Printf("extra %s/%s\n", dest, id)
+				ok = false
+			case matched == "":
+				Printf("changed %s/%s\n", dest, id)
+				ok = false
+				delete(want, id)
+			default:
+				delete(hashes, matched)
+			}
+			compared++
+		})
+		if err != nil {
+			return false, err
+		}
+
+		// a document of every other source with the id wasn't copied
+		missing := []string{}
+		for id, hashes := range want {
+			for name := range hashes {
+				if len(sources[dest]) > 1 {
+					missing = append(missing, fmt.Sprintf("%s/%s of %s", dest, id, name))
+				} else {
+					missing = append(missing, dest+"/"+id)
+				}
+			}
+		}
+		sort.Strings(missing)
+		for _, doc := range missing {
+			Printf("missing %s\n", doc)
+			ok = false
+		}
+	}
+
+	if ok {
+		Printf("%d documents of %d indexes match\n", compared, len(dests))
+	}
+	return ok, nil
+}
+
+// scan scrolls index on host and calls fn with every document. _source is
+// compacted with its keys sorted so equal documents hash the same on both
+func (c *Config) scan(host *Host, scan bool, index, types string, query []byte, fn func(id string, source json.RawMessage)) error {
+
+	path := c.scrollPath(index, types, scan)
+	if !scan {
+		sorted := map[string]interface{}{}
+		if query != nil {
+			json.Unmarshal(query, &sorted)
+		}
+		sorted["sort"] = []string{"_doc"}
+		query, _ = json.Marshal(sorted)
+	}
+
+	var scrollId string
+	defer func() {
+		if scrollId != "" {
+			clearScroll(host, scrollId)
+		}
+	}()
+
+	for {
+		var body io.Reader
+		if query != nil {
+			body = bytes.NewReader(query)
+		}
+		if scrollId != "" {
+			path, body = c.scrollNextPath(), c.scrollBody(scrollId, scan)
+		}
+		resp, err := host.Request("GET", path, body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != 200 {
+			b, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return fmt.Errorf("failed scrolling %s: %s %s", index, resp.Status, string(b))
+		}
+
+		page := struct {
+			ScrollId string `json:"_scroll_id"`
+			Hits     struct {
+				Hits []struct {
+					Id     string                 `json:"_id"`
+					Source map[string]interface{} `json:"_source"`
+				} `json:"hits"`
+			} `json:"hits"`
+		}{}
+		dec := json.NewDecoder(resp.Body)
+		dec.UseNumber()
+		err = dec.Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		// scan returns no documents with the first response
+		if len(page.Hits.Hits) == 0 && (scrollId != "" || !scan) {
+			return nil
+		}
+		if page.ScrollId == "" {
+			return fmt.Errorf("no scroll id scrolling %s", index)
+		}
+		scrollId = page.ScrollId
+
+		for _, hit := range page.Hits.Hits {
+			source, err := json.Marshal(hit.Source)
+			if err != nil {
+				return err
+			}
+			fn(hit.Id, source)
+		}
+	}
+}
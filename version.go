@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// versions further apart than this can't read each other's mappings
+const maxVersionGap = 2
+
+// versionHints are what changed in es along the way, printed when a copy
+// crosses the version a hint is about
+var versionHints = []struct {
+	Major int
+	Hint  string
+}{
+	{5, "string fields are text or keyword from es 5, es 6 and later reject string mappings"},
+	{6, "indexes have a single type from es 6, use --collapse-types or --rename-type for those with several"},
+	{7, "mappings are typeless from es 7, the mappings of all types are merged into one"},
+	{8, "es 8 rejects _type, documents are written without it"},
+}
+
+// Version is what a host says it runs at its root
+type Version struct {
+	Number       string
+	Distribution string // opensearch, empty for elasticsearch
+}
+
+func (v Version) OpenSearch() bool {
+	return v.Distribution == "opensearch"
+}
+
+func (v Version) String() string {
+	if v.OpenSearch() {
+		return "opensearch " + v.Number
+	}
+	return "es " + v.Number
+}
+
+// Es is the es version the host behaves like. opensearch forked from es
+// 7.10 and kept its apis through 1.x and 2.x
+func (v Version) Es() (major, minor int, err error) {
+
+	if v.OpenSearch() {
+		return 7, 10, nil
+	}
+	if _, err := fmt.Sscanf(v.Number, "%d.%d", &major, &minor); err != nil {
+		return 0, 0, fmt.Errorf("bad es version %s", v.Number)
+	}
+	return major, minor, nil
+}
+
+// Pit tells if the host reads with a point in time, es 7.10 and opensearch
+// 2.4 on
+func (v Version) Pit() bool {
+
+	if v.OpenSearch() {
+		var major, minor int
+		fmt.Sscanf(v.Number, "%d.%d", &major, &minor)
+		return major > 2 || major == 2 && minor >= 4
+	}
+	major, minor, _ := v.Es()
+	return major > 7 || major == 7 && minor >= 10
+}
+
+// CheckVersions refuses copies to an older version or across more than
+// maxVersionGap majors, unless --force-version, and prints what to look out
+// for between the two
+func (c *Config) CheckVersions(src, dst Version) error {
+
+	srcMajor, srcMinor, err := src.Es()
+	if err != nil {
+		return err
+	}
+	dstMajor, _, err := dst.Es()
+	if err != nil {
+		return err
+	}
+
+	for _, h := range versionHints {
+		if srcMajor < h.Major && dstMajor >= h.Major {
+			Printf("copying from %s to %s: %s\n", src, dst, h.Hint)
+		}
+	}
+	switch {
+	case dst.OpenSearch() && !src.OpenSearch() && (srcMajor > 7 || srcMajor == 7 && srcMinor > 10):
+		Printf("copying from %s to %s: opensearch forked from es 7.10, field types and settings es added later aren't there\n", src, dst)
+	case src.OpenSearch() && !dst.OpenSearch():
+		Printf("copying from %s to %s: opensearch plugin field types and settings, like knn_vector or plugins.*, aren't in es\n", src, dst)
+	}
+
+	// opensearch reads the mappings of es 8 well enough, though it counts
+	// as 7.10
+	var problem error
+	switch {
+	case dstMajor < srcMajor && !dst.OpenSearch():
+		problem = fmt.Errorf("copying from %s to the older %s, its mappings and settings may not be understood", src, dst)
+	case dstMajor-srcMajor > maxVersionGap:
+		problem = fmt.Errorf("copying from %s to %s is too big a jump for mappings to work", src, dst)
+	}
+	if problem != nil && c.ForceVersion {
+		Println(problem)
+		return nil
+	}
+	return problem
+}
+
+// hostInfo is the version of host, empty when it doesn't say
+func hostInfo(host *Host) Version {
+
+	version, err := checkHost(host, "host")
+	if err != nil {
+		return Version{}
+	}
+	return version
+}
+
+// hostVersion is the major and minor es version host behaves like, zero
+// when it doesn't say
+func hostVersion(host *Host) (major, minor int) {
+
+	major, minor, _ = hostInfo(host).Es()
+	return major, minor
+}
+
+// dropEsHeaders removes the elasticsearch compatibility headers, like
+// compatible-with=7 for es 8, which opensearch answers 406 to
+func dropEsHeaders(host *Host, role string) {
+
+	for _, name := range []string{"Accept", "Content-Type"} {
+		for _, val := range host.Headers[name] {
+			if strings.Contains(val, "vnd.elasticsearch") {
+				Printf("the %s is opensearch, not sending %s: %s\n", role, name, val)
+				host.Headers.Del(name)
+				break
+			}
+		}
+	}
+}
+
+// scanScroll tells if host still scrolls with search_type=scan, before es
+// 2.1. Hosts that don't say are taken to
+func scanScroll(host *Host) bool {
+	return scanVersion(hostVersion(host))
+}
+
+func scanVersion(major, minor int) bool {
+	return major < 2 || major == 2 && minor < 1
+}
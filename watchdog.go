@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// stopping takes this long at most once the watchdog gave up, requests
+// that hang without a timeout would keep it running forever otherwise
+const watchdogGrace = time.Minute
+
+// StartWatchdog stops reading when no document was taken by a worker or
+// flushed for timeout, ie a scroll or bulk request that never returns. The
+// documents read so far are still indexed, and --state has the indexes done
+// by the last checkpoint for --resume. If stopping hangs as well it quits
+func (c *Config) StartWatchdog(timeout time.Duration) {
+
+	if timeout <= 0 {
+		return
+	}
+	c.Progressed()
+
+	check := timeout / 10
+	if check < time.Second {
+		check = time.Second
+	}
+	go func() {
+		for range time.Tick(check) {
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&c.progress)))
+			if idle < timeout {
+				continue
+			}
+			c.Abort(fmt.Errorf("no documents read or flushed for %s, stopping", timeout))
+			time.Sleep(watchdogGrace)
+			Println("stopping is stuck as well, quitting")
+			os.Exit(exitPartial)
+		}
+	}()
+}
+
+// Progressed tells the watchdog documents are moving
+func (c *Config) Progressed() {
+	atomic.StoreInt64(&c.progress, time.Now().UnixNano())
+}
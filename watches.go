@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Watch is a watcher watch as the get and query watches apis return it
+type Watch struct {
+	Id     string                 `json:"_id"`
+	Found  bool                   `json:"found"`
+	Watch  map[string]interface{} `json:"watch"`
+	Status struct {
+		State struct {
+			Active *bool `json:"active"`
+		} `json:"state"`
+	} `json:"status"`
+}
+
+// CreateWatches copies the watcher watches of the source through the watcher
+// api rather than the .watches index, active or not as they are there. It
+// runs once the documents are copied so watches don't alert on a half empty
+// destination. Watches the destination has already are kept unless --force
+func (c *Config) CreateWatches() error {
+
+	src, dst := hostInfo(c.Src), hostInfo(c.Dst)
+	if src.OpenSearch() || dst.OpenSearch() {
+		return fmt.Errorf("opensearch has no watcher, watches can't be copied from or to it")
+	}
+	watches, err := c.sourceWatches(src)
+	if err != nil {
+		return err
+	}
+
+	api := watcherApi(dst)
+	copied := 0
+	for _, watch := range watches {
+		if !c.Destructive {
+			existing := Watch{}
+			if err := getJSON(c.Dst, api+"/watch/"+url.PathEscape(watch.Id), &existing); err == nil && existing.Found {
+				Println("keeping existing watch: ", watch.Id)
+				continue
+			}
+		}
+
+		body, err := json.Marshal(watch.Watch)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(body), "::es_redacted::") {
+			Printf("watch %s has secrets the source doesn't show, set them again on the destination\n", watch.Id)
+		}
+		active := watch.Status.State.Active == nil || *watch.Status.State.Active
+		resp, err := c.Dst.Request("PUT", fmt.Sprintf("%s/watch/%s?active=%t", api, url.PathEscape(watch.Id), active), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != 200 && resp.StatusCode != 201 {
+			return fmt.Errorf("failed creating watch %s: %s", watch.Id, string(b))
+		}
+		copied++
+	}
+	Printf("copied %d watches\n", copied)
+	return nil
+}
+
+// sourceWatches lists the watches of the source by id. es 7.11 and later
+// have an api for it, before that the ids are read from .watches
+func (c *Config) sourceWatches(src Version) ([]Watch, error) {
+
+	var watches []Watch
+	major, minor, _ := src.Es()
+	if major > 7 || major == 7 && minor >= 11 {
+		for {
+			body, _ := json.Marshal(map[string]interface{}{"from": len(watches), "size": 100})
+			resp, err := c.Src.Request("POST", "/_watcher/_query/watches", bytes.NewReader(body))
+			if err != nil {
+				return nil, err
+			}
+			page := struct {
+				Count   int     `json:"count"`
+				Watches []Watch `json:"watches"`
+			}{}
+			if resp.StatusCode != 200 {
+				b, _ := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				return nil, fmt.Errorf("failed listing watches: %s", string(b))
+			}
+			err = json.NewDecoder(resp.Body).Decode(&page)
+			resp.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			watches = append(watches, page.Watches...)
+			if len(page.Watches) == 0 || len(watches) >= page.Count {
+				return watches, nil
+			}
+		}
+	}
+
+	resp, err := c.Src.Request("GET", "/.watches/_search?size=10000&_source=false&filter_path=hits.hits._id", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+	found := struct {
+		Hits struct {
+			Hits []struct {
+				Id string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}{}
+	if resp.StatusCode != 200 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed listing watches: %s", string(b))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, hit := range found.Hits.Hits {
+		ids = append(ids, hit.Id)
+	}
+	sort.Strings(ids)
+	api := watcherApi(src)
+	for _, id := range ids {
+		watch := Watch{}
+		if err := getJSON(c.Src, api+"/watch/"+url.PathEscape(id), &watch); err != nil {
+			return nil, err
+		}
+		watch.Id = id
+		watches = append(watches, watch)
+	}
+	return watches, nil
+}
+
+// watcherApi is where watcher lives, under _xpack on es 5 and 6
+func watcherApi(version Version) string {
+
+	if major, _, _ := version.Es(); major == 5 || major == 6 {
+		return "/_xpack/watcher"
+	}
+	return "/_watcher"
+}